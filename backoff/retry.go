@@ -1,6 +1,7 @@
 package backoff
 
 import (
+	"context"
 	"time"
 
 	"github.com/utilitywarehouse/semaphore-service-mirror/log"
@@ -15,30 +16,41 @@ const (
 )
 
 // Retry will use the default backoff values to retry the passed operation
-func Retry(op operation, description string) {
+// until it succeeds or the context is cancelled
+func Retry(ctx context.Context, op operation, description string) {
 	b := &Backoff{
 		Jitter: defaultBackoffJitter,
 		Min:    defaultBackoffMin,
 		Max:    defaultBackoffMax,
 	}
-	RetryWithBackoff(op, b, description)
+	RetryWithBackoff(ctx, op, b, description)
 }
 
 // RetryWithBackoff will retry the passed function (operation) using the given
-// backoff
-func RetryWithBackoff(op operation, b *Backoff, description string) {
+// backoff until it succeeds or the context is cancelled
+func RetryWithBackoff(ctx context.Context, op operation, b *Backoff, description string) {
 	b.Reset()
 	for {
 		err := op()
 		if err == nil {
 			return
 		}
+		if ctx.Err() != nil {
+			log.Logger.Info("Retry cancelled",
+				"description", description,
+				"error", err,
+			)
+			return
+		}
 		d := b.Duration()
 		log.Logger.Error("Retry failed",
 			"description", description,
 			"error", err,
 			"backoff", d,
 		)
-		time.Sleep(d)
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
 	}
 }
@@ -1,6 +1,7 @@
 package backoff
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -33,7 +34,27 @@ func TestRetryWithBackoff(t *testing.T) {
 
 	// Retrying testFunc should fail 2 times before hitting the success
 	// threshold
-	RetryWithBackoff(testFunc, b, "test func")
+	RetryWithBackoff(context.Background(), testFunc, b, "test func")
 	assert.Equal(t, testFuncCallCounter, 3)            // should be 3 after 2 consecutive fails
 	assert.Equal(t, b.Duration(), 40*time.Millisecond) // should be 40 millisec after failing for 10 and 20 and without a jitter
 }
+
+func TestRetryWithBackoff_Cancelled(t *testing.T) {
+	log.InitLogger("retry-test", "info")
+	b := &Backoff{
+		Jitter: false,
+		Min:    1 * time.Minute,
+		Max:    1 * time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	op := func() error {
+		calls++
+		cancel()
+		return errors.New("error")
+	}
+	// Retrying should stop without waiting for the backoff once the
+	// context is cancelled
+	RetryWithBackoff(ctx, op, b, "cancelled func")
+	assert.Equal(t, 1, calls)
+}
@@ -3,12 +3,26 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
 )
 
 const (
 	defaultWGDeviceMTU  = 1420
 	defaultWGListenPort = 51820
+
+	defaultLeaseName     = "semaphore-service-mirror"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+
+	defaultGCGracePeriod = 5 * time.Minute
+
+	defaultDiscoveryLabelSelector = "semaphore-service-mirror/remote-cluster=true"
+
+	defaultHealthErrorThreshold = 5
 )
 
 // Duration is a helper to unmarshal time.Duration from json
@@ -46,12 +60,74 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 
 // globalConfig will keep configuration that applies globally on the operator
 type globalConfig struct {
-	GlobalSvcLabelSelector        string `json:"globalSvcLabelSelector"`        // Label used to select global services to mirror
-	GlobalSvcRoutingStrategyLabel string `json:"globalSvcRoutingStrategyLabel"` // Label used to enable topology aware hints for global services
-	MirrorSvcLabelSelector        string `json:"mirrorSvcLabelSelector"`        // Label used to select remote services to mirror
-	MirrorNamespace               string `json:"mirrorNamespace"`               // Local namespace to mirror remote services
-	ServiceSync                   bool   `json:"serviceSync"`                   // sync services on startup
-	EndpointSliceSync             bool   `json:"endpointSliceSync"`             // sync endpointslices (for global services) at startup
+	GlobalSvcLabelSelector        string               `json:"globalSvcLabelSelector"`        // Label used to select global services to mirror
+	GlobalSvcRoutingStrategyLabel string               `json:"globalSvcRoutingStrategyLabel"` // Label used to enable topology aware hints for global services
+	MirrorSvcLabelSelector        string               `json:"mirrorSvcLabelSelector"`        // Label used to select remote services to mirror
+	MirrorNamespace               string               `json:"mirrorNamespace"`               // Local namespace to mirror remote services
+	ServiceSync                   bool                 `json:"serviceSync"`                   // sync services on startup
+	EndpointSliceSync             bool                 `json:"endpointSliceSync"`             // sync endpointslices (for global services) at startup
+	LeaderElection                leaderElectionConfig `json:"leaderElection"`                // Lease based leader election between replicas
+	GlobalSvcMergePolicy          mergePolicy          `json:"globalSvcMergePolicy"`          // How to merge differing definitions of a global service across clusters
+	GlobalSvcHeadlessPolicy       headlessPolicy       `json:"globalSvcHeadlessPolicy"`       // Whether global services are headless
+	GCInterval                    Duration             `json:"gcInterval"`                    // How often to delete orphaned mirrors, 0 disables garbage collection
+	GCGracePeriod                 Duration             `json:"gcGracePeriod"`                 // How long a mirror should be orphaned before it is deleted
+	RemoteClusterDiscovery        discoveryConfig      `json:"remoteClusterDiscovery"`        // Discover remote clusters from secrets
+	Health                        healthConfig         `json:"health"`                        // How the connectivity to clusters affects the health checks
+	Propagation                   propagationConfig    `json:"propagation"`                   // Labels and annotations to copy from remote services of all clusters
+	MultiClusterServices          mcsConfig            `json:"multiClusterServices"`          // Select global services with ServiceExports and create ServiceImports
+	StatusResources               statusConfig         `json:"statusResources"`               // Report the state of mirrored and global services in status resources
+	ServiceFinalizer              finalizerConfig      `json:"serviceFinalizer"`              // Clean up after mirrored and global services before they are deleted
+}
+
+// finalizerConfig enables the finalizer of mirrored and global services, which
+// lets the controller delete their endpoints, endpointslices and status
+// resources before the services are gone
+type finalizerConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// statusConfig enables the MirroredService and GlobalService status resources,
+// which report the state of mirrored and global services in the mirror
+// namespace
+type statusConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// mcsConfig configures the Multi-Cluster Services API mode, where global
+// services are selected by ServiceExport objects instead of the global service
+// label and ServiceImport objects are created for them in the local cluster
+type mcsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// healthConfig configures how the connectivity to the local and remote
+// clusters is reflected in the health checks of the operator
+type healthConfig struct {
+	Policy         healthPolicy `json:"policy"`         // Whether unhealthy clusters fail liveness or only readiness
+	ErrorThreshold int          `json:"errorThreshold"` // Consecutive failed requests after which a cluster is unhealthy
+}
+
+// discoveryConfig configures the discovery of remote clusters from labelled
+// secrets, in addition to the remote clusters listed in the config file
+type discoveryConfig struct {
+	Enabled       bool   `json:"enabled"`
+	LabelSelector string `json:"labelSelector"` // Label of the secrets that describe remote clusters
+	Namespace     string `json:"namespace"`     // Namespace of the secrets, defaults to the mirror namespace
+	// Allow kubeconfigs in secrets to use exec credential plugins and auth
+	// providers, which run commands in the operator's pod
+	AllowCredentialPlugins bool `json:"allowCredentialPlugins"`
+}
+
+// leaderElectionConfig configures leader election between multiple replicas of
+// the operator. Only the leader reconciles objects in the local cluster.
+type leaderElectionConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Identity       string   `json:"identity"`       // Identity of the replica in the lease, defaults to the hostname
+	LeaseName      string   `json:"leaseName"`      // Name of the lease object
+	LeaseNamespace string   `json:"leaseNamespace"` // Namespace of the lease object, defaults to the mirror namespace
+	LeaseDuration  Duration `json:"leaseDuration"`  // How long non-leaders wait before trying to acquire the lease
+	RenewDeadline  Duration `json:"renewDeadline"`  // How long the leader retries renewing the lease before giving up
+	RetryPeriod    Duration `json:"retryPeriod"`    // How often to retry acquiring or renewing the lease
 }
 
 type localClusterConfig struct {
@@ -61,13 +137,27 @@ type localClusterConfig struct {
 }
 
 type remoteClusterConfig struct {
-	Name              string   `json:"name"`
-	KubeConfigPath    string   `json:"kubeConfigPath"`
-	RemoteAPIURL      string   `json:"remoteAPIURL"`
-	RemoteCAURL       string   `json:"remoteCAURL"`
-	RemoteSATokenPath string   `json:"remoteSATokenPath"`
-	ResyncPeriod      Duration `json:"resyncPeriod"`
-	ServicePrefix     string   `json:"servicePrefix"` // How to prefix services mirrored from this cluster locally
+	Name                      string              `json:"name"`
+	KubeConfigPath            string              `json:"kubeConfigPath"`
+	RemoteAPIURL              string              `json:"remoteAPIURL"`
+	RemoteCAURL               string              `json:"remoteCAURL"`
+	RemoteCAFile              string              `json:"remoteCAFile"`      // Path to a PEM CA bundle, instead of remoteCAURL
+	RemoteCAData              string              `json:"remoteCAData"`      // Inline PEM CA bundle, instead of remoteCAURL
+	CARefreshInterval         Duration            `json:"caRefreshInterval"` // How often to refresh the CA bundle from remoteCAURL or remoteCAFile
+	RemoteSATokenPath         string              `json:"remoteSATokenPath"`
+	ResyncPeriod              Duration            `json:"resyncPeriod"`
+	ServicePrefix             string              `json:"servicePrefix"`             // How to prefix services mirrored from this cluster locally
+	MirrorEndpointSlices      bool                `json:"mirrorEndpointSlices"`      // Mirror endpointslices instead of endpoints
+	MirrorLoadBalancerIngress bool                `json:"mirrorLoadBalancerIngress"` // Point mirrors of LoadBalancer services at the load balancer instead of the pods
+	IncludeNamespaces         []string            `json:"includeNamespaces"`         // Names or glob patterns of namespaces to mirror, all when empty
+	ExcludeNamespaces         []string            `json:"excludeNamespaces"`         // Names or glob patterns of namespaces never to mirror
+	AuthMethod                string              `json:"authMethod"`                // One of token, kubeconfig, exec or oidc, guessed from the rest of the config when empty
+	StaleEndpointPolicy       staleEndpointPolicy `json:"staleEndpointPolicy"`       // What to do with mirrored endpoints while the cluster is unreachable
+	StaleEndpointTimeout      Duration            `json:"staleEndpointTimeout"`      // How long the cluster must be unreachable before applying the policy
+	Propagation               propagationConfig   `json:"propagation"`               // Labels and annotations to copy from remote services, in addition to the global ones
+	// Credentials of clusters discovered from secrets, instead of paths
+	KubeConfigData []byte `json:"-"`
+	RemoteSAToken  string `json:"-"`
 }
 
 // Config holds the application configuration
@@ -92,8 +182,8 @@ func parseConfig(rawConfig []byte, flagGlobalSvcLabelSelector, flagGlobalSvcRout
 	if flagGlobalSvcLabelSelector != "" {
 		conf.Global.GlobalSvcLabelSelector = flagGlobalSvcLabelSelector
 	}
-	if conf.Global.GlobalSvcLabelSelector == "" {
-		return nil, fmt.Errorf("Label selector for global services should be specified either via global json config, env vars or flag")
+	if conf.Global.GlobalSvcLabelSelector == "" && !conf.Global.MultiClusterServices.Enabled {
+		return nil, fmt.Errorf("Label selector for global services should be specified either via global json config, env vars or flag, unless multiClusterServices is enabled")
 	}
 	if flagGlobalSvcRoutingStrategyLabel != "" {
 		conf.Global.GlobalSvcRoutingStrategyLabel = flagGlobalSvcRoutingStrategyLabel
@@ -107,6 +197,41 @@ func parseConfig(rawConfig []byte, flagGlobalSvcLabelSelector, flagGlobalSvcRout
 	if conf.Global.MirrorNamespace == "" {
 		return nil, fmt.Errorf("Local mirroring namespace should be specified either via global json config, env vars or flag")
 	}
+	if conf.Global.GlobalSvcMergePolicy == "" {
+		conf.Global.GlobalSvcMergePolicy = mergePolicyLatest
+	}
+	if !conf.Global.GlobalSvcMergePolicy.valid() {
+		return nil, fmt.Errorf("Invalid global service merge policy: %s", conf.Global.GlobalSvcMergePolicy)
+	}
+	if conf.Global.GlobalSvcHeadlessPolicy == "" {
+		conf.Global.GlobalSvcHeadlessPolicy = headlessPolicyReference
+	}
+	if !conf.Global.GlobalSvcHeadlessPolicy.valid() {
+		return nil, fmt.Errorf("Invalid global service headless policy: %s", conf.Global.GlobalSvcHeadlessPolicy)
+	}
+	if conf.Global.GCGracePeriod.Duration == 0 {
+		conf.Global.GCGracePeriod = Duration{defaultGCGracePeriod}
+	}
+	if conf.Global.Health.Policy == "" {
+		conf.Global.Health.Policy = healthPolicyReadiness
+	}
+	if !conf.Global.Health.Policy.valid() {
+		return nil, fmt.Errorf("Invalid health policy: %s", conf.Global.Health.Policy)
+	}
+	if conf.Global.Health.ErrorThreshold == 0 {
+		conf.Global.Health.ErrorThreshold = defaultHealthErrorThreshold
+	}
+	if conf.Global.Health.ErrorThreshold < 0 {
+		return nil, fmt.Errorf("Health error threshold cannot be negative")
+	}
+	if err := validatePropagationConfig(conf.Global.Propagation); err != nil {
+		return nil, fmt.Errorf("Invalid global propagation config: %v", err)
+	}
+	if conf.Global.LeaderElection.Enabled {
+		if err := setLeaderElectionDefaults(&conf.Global.LeaderElection, conf.Global.MirrorNamespace); err != nil {
+			return nil, err
+		}
+	}
 	if conf.LocalCluster.Name == "" {
 		return nil, fmt.Errorf("Configuration is missing local cluster name")
 	}
@@ -115,20 +240,99 @@ func parseConfig(rawConfig []byte, flagGlobalSvcLabelSelector, flagGlobalSvcRout
 		conf.LocalCluster.Zones = []string{"local"}
 	}
 
-	// Check for mandatory remote config.
-	if len(conf.RemoteClusters) < 1 {
+	if conf.Global.RemoteClusterDiscovery.Enabled {
+		if conf.Global.RemoteClusterDiscovery.LabelSelector == "" {
+			conf.Global.RemoteClusterDiscovery.LabelSelector = defaultDiscoveryLabelSelector
+		}
+		if conf.Global.RemoteClusterDiscovery.Namespace == "" {
+			conf.Global.RemoteClusterDiscovery.Namespace = conf.Global.MirrorNamespace
+		}
+	}
+
+	// Check for mandatory remote config. Remote clusters may all be
+	// discovered from secrets.
+	if len(conf.RemoteClusters) < 1 && !conf.Global.RemoteClusterDiscovery.Enabled {
 		return nil, fmt.Errorf("No remote cluster configuration defined")
 	}
 	for _, r := range conf.RemoteClusters {
-		if r.Name == "" {
-			return nil, fmt.Errorf("Configuration is missing remote cluster name")
+		if err := validateRemoteClusterConfig(r); err != nil {
+			return nil, err
 		}
-		if (r.RemoteAPIURL == "" || r.RemoteCAURL == "" || r.RemoteSATokenPath == "") && r.KubeConfigPath == "" {
-			return nil, fmt.Errorf("Insufficient configuration to create remote cluster client. Set kubeConfigPath or remoteAPIURL and remoteCAURL and remoteSATokenPath")
+	}
+	return conf, nil
+}
+
+// validateRemoteClusterConfig checks that the remote cluster configuration is
+// complete
+func validateRemoteClusterConfig(r *remoteClusterConfig) error {
+	if r.Name == "" {
+		return fmt.Errorf("Configuration is missing remote cluster name")
+	}
+	hasCA := r.RemoteCAURL != "" || r.RemoteCAFile != "" || r.RemoteCAData != ""
+	hasToken := r.RemoteAPIURL != "" && hasCA && (r.RemoteSATokenPath != "" || r.RemoteSAToken != "")
+	hasKubeConfig := r.KubeConfigPath != "" || len(r.KubeConfigData) > 0
+	switch r.AuthMethod {
+	case "":
+		if !hasToken && !hasKubeConfig {
+			return fmt.Errorf("Insufficient configuration to create remote cluster client. Set kubeConfigPath or remoteAPIURL and remoteCAURL (or remoteCAFile or remoteCAData) and remoteSATokenPath")
+		}
+	case kube.AuthMethodToken:
+		if !hasToken {
+			return fmt.Errorf("Auth method %s requires remoteAPIURL, remoteCAURL (or remoteCAFile or remoteCAData) and remoteSATokenPath", r.AuthMethod)
 		}
-		if r.ServicePrefix == "" {
-			return nil, fmt.Errorf("Configuration is missing a service prefix for services mirrored from the remote")
+	case kube.AuthMethodKubeConfig, kube.AuthMethodExec, kube.AuthMethodOIDC:
+		if !hasKubeConfig {
+			return fmt.Errorf("Auth method %s requires kubeConfigPath", r.AuthMethod)
 		}
+	default:
+		return fmt.Errorf("Invalid auth method for remote cluster %s: %s", r.Name, r.AuthMethod)
 	}
-	return conf, nil
+	if r.ServicePrefix == "" {
+		return fmt.Errorf("Configuration is missing a service prefix for services mirrored from the remote")
+	}
+	if r.StaleEndpointPolicy != "" && !r.StaleEndpointPolicy.valid() {
+		return fmt.Errorf("Invalid stale endpoint policy for remote cluster %s: %s", r.Name, r.StaleEndpointPolicy)
+	}
+	if r.StaleEndpointTimeout.Duration < 0 {
+		return fmt.Errorf("Stale endpoint timeout for remote cluster %s cannot be negative", r.Name)
+	}
+	if err := validatePropagationConfig(r.Propagation); err != nil {
+		return fmt.Errorf("Invalid propagation config for remote cluster %s: %v", r.Name, err)
+	}
+	if err := validateNamespacePatterns(r.IncludeNamespaces); err != nil {
+		return fmt.Errorf("Invalid includeNamespaces for remote cluster %s: %v", r.Name, err)
+	}
+	if err := validateNamespacePatterns(r.ExcludeNamespaces); err != nil {
+		return fmt.Errorf("Invalid excludeNamespaces for remote cluster %s: %v", r.Name, err)
+	}
+	return nil
+}
+
+func setLeaderElectionDefaults(le *leaderElectionConfig, namespace string) error {
+	if le.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("Cannot get hostname to use as leader election identity: %v", err)
+		}
+		le.Identity = hostname
+	}
+	if le.LeaseName == "" {
+		le.LeaseName = defaultLeaseName
+	}
+	if le.LeaseNamespace == "" {
+		le.LeaseNamespace = namespace
+	}
+	if le.LeaseDuration.Duration == 0 {
+		le.LeaseDuration.Duration = defaultLeaseDuration
+	}
+	if le.RenewDeadline.Duration == 0 {
+		le.RenewDeadline.Duration = defaultRenewDeadline
+	}
+	if le.RetryPeriod.Duration == 0 {
+		le.RetryPeriod.Duration = defaultRetryPeriod
+	}
+	if le.LeaseDuration.Duration <= le.RenewDeadline.Duration {
+		return fmt.Errorf("Leader election lease duration should be greater than renew deadline")
+	}
+	return nil
 }
@@ -65,7 +65,7 @@ func TestConfig(t *testing.T) {
 }
 `)
 	_, err = parseConfig(insufficientRemoteKubeConfigPath, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
-	assert.Equal(t, fmt.Errorf("Insufficient configuration to create remote cluster client. Set kubeConfigPath or remoteAPIURL and remoteCAURL and remoteSATokenPath"), err)
+	assert.Equal(t, fmt.Errorf("Insufficient configuration to create remote cluster client. Set kubeConfigPath or remoteAPIURL and remoteCAURL (or remoteCAFile or remoteCAData) and remoteSATokenPath"), err)
 
 	rawFullConfig := []byte(`
 {
@@ -122,3 +122,487 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, "cluster-2", config.RemoteClusters[1].ServicePrefix)
 
 }
+
+func TestConfig_LeaderElection(t *testing.T) {
+	leaderElectionConfig := []byte(`
+{
+  "global": {
+    "globalSvcLabelSelector": "globalLabel",
+    "globalSvcRoutingStrategyLabel": "globalTopologyLabel",
+    "mirrorSvcLabelSelector": "mirrorLabel",
+    "mirrorNamespace": "sys-semaphore",
+    "leaderElection": {
+      "enabled": true,
+      "identity": "replica-1",
+      "leaseDuration": "30s"
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(leaderElectionConfig, "", "", "", "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, config.Global.LeaderElection.Enabled)
+	assert.Equal(t, "replica-1", config.Global.LeaderElection.Identity)
+	assert.Equal(t, defaultLeaseName, config.Global.LeaderElection.LeaseName)
+	assert.Equal(t, "sys-semaphore", config.Global.LeaderElection.LeaseNamespace)
+	assert.Equal(t, Duration{30 * time.Second}, config.Global.LeaderElection.LeaseDuration)
+	assert.Equal(t, Duration{defaultRenewDeadline}, config.Global.LeaderElection.RenewDeadline)
+	assert.Equal(t, Duration{defaultRetryPeriod}, config.Global.LeaderElection.RetryPeriod)
+
+	invalidLeaderElectionConfig := []byte(`
+{
+  "global": {
+    "leaderElection": {
+      "enabled": true,
+      "leaseDuration": "5s",
+      "renewDeadline": "10s"
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidLeaderElectionConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, fmt.Errorf("Leader election lease duration should be greater than renew deadline"), err)
+}
+
+func TestConfig_GlobalSvcMergePolicy(t *testing.T) {
+	defaultMergePolicyConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(defaultMergePolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	// Defaults to the latest service winning, as before merge policies
+	assert.Equal(t, mergePolicyLatest, config.Global.GlobalSvcMergePolicy)
+
+	mergePolicyConfig := []byte(`
+{
+  "global": {
+    "globalSvcMergePolicy": "oldest"
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	config, err = parseConfig(mergePolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mergePolicyOldest, config.Global.GlobalSvcMergePolicy)
+
+	invalidMergePolicyConfig := []byte(`
+{
+  "global": {
+    "globalSvcMergePolicy": "newest"
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidMergePolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, fmt.Errorf("Invalid global service merge policy: newest"), err)
+}
+
+func TestConfig_GlobalSvcHeadlessPolicy(t *testing.T) {
+	headlessPolicyConfig := []byte(`
+{
+  "global": {
+    "globalSvcHeadlessPolicy": "headless"
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(headlessPolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, headlessPolicyHeadless, config.Global.GlobalSvcHeadlessPolicy)
+
+	invalidHeadlessPolicyConfig := []byte(`
+{
+  "global": {
+    "globalSvcHeadlessPolicy": "any"
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidHeadlessPolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, fmt.Errorf("Invalid global service headless policy: any"), err)
+}
+
+func TestConfig_MultiClusterServices(t *testing.T) {
+	mcsConfig := []byte(`
+{
+  "global": {
+    "multiClusterServices": {
+      "enabled": true
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	// The global service label selector is not needed, exported services
+	// are selected by ServiceExports
+	config, err := parseConfig(mcsConfig, "", testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, config.Global.MultiClusterServices.Enabled)
+	assert.Equal(t, "", config.Global.GlobalSvcLabelSelector)
+}
+
+func TestConfig_Health(t *testing.T) {
+	healthConfig := []byte(`
+{
+  "global": {
+    "health": {
+      "policy": "liveness",
+      "errorThreshold": 10
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(healthConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, healthPolicyLiveness, config.Global.Health.Policy)
+	assert.Equal(t, 10, config.Global.Health.ErrorThreshold)
+
+	invalidHealthPolicyConfig := []byte(`
+{
+  "global": {
+    "health": {
+      "policy": "restart"
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidHealthPolicyConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, fmt.Errorf("Invalid health policy: restart"), err)
+}
+
+func TestConfig_StaleEndpoints(t *testing.T) {
+	staleEndpointsConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "staleEndpointPolicy": "notReady",
+      "staleEndpointTimeout": "2m"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(staleEndpointsConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, staleEndpointPolicyNotReady, config.RemoteClusters[0].StaleEndpointPolicy)
+	assert.Equal(t, 2*time.Minute, config.RemoteClusters[0].StaleEndpointTimeout.Duration)
+
+	invalidStaleEndpointsConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "staleEndpointPolicy": "delete"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidStaleEndpointsConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, fmt.Errorf("Invalid stale endpoint policy for remote cluster remote_cluster_1: delete"), err)
+}
+
+func TestConfig_Namespaces(t *testing.T) {
+	namespacesConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "includeNamespaces": ["team-*"],
+      "excludeNamespaces": ["team-secret"]
+    }
+  ]
+}
+`)
+	config, err := parseConfig(namespacesConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"team-*"}, config.RemoteClusters[0].IncludeNamespaces)
+	assert.Equal(t, []string{"team-secret"}, config.RemoteClusters[0].ExcludeNamespaces)
+
+	invalidNamespacesConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "excludeNamespaces": ["team-["]
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidNamespacesConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestConfig_AuthMethod(t *testing.T) {
+	authMethodConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "authMethod": "exec"
+    },
+    {
+      "name": "remote_cluster_2",
+      "remoteAPIURL": "remote_api_url",
+      "remoteCAURL": "remote_ca_url",
+      "remoteSATokenPath": "/path/to/token",
+      "servicePrefix": "cluster-2",
+      "authMethod": "token"
+    }
+  ]
+}
+`)
+	config, err := parseConfig(authMethodConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "exec", config.RemoteClusters[0].AuthMethod)
+	assert.Equal(t, "token", config.RemoteClusters[1].AuthMethod)
+
+	// The token auth method needs a token
+	tokenWithoutTokenConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "authMethod": "token"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(tokenWithoutTokenConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.NotEqual(t, nil, err)
+
+	// Exec credentials come from a kubeconfig
+	execWithoutKubeConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "remoteAPIURL": "remote_api_url",
+      "remoteCAURL": "remote_ca_url",
+      "remoteSATokenPath": "/path/to/token",
+      "servicePrefix": "cluster-1",
+      "authMethod": "exec"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(execWithoutKubeConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.NotEqual(t, nil, err)
+
+	invalidAuthMethodConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "authMethod": "password"
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidAuthMethodConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestConfig_RemoteClusterDiscovery(t *testing.T) {
+	discoveryConfig := []byte(`
+{
+  "global": {
+    "remoteClusterDiscovery": {
+      "enabled": true
+    }
+  },
+  "localCluster": {
+    "name": "local_cluster"
+  }
+}
+`)
+	config, err := parseConfig(discoveryConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(config.RemoteClusters))
+	assert.Equal(t, defaultDiscoveryLabelSelector, config.Global.RemoteClusterDiscovery.LabelSelector)
+	assert.Equal(t, testFlagMirrorNamespace, config.Global.RemoteClusterDiscovery.Namespace)
+}
+
+func TestConfig_Propagation(t *testing.T) {
+	propagationConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "global": {
+    "propagation": {
+      "labels": ["team"],
+      "annotations": ["example\\.com/.*"]
+    }
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "propagation": {
+        "labels": ["app"]
+      }
+    }
+  ]
+}
+`)
+	config, err := parseConfig(propagationConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"team"}, config.Global.Propagation.Labels)
+	assert.Equal(t, []string{"example\\.com/.*"}, config.Global.Propagation.Annotations)
+	assert.Equal(t, []string{"app"}, config.RemoteClusters[0].Propagation.Labels)
+
+	invalidPropagationConfig := []byte(`
+{
+  "localCluster": {
+    "name": "local_cluster"
+  },
+  "remoteClusters": [
+    {
+      "name": "remote_cluster_1",
+      "kubeConfigPath": "/path/to/kube/config",
+      "servicePrefix": "cluster-1",
+      "propagation": {
+        "labels": ["("]
+      }
+    }
+  ]
+}
+`)
+	_, err = parseConfig(invalidPropagationConfig, testFlagGlobalSvcLabelSelector, testFlagGlobalSvcTopologyLabel, testFlagMirrorSvcLabelSelector, testFlagMirrorNamespace)
+	assert.NotEqual(t, nil, err)
+}
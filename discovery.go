@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/backoff"
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+const (
+	// Annotations of remote cluster secrets
+	remoteClusterNameAnno          = "semaphore-service-mirror/name"
+	remoteClusterServicePrefixAnno = "semaphore-service-mirror/service-prefix"
+	remoteClusterConfigAnno        = "semaphore-service-mirror/config" // Optional JSON with the rest of the remote cluster configuration
+
+	// Data keys of remote cluster secrets
+	remoteClusterKubeConfigKey = "kubeconfig"
+	remoteClusterAPIURLKey     = "apiURL"
+	remoteClusterTokenKey      = "token"
+	remoteClusterCAKey         = "ca.crt"
+	remoteClusterCAURLKey      = "caURL"
+)
+
+// remoteClusterDiscovery watches secrets that describe remote clusters and
+// applies the discovered clusters on every change
+type remoteClusterDiscovery struct {
+	mu            sync.Mutex
+	secretWatcher *kube.SecretWatcher
+	apply         func([]*remoteClusterConfig) error
+	lastValid     map[string]*remoteClusterConfig // Last valid configuration of each secret, keyed by secret name
+	backoff       *backoff.Backoff                // Backoff between retries of a failed apply
+	retry         *time.Timer                     // Pending retry of a failed apply
+	stopped       bool
+	// Allow exec credential plugins and auth providers in kubeconfigs
+	allowCredentialPlugins bool
+}
+
+func newRemoteClusterDiscovery(client kubernetes.Interface, conf discoveryConfig, apply func([]*remoteClusterConfig) error) *remoteClusterDiscovery {
+	d := &remoteClusterDiscovery{
+		apply:                  apply,
+		lastValid:              map[string]*remoteClusterConfig{},
+		backoff:                &backoff.Backoff{Jitter: true, Min: 2 * time.Second, Max: time.Minute},
+		allowCredentialPlugins: conf.AllowCredentialPlugins,
+	}
+	d.secretWatcher = kube.NewSecretWatcher(
+		"remoteClusterSecretWatcher",
+		client,
+		0,
+		d.SecretEventHandler,
+		conf.LabelSelector,
+		conf.Namespace,
+		"discovery",
+		nil,
+	)
+	d.secretWatcher.Init()
+	return d
+}
+
+// Run starts watching secrets
+func (d *remoteClusterDiscovery) Run() {
+	go d.secretWatcher.Run()
+}
+
+// Stop stops watching secrets and cancels any pending retry
+func (d *remoteClusterDiscovery) Stop() {
+	d.secretWatcher.Stop()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	if d.retry != nil {
+		d.retry.Stop()
+	}
+}
+
+// SecretEventHandler applies the discovered remote clusters on every secret
+// event
+func (d *remoteClusterDiscovery) SecretEventHandler(eventType watch.EventType, old *v1.Secret, new *v1.Secret) {
+	switch eventType {
+	case watch.Added, watch.Modified, watch.Deleted:
+		log.Logger.Debug("remote cluster secret event", "event", eventType)
+		d.sync()
+	default:
+		log.Logger.Info("Unknown secret event received: %v", eventType)
+	}
+}
+
+// sync builds the remote clusters configuration from all watched secrets and
+// applies it. Secrets with invalid configuration keep their last valid
+// configuration, so that a bad update does not tear down a working cluster.
+// A failed apply is retried with backoff until it succeeds or a newer sync
+// replaces it.
+func (d *remoteClusterDiscovery) sync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return
+	}
+	if d.retry != nil {
+		d.retry.Stop()
+		d.retry = nil
+	}
+
+	secrets, err := d.secretWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote cluster secrets", "err", err)
+		return
+	}
+	// Sort secrets for a stable choice between secrets describing the same
+	// cluster
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	remotes := []*remoteClusterConfig{}
+	valid := map[string]*remoteClusterConfig{}
+	names := map[string]string{}
+	for _, secret := range secrets {
+		remote, err := remoteClusterFromSecret(secret, d.allowCredentialPlugins)
+		if err != nil {
+			last, ok := d.lastValid[secret.Name]
+			if !ok {
+				log.Logger.Error("invalid remote cluster secret, skipping", "name", secret.Name, "err", err)
+				continue
+			}
+			log.Logger.Error("invalid remote cluster secret, keeping the last valid configuration", "name", secret.Name, "err", err)
+			remote = last
+		}
+		valid[secret.Name] = remote
+		if other, ok := names[remote.Name]; ok {
+			log.Logger.Error("remote cluster is described by multiple secrets, skipping", "cluster", remote.Name, "name", secret.Name, "other", other)
+			continue
+		}
+		names[remote.Name] = secret.Name
+		remotes = append(remotes, remote)
+	}
+	d.lastValid = valid
+	if err := d.apply(remotes); err != nil {
+		wait := d.backoff.Duration()
+		log.Logger.Error("cannot apply discovered remote clusters, retrying", "err", err, "backoff", wait)
+		d.retry = time.AfterFunc(wait, d.sync)
+		return
+	}
+	d.backoff.Reset()
+}
+
+// remoteClusterFromSecret returns the remote cluster configuration described by
+// a secret. Anyone who can create secrets in the namespace can describe a
+// cluster, so kubeconfigs may only get credentials from exec plugins or auth
+// providers if allowCredentialPlugins is set.
+func remoteClusterFromSecret(secret *v1.Secret, allowCredentialPlugins bool) (*remoteClusterConfig, error) {
+	remote := &remoteClusterConfig{}
+	if conf, ok := secret.Annotations[remoteClusterConfigAnno]; ok {
+		if err := json.Unmarshal([]byte(conf), remote); err != nil {
+			return nil, fmt.Errorf("error unmarshalling %s annotation: %v", remoteClusterConfigAnno, err)
+		}
+	}
+	remote.Name = secret.Annotations[remoteClusterNameAnno]
+	remote.ServicePrefix = secret.Annotations[remoteClusterServicePrefixAnno]
+	remote.KubeConfigData = secret.Data[remoteClusterKubeConfigKey]
+	if v, ok := secret.Data[remoteClusterAPIURLKey]; ok {
+		remote.RemoteAPIURL = string(v)
+	}
+	if v, ok := secret.Data[remoteClusterTokenKey]; ok {
+		remote.RemoteSAToken = string(v)
+	}
+	if v, ok := secret.Data[remoteClusterCAKey]; ok {
+		remote.RemoteCAData = string(v)
+	}
+	if v, ok := secret.Data[remoteClusterCAURLKey]; ok {
+		remote.RemoteCAURL = string(v)
+	}
+	if err := validateRemoteClusterConfig(remote); err != nil {
+		return nil, err
+	}
+	if len(remote.KubeConfigData) > 0 && !allowCredentialPlugins {
+		if err := kube.CheckKubeConfigCredentialPlugins(remote.KubeConfigData); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig: %v", err)
+		}
+	}
+	return remote, nil
+}
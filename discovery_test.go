@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testRemoteClusterSecret(name, cluster, prefix string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "local-ns",
+			Labels:    map[string]string{"semaphore-service-mirror/remote-cluster": "true"},
+			Annotations: map[string]string{
+				remoteClusterNameAnno:          cluster,
+				remoteClusterServicePrefixAnno: prefix,
+			},
+		},
+		Data: map[string][]byte{
+			remoteClusterAPIURLKey: []byte("https://remote"),
+			remoteClusterTokenKey:  []byte("token"),
+			remoteClusterCAKey:     []byte("ca"),
+		},
+	}
+}
+
+// testKubeConfig returns a kubeconfig with a single user
+func testKubeConfig(user string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    user: remote
+current-context: remote
+users:
+- name: remote
+  user:
+    ` + user + "\n"
+}
+
+func TestRemoteClusterFromSecret(t *testing.T) {
+	secret := testRemoteClusterSecret("remote-a", "a", "prefix-a")
+	secret.Annotations[remoteClusterConfigAnno] = `{"mirrorEndpointSlices": true, "excludeNamespaces": ["kube-system"]}`
+	remote, err := remoteClusterFromSecret(secret, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &remoteClusterConfig{
+		Name:                 "a",
+		ServicePrefix:        "prefix-a",
+		RemoteAPIURL:         "https://remote",
+		RemoteSAToken:        "token",
+		RemoteCAData:         "ca",
+		MirrorEndpointSlices: true,
+		ExcludeNamespaces:    []string{"kube-system"},
+	}, remote)
+
+	kubeConfigSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "remote-b",
+			Annotations: map[string]string{
+				remoteClusterNameAnno:          "b",
+				remoteClusterServicePrefixAnno: "prefix-b",
+			},
+		},
+		Data: map[string][]byte{
+			remoteClusterKubeConfigKey: []byte(testKubeConfig(`token: remote-token`)),
+		},
+	}
+	remote, err = remoteClusterFromSecret(kubeConfigSecret, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte(testKubeConfig(`token: remote-token`)), remote.KubeConfigData)
+
+	// Credential plugins are only allowed explicitly
+	for _, user := range []string{
+		"exec:\n      apiVersion: client.authentication.k8s.io/v1\n      command: /bin/sh",
+		"auth-provider:\n      name: oidc",
+	} {
+		pluginSecret := kubeConfigSecret.DeepCopy()
+		pluginSecret.Data[remoteClusterKubeConfigKey] = []byte(testKubeConfig(user))
+		_, err = remoteClusterFromSecret(pluginSecret, false)
+		assert.NotEqual(t, nil, err)
+		_, err = remoteClusterFromSecret(pluginSecret, true)
+		assert.Equal(t, nil, err)
+	}
+
+	// Missing service prefix
+	delete(kubeConfigSecret.Annotations, remoteClusterServicePrefixAnno)
+	_, err = remoteClusterFromSecret(kubeConfigSecret, false)
+	assert.NotEqual(t, nil, err)
+	// Missing credentials
+	_, err = remoteClusterFromSecret(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "remote-c",
+			Annotations: map[string]string{
+				remoteClusterNameAnno:          "c",
+				remoteClusterServicePrefixAnno: "prefix-c",
+			},
+		},
+	}, false)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRemoteClusterDiscoverySync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	secretA := testRemoteClusterSecret("remote-a", "a", "prefix-a")
+	// Describes the same cluster as remote-a
+	secretDuplicate := testRemoteClusterSecret("remote-dup", "a", "prefix-dup")
+	fakeClient := fake.NewSimpleClientset(secretA, secretDuplicate)
+
+	var mu sync.Mutex
+	var applied []*remoteClusterConfig
+	d := newRemoteClusterDiscovery(
+		fakeClient,
+		discoveryConfig{
+			Enabled:       true,
+			LabelSelector: defaultDiscoveryLabelSelector,
+			Namespace:     "local-ns",
+		},
+		func(remotes []*remoteClusterConfig) error {
+			mu.Lock()
+			defer mu.Unlock()
+			applied = remotes
+			return nil
+		},
+	)
+	go d.secretWatcher.Run()
+	cache.WaitForNamedCacheSync("remoteClusterSecretWatcher", ctx.Done(), d.secretWatcher.HasSynced)
+
+	// Of the secrets describing the same cluster, the first by name is used
+	// Applied on every event
+	appliedPrefixes := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		if applied == nil {
+			return nil
+		}
+		prefixes := []string{}
+		for _, r := range applied {
+			prefixes = append(prefixes, r.ServicePrefix)
+		}
+		return prefixes
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"prefix-a"}, appliedPrefixes())
+	}, time.Second, 10*time.Millisecond)
+
+	// An invalid update keeps the last valid configuration
+	invalid := secretA.DeepCopy()
+	invalid.Data = nil
+	invalid.Annotations[remoteClusterServicePrefixAnno] = "prefix-invalid"
+	if _, err := fakeClient.CoreV1().Secrets("local-ns").Update(ctx, invalid, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		s, err := d.secretWatcher.Get("remote-a", "local-ns")
+		return err == nil && s.Data == nil
+	}, time.Second, 10*time.Millisecond)
+	d.sync()
+	assert.Equal(t, []string{"prefix-a"}, appliedPrefixes())
+
+	// Deleting the secrets removes the cluster
+	if err := fakeClient.CoreV1().Secrets("local-ns").Delete(ctx, "remote-a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeClient.CoreV1().Secrets("local-ns").Delete(ctx, "remote-dup", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{}, appliedPrefixes())
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemoteClusterDiscoveryRetriesFailedApply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	fakeClient := fake.NewSimpleClientset(testRemoteClusterSecret("remote-a", "a", "prefix-a"))
+
+	var mu sync.Mutex
+	attempts := 0
+	d := newRemoteClusterDiscovery(
+		fakeClient,
+		discoveryConfig{
+			Enabled:       true,
+			LabelSelector: defaultDiscoveryLabelSelector,
+			Namespace:     "local-ns",
+		},
+		func(remotes []*remoteClusterConfig) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("failed to start")
+			}
+			return nil
+		},
+	)
+	d.backoff.Min = 10 * time.Millisecond
+	d.backoff.Max = 10 * time.Millisecond
+	// Wait for the watcher to stop, so that it does not log after the test
+	done := make(chan struct{})
+	go func() {
+		d.secretWatcher.Run()
+		close(done)
+	}()
+	defer func() {
+		d.Stop()
+		<-done
+	}()
+	cache.WaitForNamedCacheSync("remoteClusterSecretWatcher", ctx.Done(), d.secretWatcher.HasSynced)
+
+	// The failed apply is retried without further secret events
+	getAttempts := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts
+	}
+	assert.Eventually(t, func() bool { return getAttempts() == 3 }, time.Second, 10*time.Millisecond)
+	// No more retries after a successful apply
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, getAttempts())
+	d.mu.Lock()
+	assert.Equal(t, float64(0), d.backoff.Attempt())
+	d.mu.Unlock()
+}
+
+func TestMergeRemoteClusters(t *testing.T) {
+	static := []*remoteClusterConfig{
+		&remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"},
+	}
+	discovered := []*remoteClusterConfig{
+		&remoteClusterConfig{Name: "a", KubeConfigData: []byte("a"), ServicePrefix: "discovered-a"},
+		&remoteClusterConfig{Name: "b", KubeConfigData: []byte("b"), ServicePrefix: "b"},
+	}
+	merged := mergeRemoteClusters(static, discovered)
+	assert.Equal(t, 2, len(merged))
+	assert.Equal(t, "a", merged[0].ServicePrefix)
+	assert.Equal(t, "b", merged[1].Name)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+)
+
+// Reasons of the events recorded on mirrored and global services
+const (
+	eventReasonCreated          = "Created"
+	eventReasonUpdated          = "Updated"
+	eventReasonRecreated        = "Recreated"
+	eventReasonDeleted          = "Deleted"
+	eventReasonGarbageCollected = "GarbageCollected"
+	eventReasonReconcileFailed  = "ReconcileFailed"
+	eventReasonPortConflict     = "PortConflict"
+	eventReasonHeadlessMismatch = "HeadlessMismatch"
+	eventReasonNameTooLong      = "NameTooLong"
+)
+
+// serviceEventTarget returns the object to record the events of a local service
+// on. The service itself is preferred, so that the events are listed by
+// kubectl describe, otherwise a reference to it is returned.
+func serviceEventTarget(svc *v1.Service, err error, name, namespace string) runtime.Object {
+	if err == nil && svc != nil {
+		return svc
+	}
+	return kube.ServiceReference(name, namespace)
+}
+
+// recordConflictEvents records a warning for each conflict between the
+// definitions of a global service in different clusters
+func recordConflictEvents(globalSvc *v1.Service, conflicts []string) {
+	for _, c := range conflicts {
+		reason := eventReasonPortConflict
+		if strings.HasPrefix(c, headlessMismatchConflict) {
+			reason = eventReasonHeadlessMismatch
+		}
+		kube.EventRecorder.Eventf(globalSvc, v1.EventTypeWarning, reason, "Conflicting global service definitions: %s", c)
+	}
+}
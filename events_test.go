@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+// drainEvents returns the events recorded so far by the fake recorder
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestMirrorServiceEvents(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	recorder := record.NewFakeRecorder(10)
+	kube.EventRecorder = recorder
+	defer func() { kube.EventRecorder = &record.FakeRecorder{} }()
+
+	longName := "test-svc-" + strings.Repeat("a", 60)
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      longName,
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Port: 80}},
+		},
+	}
+	fakeClient := fake.NewSimpleClientset()
+	fakeWatchClient := fake.NewSimpleClientset(testSvc)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+	})
+	go testRunner.serviceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+
+	assert.Equal(t, nil, testRunner.reconcileService(longName, "remote-ns"))
+	assert.Equal(t, []string{
+		"Normal Created Created mirror of service remote-ns/" + longName + " from cluster test-runner",
+		"Warning NameTooLong Mirror name of service remote-ns/" + longName + " is longer than 63 characters and was shortened",
+	}, drainEvents(recorder))
+
+	// Reconciling an unchanged service records nothing
+	assert.Equal(t, nil, testRunner.reconcileService(longName, "remote-ns"))
+	assert.Equal(t, []string{}, drainEvents(recorder))
+
+	// Failures are recorded on the mirrored service
+	fakeClient.PrependReactor("get", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, assert.AnError
+	})
+	assert.NotEqual(t, nil, testRunner.reconcileService(longName, "remote-ns"))
+	events := drainEvents(recorder)
+	assert.Equal(t, 1, len(events))
+	assert.True(t, strings.HasPrefix(events[0], "Warning ReconcileFailed Failed to mirror service remote-ns/"+longName+" from cluster test-runner"))
+}
+
+func TestRecordConflictEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	kube.EventRecorder = recorder
+	defer func() { kube.EventRecorder = &record.FakeRecorder{} }()
+
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	svc := createTestService("test-svc", "remote-ns", "1.1.1.1", []int32{80})
+	store.AddOrUpdateClusterServiceTarget(svc, "a", false, nil, nil)
+	headlessSvc := createTestService("test-svc", "remote-ns", "None", []int32{80})
+	headlessSvc.Spec.Ports[0].Protocol = v1.ProtocolUDP
+	gsvc := store.AddOrUpdateClusterServiceTarget(headlessSvc, "b", false, nil, nil)
+	assert.Equal(t, 2, len(gsvc.conflicts))
+
+	recordConflictEvents(&v1.Service{}, gsvc.conflicts)
+	events := drainEvents(recorder)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "Warning HeadlessMismatch Conflicting global service definitions: headless mismatch between clusters a and b", events[0])
+	assert.True(t, strings.HasPrefix(events[1], "Warning PortConflict Conflicting global service definitions: "))
+}
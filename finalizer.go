@@ -0,0 +1,19 @@
+package main
+
+import (
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// serviceFinalizer holds the deletion of mirrored and global services until the
+// controller has cleaned up their endpoints, endpointslices and status
+// resources
+const serviceFinalizer = "mirror.semaphore.uw.io/cleanup"
+
+// finalizing returns true if the service is being deleted and waits for the
+// controller to clean up after it. Services are finalized even if adding the
+// finalizer has been disabled since, so that their deletion does not hang.
+func finalizing(svc *v1.Service) bool {
+	return svc.DeletionTimestamp != nil && slices.Contains(svc.Finalizers, serviceFinalizer)
+}
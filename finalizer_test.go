@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func TestMirrorServiceFinalizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	mirrorName := generateMirrorName("prefix", "remote-ns", "test-svc")
+	mirrorSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        mirrorName,
+			Namespace:   "local-ns",
+			UID:         "mirror-uid",
+			Labels:      testMirrorLabels,
+			Annotations: generateMirrorAnnotations("test-svc", "remote-ns"),
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     []v1.ServicePort{{Port: 80}},
+			ClusterIP: "None",
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(mirrorSvc)
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     []v1.ServicePort{{Port: 80}},
+			ClusterIP: "None",
+		},
+	}
+	testEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Subsets: []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc, testEndpoints)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		finalizer:     true,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.mirrorServiceWatcher.Run()
+	go testRunner.endpointsWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), testRunner.endpointsWatcher.HasSynced)
+
+	// The finalizer is added to the mirrored service
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{serviceFinalizer}, svc.Finalizers)
+
+	// Endpoints are owned by the mirrored service
+	assert.Equal(t, nil, testRunner.reconcileEndpoints("test-svc", "remote-ns"))
+	endpoints, err := fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(endpoints.OwnerReferences))
+	assert.Equal(t, mirrorName, endpoints.OwnerReferences[0].Name)
+	assert.Equal(t, "mirror-uid", string(endpoints.OwnerReferences[0].UID))
+
+	// The fake client ignores finalizers, mark the service deleted instead
+	now := metav1.Now()
+	svc.DeletionTimestamp = &now
+	_, err = fakeClient.CoreV1().Services("local-ns").Update(ctx, svc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		return testRunner.finalizerQueue.queue.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The service is not updated while it is being deleted
+	assert.NotEqual(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+
+	assert.Equal(t, nil, testRunner.finalizeService(mirrorName, "local-ns"))
+	_, err = fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	svc, err = fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(svc.Finalizers))
+}
+
+func TestGlobalServiceFinalizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	globalSvcName := generateGlobalServiceName("test-svc", "remote-ns")
+	globalSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       globalSvcName,
+			Namespace:  "local-ns",
+			UID:        "global-uid",
+			Labels:     globalSvcLabels,
+			Finalizers: []string{serviceFinalizer},
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(globalSvc)
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    testGlobalSvcLabel,
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     []v1.ServicePort{{Port: 80}},
+			ClusterIP: "1.1.1.1",
+		},
+	}
+	testEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc-abc",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"kubernetes.io/service-name":            "test-svc",
+				"mirror.semaphore.uw.io/global-service": "true",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc, testEndpointSlice)
+
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, ""),
+		routingStrategyLabel: selector,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.globalServiceWatcher.Run()
+	go testRunner.endpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("globalServiceWatcher", ctx.Done(), testRunner.globalServiceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+
+	// Endpointslices are owned by the global service
+	mirrorName := generateGlobalEndpointSliceName("test-runner", "remote-ns", "test-svc-abc")
+	assert.Equal(t, nil, testRunner.reconcileEndpointSlice("test-svc-abc", "remote-ns"))
+	es, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(es.OwnerReferences))
+	assert.Equal(t, globalSvcName, es.OwnerReferences[0].Name)
+	assert.Equal(t, "global-uid", string(es.OwnerReferences[0].UID))
+
+	// The finalizer is removed when it is disabled
+	assert.Equal(t, nil, testRunner.reconcileGlobalService("test-svc", "remote-ns"))
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, globalSvcName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(svc.Finalizers))
+
+	// Services being deleted are cleaned up after and finalized
+	now := metav1.Now()
+	svc.Finalizers = []string{serviceFinalizer}
+	svc.DeletionTimestamp = &now
+	_, err = fakeClient.CoreV1().Services("local-ns").Update(ctx, svc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		return testRunner.finalizerQueue.queue.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, nil, testRunner.finalizeService(globalSvcName, "local-ns"))
+	_, err = fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	svc, err = fakeClient.CoreV1().Services("local-ns").Get(ctx, globalSvcName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(svc.Finalizers))
+}
+
+// assignServiceUIDs makes the fake client set a new UID on created services,
+// like the API server does
+func assignServiceUIDs(client *fake.Clientset) {
+	n := 0
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		n++
+		action.(k8stesting.CreateAction).GetObject().(*v1.Service).UID = types.UID(fmt.Sprintf("uid-%d", n))
+		return false, nil, nil
+	})
+}
+
+// processQueue reconciles the items in the queue until it is empty
+func processQueue(q *queue) {
+	for q.queue.Len() > 0 {
+		q.processItem()
+	}
+}
+
+func TestMirrorServiceRecreateOwnerReferences(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	mirrorName := generateMirrorName("prefix", "remote-ns", "test-svc")
+	fakeClient := fake.NewSimpleClientset()
+	assignServiceUIDs(fakeClient)
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     []v1.ServicePort{{Port: 80}},
+			ClusterIP: "1.1.1.1",
+		},
+	}
+	testEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Subsets: []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc, testEndpoints)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.mirrorServiceWatcher.Run()
+	go testRunner.endpointsWatcher.Run()
+	go testRunner.mirrorEndpointsWatcher.Run()
+	go testRunner.mirrorEndpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), testRunner.endpointsWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorEndpointsWatcher", ctx.Done(), testRunner.mirrorEndpointsWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorEndpointSliceWatcher", ctx.Done(), testRunner.mirrorEndpointSliceWatcher.HasSynced)
+
+	// ownedBy reconciles the queued endpoints and returns true when the
+	// mirrored endpoints are owned by the current mirrored service
+	ownedBy := func(uid types.UID) func() bool {
+		return func() bool {
+			processQueue(testRunner.endpointsQueue)
+			svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+			if err != nil || svc.UID != uid {
+				return false
+			}
+			endpoints, err := fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+			return err == nil && len(endpoints.OwnerReferences) == 1 && endpoints.OwnerReferences[0].UID == uid
+		}
+	}
+
+	// The endpoints are mirrored once the new service is seen
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	assert.Eventually(t, ownedBy("uid-1"), time.Second, 10*time.Millisecond)
+
+	// Recreating the service moves the endpoints to the new service
+	testSvc.Spec.ClusterIP = v1.ClusterIPNone
+	_, err := fakeWatchClient.CoreV1().Services("remote-ns").Update(ctx, testSvc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		svc, err := testRunner.serviceWatcher.Get("test-svc", "remote-ns")
+		return err == nil && svc.Spec.ClusterIP == v1.ClusterIPNone
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	assert.Eventually(t, ownedBy("uid-2"), time.Second, 10*time.Millisecond)
+
+	// A missing owner reference is repaired on the next service event
+	endpoints, err := fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	endpoints.OwnerReferences = nil
+	_, err = fakeClient.CoreV1().Endpoints("local-ns").Update(ctx, endpoints, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		e, err := testRunner.mirrorEndpointsWatcher.Get(mirrorName, "local-ns")
+		return err == nil && len(e.OwnerReferences) == 0
+	}, time.Second, 10*time.Millisecond)
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	svc.Annotations["test"] = "modified"
+	_, err = fakeClient.CoreV1().Services("local-ns").Update(ctx, svc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, ownedBy("uid-2"), time.Second, 10*time.Millisecond)
+}
+
+func TestGlobalServiceRecreateOwnerReferences(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	globalSvcName := generateGlobalServiceName("test-svc", "remote-ns")
+	fakeClient := fake.NewSimpleClientset()
+	assignServiceUIDs(fakeClient)
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+
+	// Runners of two clusters mirror an endpointslice each to the global
+	// service
+	testSvcs := map[string]*v1.Service{}
+	fakeWatchClients := map[string]*fake.Clientset{}
+	testRunners := map[string]*GlobalRunner{}
+	for _, cluster := range []string{"runnerA", "runnerB"} {
+		testSvcs[cluster] = &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-svc",
+				Namespace: "remote-ns",
+				Labels:    testGlobalSvcLabel,
+			},
+			Spec: v1.ServiceSpec{
+				Ports:     []v1.ServicePort{{Port: 80}},
+				ClusterIP: "1.1.1.1",
+			},
+		}
+		testEndpointSlice := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-svc-" + cluster,
+				Namespace: "remote-ns",
+				Labels: map[string]string{
+					"kubernetes.io/service-name":            "test-svc",
+					"mirror.semaphore.uw.io/global-service": "true",
+				},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		}
+		fakeWatchClients[cluster] = fake.NewSimpleClientset(testSvcs[cluster], testEndpointSlice)
+		testRunner := newGlobalRunner(globalRunnerOptions{
+			client:               fakeClient,
+			watchClient:          fakeWatchClients[cluster],
+			name:                 cluster,
+			namespace:            "local-ns",
+			labelSelector:        testGlobalSvcLabelString,
+			resyncPeriod:         60 * time.Minute,
+			globalServiceStore:   testGlobalStore,
+			routingStrategyLabel: selector,
+		})
+		go testRunner.serviceWatcher.Run()
+		go testRunner.globalServiceWatcher.Run()
+		go testRunner.endpointSliceWatcher.Run()
+		go testRunner.mirrorEndpointSliceWatcher.Run()
+		cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+		cache.WaitForNamedCacheSync("globalServiceWatcher", ctx.Done(), testRunner.globalServiceWatcher.HasSynced)
+		cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+		cache.WaitForNamedCacheSync("mirrorEndpointSliceWatcher", ctx.Done(), testRunner.mirrorEndpointSliceWatcher.HasSynced)
+		testRunners[cluster] = testRunner
+	}
+
+	// ownedBy reconciles the queued endpointslices and returns true when the
+	// endpointslices of both clusters are owned by the current global service
+	ownedBy := func(uid types.UID) func() bool {
+		return func() bool {
+			for _, testRunner := range testRunners {
+				processQueue(testRunner.endpointSliceQueue)
+			}
+			svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, globalSvcName, metav1.GetOptions{})
+			if err != nil || svc.UID != uid {
+				return false
+			}
+			for _, cluster := range []string{"runnerA", "runnerB"} {
+				name := generateGlobalEndpointSliceName(cluster, "remote-ns", "test-svc-"+cluster)
+				es, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, name, metav1.GetOptions{})
+				if err != nil || len(es.OwnerReferences) != 1 || es.OwnerReferences[0].UID != uid {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	assert.Equal(t, nil, testRunners["runnerA"].reconcileGlobalService("test-svc", "remote-ns"))
+	assert.Equal(t, nil, testRunners["runnerB"].reconcileGlobalService("test-svc", "remote-ns"))
+	assert.Eventually(t, ownedBy("uid-1"), time.Second, 10*time.Millisecond)
+
+	// Making the reference service headless recreates the global service and
+	// the endpointslices of all clusters move to the new service
+	testSvcs["runnerA"].Spec.ClusterIP = v1.ClusterIPNone
+	_, err := fakeWatchClients["runnerA"].CoreV1().Services("remote-ns").Update(ctx, testSvcs["runnerA"], metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		svc, err := testRunners["runnerA"].serviceWatcher.Get("test-svc", "remote-ns")
+		return err == nil && svc.Spec.ClusterIP == v1.ClusterIPNone
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, nil, testRunners["runnerA"].reconcileGlobalService("test-svc", "remote-ns"))
+	assert.Eventually(t, ownedBy("uid-2"), time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+// garbageCollector keeps track of orphaned mirrored objects and releases them
+// for deletion only after they have been orphaned for longer than a grace
+// period. This protects mirrors from being deleted while a remote cache is
+// being reset, for example after a watch gap.
+type garbageCollector struct {
+	runner      string
+	gracePeriod time.Duration
+	orphans     map[string]map[string]time.Time // first time each orphan was seen, by kind and name
+	now         func() time.Time
+}
+
+func newGarbageCollector(runner string, gracePeriod time.Duration) *garbageCollector {
+	return &garbageCollector{
+		runner:      runner,
+		gracePeriod: gracePeriod,
+		orphans:     make(map[string]map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// expired records the currently orphaned objects of a kind and returns the ones
+// that have been orphaned for longer than the grace period. Objects that are no
+// longer orphaned are forgotten.
+func (gc *garbageCollector) expired(kind string, orphans []string) []string {
+	now := gc.now()
+	seen := gc.orphans[kind]
+	current := make(map[string]time.Time, len(orphans))
+	expired := []string{}
+	for _, name := range orphans {
+		first, ok := seen[name]
+		if !ok {
+			first = now
+		}
+		current[name] = first
+		if now.Sub(first) >= gc.gracePeriod {
+			expired = append(expired, name)
+		}
+	}
+	gc.orphans[kind] = current
+	metrics.SetGCOrphans(kind, gc.runner, float64(len(orphans)))
+	return expired
+}
+
+// forget drops an orphan after it has been deleted
+func (gc *garbageCollector) forget(kind, name string) {
+	delete(gc.orphans[kind], name)
+}
+
+// collect lists the orphaned objects of a kind and deletes the ones that have
+// been orphaned for longer than the grace period
+func (gc *garbageCollector) collect(kind string, listOrphans func() ([]string, error), deleteOrphan func(string) error) {
+	orphans, err := listOrphans()
+	if err != nil {
+		log.Logger.Error("listing orphaned objects", "kind", kind, "err", err, "runner", gc.runner)
+		metrics.IncGCErrors(kind, gc.runner)
+		return
+	}
+	for _, name := range gc.expired(kind, orphans) {
+		if err := deleteOrphan(name); err != nil {
+			metrics.IncGCErrors(kind, gc.runner)
+			continue
+		}
+		gc.forget(kind, name)
+		metrics.IncGCDeleted(kind, gc.runner)
+	}
+}
+
+// runGarbageCollection calls collect every interval until the context is
+// cancelled or stop is closed. A zero interval disables garbage collection.
+func runGarbageCollection(ctx context.Context, stop <-chan struct{}, interval time.Duration, runner string, collect func()) {
+	if interval <= 0 {
+		return
+	}
+	log.Logger.Info("starting garbage collection", "interval", interval, "runner", runner)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+			collect()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGarbageCollectorExpired(t *testing.T) {
+	now := time.Now()
+	gc := newGarbageCollector("test-runner", time.Minute)
+	gc.now = func() time.Time { return now }
+
+	// Newly orphaned objects should be kept for the grace period
+	assert.Equal(t, []string{}, gc.expired("service", []string{"a", "b"}))
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []string{}, gc.expired("service", []string{"a", "b", "c"}))
+
+	// b is no longer orphaned and should be forgotten
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []string{"a"}, gc.expired("service", []string{"a", "c"}))
+
+	// b is orphaned again and should go through a new grace period
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []string{"a", "c"}, gc.expired("service", []string{"a", "b", "c"}))
+
+	// Kinds are tracked separately
+	assert.Equal(t, []string{}, gc.expired("endpointslice", []string{"a"}))
+}
+
+func TestMirrorRunnerGarbageCollect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	mirroredSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	orphanSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-old-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(mirroredSvc, orphanSvc)
+	fakeWatchClient := fake.NewSimpleClientset(testSvc)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		gcInterval:    time.Minute,
+		gcGracePeriod: time.Minute,
+	})
+	now := time.Now()
+	testRunner.gc.now = func() time.Time { return now }
+
+	// Garbage collection should be skipped before the remote cache syncs
+	go testRunner.mirrorServiceWatcher.Run()
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+	testRunner.GarbageCollect()
+	assert.Equal(t, 0, len(testRunner.gc.orphans["service"]))
+
+	go testRunner.serviceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+
+	// The orphan should survive the grace period
+	testRunner.GarbageCollect()
+	svcs, err := fakeClient.CoreV1().Services("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(svcs.Items))
+
+	now = now.Add(time.Minute)
+	testRunner.GarbageCollect()
+	svcs, err = fakeClient.CoreV1().Services("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(svcs.Items))
+	assert.Equal(t, mirroredSvc.Name, svcs.Items[0].Name)
+}
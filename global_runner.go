@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
@@ -10,7 +12,9 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 
@@ -23,104 +27,218 @@ import (
 type GlobalRunner struct {
 	ctx                        context.Context
 	client                     kubernetes.Interface
+	mcsClient                  dynamic.Interface // Client for local ServiceImports, nil without the Multi-Cluster Services API
 	globalServiceStore         *GlobalServiceStore
 	serviceQueue               *queue
 	serviceWatcher             *kube.ServiceWatcher
+	globalServiceWatcher       *kube.ServiceWatcher
 	endpointSliceQueue         *queue
 	endpointSliceWatcher       *kube.EndpointSliceWatcher
 	mirrorEndpointSliceWatcher *kube.EndpointSliceWatcher
+	serviceExportWatcher       *kube.ServiceExportWatcher // Watches remote ServiceExports, nil without the Multi-Cluster Services API
+	statusQueue                *queue
+	finalizerQueue             *queue
 	name                       string
 	namespace                  string
 	labelselector              string
+	namespaceFilter            *namespaceFilter    // Remote namespaces to mirror, nil mirrors all
+	staleEndpoints             *staleEndpointGuard // Applies the stale endpoint policy while the remote cluster is unreachable, nil keeps endpoints
+	propagation                *propagationRules   // Labels and annotations to copy from remote services, nil copies none
+	status                     *statusReporter     // Reports the state of global services in the runner's cluster, nil reports nothing
 	sync                       bool
+	finalizer                  bool              // Add a finalizer to global services, to clean up after them before they are deleted
 	syncMirrorLabels           map[string]string // Labels used to watch mirrore endpointslices and delete stale objects on startup
-	initialised                bool              // Flag to turn on after the successful initialisation of the runner.
+	initialised                atomic.Bool       // Flag to turn on after the successful initialisation of the runner.
 	local                      bool              // Flag to identify if the runner is running against a local or remote cluster
 	routingStrategyLabel       labels.Selector   // Label to identify services that want to utilise topology hints
+	elected                    <-chan struct{}   // Closed when the replica becomes the leader and should start reconciling
+	gc                         *garbageCollector
+	gcInterval                 time.Duration // How often to delete orphaned mirrors, 0 disables garbage collection
+	gcStop                     chan struct{}
 }
 
-func newGlobalRunner(client, watchClient kubernetes.Interface, name, namespace, labelselector string, resyncPeriod time.Duration, gst *GlobalServiceStore, local bool, rsl labels.Selector, sync bool) *GlobalRunner {
+// globalRunnerOptions holds the configuration of a GlobalRunner. Zero values
+// disable the optional features.
+type globalRunnerOptions struct {
+	client               kubernetes.Interface // Client of the local cluster, where global services are created
+	watchClient          kubernetes.Interface // Client of the watched cluster
+	mcsClient            dynamic.Interface    // Nil without the Multi-Cluster Services API
+	mcsWatchClient       dynamic.Interface    // Nil without the Multi-Cluster Services API
+	name                 string
+	namespace            string // Local namespace of the global services
+	labelSelector        string
+	namespaceFilter      *namespaceFilter
+	staleEndpoints       *staleEndpointGuard
+	propagation          *propagationRules
+	status               *statusReporter
+	resyncPeriod         time.Duration
+	globalServiceStore   *GlobalServiceStore
+	local                bool
+	routingStrategyLabel labels.Selector
+	sync                 bool
+	finalizer            bool
+	gcInterval           time.Duration
+	gcGracePeriod        time.Duration
+	elected              <-chan struct{} // Nil never starts reconciling
+}
+
+func newGlobalRunner(opts globalRunnerOptions) *GlobalRunner {
 	mirrorLabels := map[string]string{
 		"mirrored-endpoint-slice":        "true",
-		"mirror-endpointslice-sync-name": name,
+		"mirror-endpointslice-sync-name": opts.name,
 	}
 	runner := &GlobalRunner{
 		ctx:                  context.Background(),
-		client:               client,
-		name:                 name,
-		namespace:            namespace,
-		globalServiceStore:   gst,
-		initialised:          false,
-		local:                local,
-		routingStrategyLabel: rsl,
-		sync:                 sync,
+		client:               opts.client,
+		mcsClient:            opts.mcsClient,
+		name:                 opts.name,
+		namespace:            opts.namespace,
+		globalServiceStore:   opts.globalServiceStore,
+		namespaceFilter:      opts.namespaceFilter,
+		staleEndpoints:       opts.staleEndpoints,
+		propagation:          opts.propagation,
+		status:               opts.status,
+		local:                opts.local,
+		routingStrategyLabel: opts.routingStrategyLabel,
+		sync:                 opts.sync,
+		finalizer:            opts.finalizer,
 		syncMirrorLabels:     mirrorLabels,
+		elected:              opts.elected,
+		gcInterval:           opts.gcInterval,
+		gcStop:               make(chan struct{}),
 	}
-	runner.serviceQueue = newQueue(fmt.Sprintf("%s-global-service", name), runner.reconcileGlobalService)
-	runner.endpointSliceQueue = newQueue(fmt.Sprintf("%s-endpointslice", name), runner.reconcileEndpointSlice)
-	runnerName := fmt.Sprintf("global-%s", name)
+	runner.serviceQueue = newQueue(fmt.Sprintf("%s-global-service", opts.name), runner.reconcileGlobalService)
+	runner.endpointSliceQueue = newQueue(fmt.Sprintf("%s-endpointslice", opts.name), runner.reconcileEndpointSlice)
+	runner.statusQueue = newQueue(fmt.Sprintf("%s-global-status", opts.name), runner.reconcileStatus)
+	runner.finalizerQueue = newQueue(fmt.Sprintf("%s-global-finalizer", opts.name), runner.finalizeService)
+	runnerName := fmt.Sprintf("global-%s", opts.name)
+	runner.gc = newGarbageCollector(runnerName, opts.gcGracePeriod)
 
 	// Create and initialize a service watcher
 	serviceWatcher := kube.NewServiceWatcher(
-		fmt.Sprintf("%s-serviceWatcher", name),
-		watchClient,
-		resyncPeriod,
+		fmt.Sprintf("%s-serviceWatcher", opts.name),
+		opts.watchClient,
+		opts.resyncPeriod,
 		runner.ServiceEventHandler,
-		labelselector,
+		opts.labelSelector,
 		metav1.NamespaceAll,
 		runnerName,
+		kube.HealthOf(opts.name),
 	)
 	runner.serviceWatcher = serviceWatcher
 	runner.serviceWatcher.Init()
 
+	// Create and initialize a service watcher for global services
+	globalServiceWatcher := kube.NewServiceWatcher(
+		fmt.Sprintf("%s-globalServiceWatcher", opts.name),
+		opts.client,
+		opts.resyncPeriod,
+		runner.GlobalServiceEventHandler,
+		labels.Set(globalSvcLabels).String(),
+		opts.namespace,
+		runnerName,
+		nil,
+	)
+	runner.globalServiceWatcher = globalServiceWatcher
+	runner.globalServiceWatcher.Init()
+
 	// Create and initialize an endpointslice watcher
 	endpointSliceWatcher := kube.NewEndpointSliceWatcher(
-		fmt.Sprintf("%s-endpointSliceWatcher", name),
-		watchClient,
-		resyncPeriod,
+		fmt.Sprintf("%s-endpointSliceWatcher", opts.name),
+		opts.watchClient,
+		opts.resyncPeriod,
 		runner.EndpointSliceEventHandler,
-		labelselector,
+		opts.labelSelector,
 		metav1.NamespaceAll,
 		runnerName,
+		kube.HealthOf(opts.name),
 	)
 	runner.endpointSliceWatcher = endpointSliceWatcher
 	runner.endpointSliceWatcher.Init()
 
 	// Create and initialize an endpointslice watcher for mirrored endpointslices
 	mirrorEndpointSliceWatcher := kube.NewEndpointSliceWatcher(
-		fmt.Sprintf("%s-mirrorEndpointSliceWatcher", name),
-		watchClient,
-		resyncPeriod,
+		fmt.Sprintf("%s-mirrorEndpointSliceWatcher", opts.name),
+		opts.client,
+		opts.resyncPeriod,
 		nil,
 		labels.Set(mirrorLabels).String(),
-		namespace,
+		opts.namespace,
 		runnerName,
+		nil,
 	)
 	runner.mirrorEndpointSliceWatcher = mirrorEndpointSliceWatcher
 	runner.mirrorEndpointSliceWatcher.Init()
 
+	// Create and initialize a ServiceExport watcher, which selects global
+	// services under the Multi-Cluster Services API
+	if opts.mcsWatchClient != nil {
+		serviceExportWatcher := kube.NewServiceExportWatcher(
+			fmt.Sprintf("%s-serviceExportWatcher", opts.name),
+			opts.mcsWatchClient,
+			opts.resyncPeriod,
+			runner.ServiceExportEventHandler,
+			metav1.NamespaceAll,
+			runnerName,
+			kube.HealthOf(opts.name),
+		)
+		runner.serviceExportWatcher = serviceExportWatcher
+		runner.serviceExportWatcher.Init()
+	}
+
 	return runner
 }
 
-// Run starts the watchers and queues of the runner
-func (gr *GlobalRunner) Run() error {
+// Run starts the watchers of the runner and, once the replica is elected
+// leader, the initial sync and queues. Followers keep their caches warm.
+func (gr *GlobalRunner) Run(ctx context.Context) error {
+	gr.ctx = ctx
+	if gr.serviceExportWatcher != nil {
+		go gr.serviceExportWatcher.Run()
+	}
 	go gr.serviceWatcher.Run()
-	// At this point the runner should be considered initialised and live.
-	gr.initialised = true
-	stopCh := make(chan struct{})
-	if ok := cache.WaitForNamedCacheSync("serviceWatcher", stopCh, gr.serviceWatcher.HasSynced); !ok {
+	go gr.globalServiceWatcher.Run()
+	if ok := cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), gr.serviceWatcher.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for service caches to sync")
 	}
+	if ok := cache.WaitForNamedCacheSync("globalServiceWatcher", ctx.Done(), gr.globalServiceWatcher.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for global service caches to sync")
+	}
+	if gr.serviceExportWatcher != nil {
+		if ok := cache.WaitForNamedCacheSync("serviceExportWatcher", ctx.Done(), gr.serviceExportWatcher.HasSynced); !ok {
+			return fmt.Errorf("failed to wait for service export caches to sync")
+		}
+	}
 
 	go gr.endpointSliceWatcher.Run()
 	go gr.mirrorEndpointSliceWatcher.Run()
 	// We need to wait fot endpoinslices watchers to sync before we sync
-	if ok := cache.WaitForNamedCacheSync(fmt.Sprintf("gl-%s-endpointSliceWatcher", gr.name), stopCh, gr.endpointSliceWatcher.HasSynced); !ok {
+	if ok := cache.WaitForNamedCacheSync(fmt.Sprintf("gl-%s-endpointSliceWatcher", gr.name), ctx.Done(), gr.endpointSliceWatcher.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for endpintslices caches to sync")
 	}
-	if ok := cache.WaitForNamedCacheSync(fmt.Sprintf("mirror-%s-endpointSliceWatcher", gr.name), stopCh, gr.mirrorEndpointSliceWatcher.HasSynced); !ok {
+	if ok := cache.WaitForNamedCacheSync(fmt.Sprintf("mirror-%s-endpointSliceWatcher", gr.name), ctx.Done(), gr.mirrorEndpointSliceWatcher.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for mirror endpintslices caches to sync")
 	}
+	// The runner is initialised once all its caches have synced
+	gr.initialised.Store(true)
+
+	log.Logger.Info("waiting for leadership to start reconciling", "runner", gr.name)
+	select {
+	case <-gr.elected:
+	case <-ctx.Done():
+		return fmt.Errorf("stopped while waiting for leadership: %v", ctx.Err())
+	}
+
+	// Migrate before syncing, since the sync would delete legacy
+	// endpointslices as orphans before their replacements exist
+	if err := gr.MigrateLegacyEndpointSlices(); err != nil {
+		log.Logger.Warn(
+			"Error migrating legacy endpointslices, skipping..",
+			"err", err,
+			"runner", gr.name,
+		)
+	}
+
 	// After endpointslice store syncs, perform a sync to delete stale mirrors
 	if gr.sync {
 		log.Logger.Info("Syncing endpointslices", "runner", gr.name)
@@ -131,29 +249,156 @@ func (gr *GlobalRunner) Run() error {
 				"runner", gr.name,
 			)
 		}
+		if err := gr.ExcludedServiceSync(); err != nil {
+			log.Logger.Warn(
+				"Error syncing global services in excluded namespaces, skipping..",
+				"err", err,
+				"runner", gr.name,
+			)
+		}
 	}
 
 	go gr.serviceQueue.Run()
 	go gr.endpointSliceQueue.Run()
+	go gr.statusQueue.Run()
+	go gr.finalizerQueue.Run()
+	go runGarbageCollection(ctx, gr.gcStop, gr.gcInterval, gr.name, gr.GarbageCollect)
+	go gr.staleEndpoints.Run(ctx, gr.name, gr.requeueEndpointSlices)
 
 	return nil
 }
 
-// Stop stops watchers and runners
+// Stop stops garbage collection, queues and watchers. It waits for in-flight
+// reconciles to finish before stopping the watchers.
 func (gr *GlobalRunner) Stop() {
+	close(gr.gcStop)
+	gr.staleEndpoints.Stop()
 	gr.serviceQueue.Stop()
-	gr.serviceWatcher.Stop()
 	gr.endpointSliceQueue.Stop()
+	gr.statusQueue.Stop()
+	gr.finalizerQueue.Stop()
+	gr.serviceWatcher.Stop()
+	gr.globalServiceWatcher.Stop()
 	gr.endpointSliceWatcher.Stop()
+	gr.mirrorEndpointSliceWatcher.Stop()
+	if gr.serviceExportWatcher != nil {
+		gr.serviceExportWatcher.Stop()
+	}
+}
+
+// Cleanup removes the runner's cluster from all global services and deletes the
+// endpointslices mirrored from it. It is meant to be called after the runner is
+// stopped, when the remote cluster is removed from the configuration. The
+// global services are taken from the store rather than the remote cache, which
+// is empty if the cluster was unreachable.
+func (gr *GlobalRunner) Cleanup() error {
+	for _, gsvc := range gr.globalServiceStore.ClusterServices(gr.name) {
+		if err := gr.removeServiceTarget(gsvc.name, gsvc.namespace); err != nil {
+			return err
+		}
+	}
+	endpointSlices, err := gr.client.DiscoveryV1().EndpointSlices(gr.namespace).List(
+		gr.ctx,
+		metav1.ListOptions{LabelSelector: labels.Set(gr.syncMirrorLabels).String()},
+	)
+	if err != nil {
+		return fmt.Errorf("listing mirrored endpointslices: %v", err)
+	}
+	for _, es := range endpointSlices.Items {
+		log.Logger.Info(
+			"Deleting mirrored endpointslice",
+			"endpointslice", es.Name,
+			"runner", gr.name,
+		)
+		if err := gr.deleteEndpointSlice(es.Name, es.Namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", es.Namespace, es.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeServiceTarget removes the runner's cluster from a global service. It
+// deletes the local service if no clusters are left, or updates it otherwise.
+func (gr *GlobalRunner) removeServiceTarget(name, namespace string) error {
+	globalSvcName := generateGlobalServiceName(name, namespace)
+	gsvc := gr.globalServiceStore.DeleteClusterServiceTarget(name, namespace, gr.name)
+	gr.removeStatus(name, namespace, gsvc)
+	if gsvc == nil {
+		log.Logger.Info("global service has no more targets, deleting local service", "namespace", gr.namespace, "name", globalSvcName, "runner", gr.name)
+		if err := gr.deleteGlobalService(globalSvcName, name, namespace); err != nil {
+			return err
+		}
+		return gr.deleteServiceImport(name, namespace)
+	}
+	globalSvc, err := kube.GetService(gr.ctx, gr.client, globalSvcName, gr.namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting service %s/%s: %v", gr.namespace, globalSvcName, err)
+	}
+	globalSvc, err = gr.updateGlobalService(globalSvc, gsvc)
+	if err != nil {
+		return fmt.Errorf("updating service %s/%s: %v", gr.namespace, globalSvcName, err)
+	}
+	return gr.reconcileServiceImport(gsvc, globalSvc)
+}
+
+// ExcludedServiceSync removes the runner's cluster from global services in
+// excluded namespaces, which may have been added before the namespace was
+// excluded
+func (gr *GlobalRunner) ExcludedServiceSync() error {
+	svcs, err := gr.serviceWatcher.List()
+	if err != nil {
+		return err
+	}
+	for _, svc := range svcs {
+		if gr.namespaceFilter.Allowed(svc.Namespace) {
+			continue
+		}
+		gsvc, err := gr.globalServiceStore.Get(svc.Name, svc.Namespace)
+		if err != nil {
+			continue
+		}
+		if _, found := inSlice(gsvc.clusters, gr.name); !found {
+			continue
+		}
+		log.Logger.Info("removing cluster from global service in excluded namespace", "namespace", svc.Namespace, "name", svc.Name, "runner", gr.name)
+		if err := gr.removeServiceTarget(svc.Name, svc.Namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeueEndpointSlices queues all the remote endpointslices to reconcile their
+// mirrors
+func (gr *GlobalRunner) requeueEndpointSlices() {
+	endpointSlices, err := gr.endpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote endpointslices", "err", err, "runner", gr.name)
+		return
+	}
+	for _, es := range endpointSlices {
+		if gr.namespaceFilter.Allowed(es.Namespace) {
+			gr.endpointSliceQueue.Add(es)
+		}
+	}
 }
 
 // Initialised returns true when the runner is successfully initialised
 func (gr *GlobalRunner) Initialised() bool {
-	return gr.initialised
+	return gr.initialised.Load()
 }
 
-func (gr *GlobalRunner) reconcileGlobalService(name, namespace string) error {
+func (gr *GlobalRunner) reconcileGlobalService(name, namespace string) (err error) {
 	globalSvcName := generateGlobalServiceName(name, namespace)
+	defer func() {
+		if err != nil {
+			kube.EventRecorder.Eventf(gr.eventTarget(globalSvcName), v1.EventTypeWarning, eventReasonReconcileFailed, "Failed to mirror service %s/%s from cluster %s: %v", namespace, name, gr.name, err)
+		}
+		gr.status.RecordReconcile(name, namespace, err)
+		gr.queueStatus(name, namespace)
+	}()
 	// Get the remote service
 	log.Logger.Info("getting remote service", "namespace", namespace, "name", name, "runner", gr.name)
 	remoteSvc, err := gr.getRemoteService(name, namespace)
@@ -167,10 +412,11 @@ func (gr *GlobalRunner) reconcileGlobalService(name, namespace string) error {
 		// continue
 		if gsvc == nil {
 			log.Logger.Info("global service not found, deleting local service", "namespace", gr.namespace, "name", globalSvcName, "runner", gr.name)
-			if err := kube.DeleteService(gr.ctx, gr.client, globalSvcName, gr.namespace); err != nil && !errors.IsNotFound(err) {
-				return fmt.Errorf("deleting service %s/%s: %v", gr.namespace, globalSvcName, err)
+			if err := gr.deleteGlobalService(globalSvcName, name, namespace); err != nil {
+				return err
 			}
-			return nil // return on successful service deletion, nothing else to do here.
+			// return on successful service deletion, nothing else to do here.
+			return gr.deleteServiceImport(name, namespace)
 		}
 	} else if err != nil {
 		return fmt.Errorf("getting remote service: %v", err)
@@ -178,9 +424,15 @@ func (gr *GlobalRunner) reconcileGlobalService(name, namespace string) error {
 	// If the remote service wasn't deleted, try to add it to the store
 	if remoteSvc != nil {
 		setServiceTopologyHints := matchSelector(gr.routingStrategyLabel, remoteSvc)
-		_, err := gr.globalServiceStore.AddOrUpdateClusterServiceTarget(remoteSvc, gr.name, setServiceTopologyHints)
-		if err != nil {
-			return fmt.Errorf("failed to create/update service: %v", err)
+		gsvc := gr.globalServiceStore.AddOrUpdateClusterServiceTarget(
+			remoteSvc,
+			gr.name,
+			setServiceTopologyHints,
+			gr.propagation.Labels(remoteSvc.Labels),
+			gr.propagation.Annotations(remoteSvc.Annotations),
+		)
+		if len(gsvc.conflicts) > 0 {
+			log.Logger.Warn("conflicting global service definitions", "namespace", namespace, "name", name, "conflicts", strings.Join(gsvc.conflicts, "; "), "runner", gr.name)
 		}
 	}
 	gsvc, err := gr.globalServiceStore.Get(name, namespace)
@@ -192,32 +444,101 @@ func (gr *GlobalRunner) reconcileGlobalService(name, namespace string) error {
 	globalSvc, err := kube.GetService(gr.ctx, gr.client, globalSvcName, gr.namespace)
 	if errors.IsNotFound(err) {
 		log.Logger.Info("local service not found, creating service", "namespace", gr.namespace, "name", gsvc.name, "runner", gr.name)
-		if _, err := kube.CreateService(gr.ctx, gr.client, globalSvcName, gr.namespace, gsvc.labels, gsvc.annotations, remoteSvc.Spec.Ports, gsvc.headless); err != nil {
+		meta := globalServiceMetadata(&metav1.ObjectMeta{Labels: mergeMetadata(gsvc.labels)}, gsvc)
+		globalSvc, err = kube.CreateService(gr.ctx, gr.client, globalSvcName, gr.namespace, meta.Labels, meta.Annotations, gsvc.spec)
+		if err != nil {
 			return fmt.Errorf("creating service %s/%s: %v", gr.namespace, globalSvcName, err)
 		}
+		kube.EventRecorder.Eventf(globalSvc, v1.EventTypeNormal, eventReasonCreated, "Created global service of %s/%s from clusters %s", namespace, name, strings.Join(gsvc.clusters, ","))
+		if len(fullGlobalServiceName(name, namespace)) > maxNameLength {
+			kube.EventRecorder.Eventf(globalSvc, v1.EventTypeWarning, eventReasonNameTooLong, "Global service name of %s/%s is longer than %d characters and was shortened", namespace, name, maxNameLength)
+		}
 	} else if err != nil {
 		return fmt.Errorf("getting service %s/%s: %v", gr.namespace, globalSvcName, err)
+	} else if globalSvc.DeletionTimestamp != nil {
+		// Retry until the deletion completes, to create the service again
+		return fmt.Errorf("service %s/%s is being deleted", gr.namespace, globalSvcName)
 	} else {
 		log.Logger.Info("local service found, updating service", "namespace", gr.namespace, "name", gsvc.name, "runner", gr.name)
-		if _, err := gr.updateGlobalService(globalSvc, gsvc.ports, gsvc.annotations); err != nil {
+		globalSvc, err = gr.updateGlobalService(globalSvc, gsvc)
+		if err != nil {
 			return fmt.Errorf("updating service %s/%s: %v", gr.namespace, globalSvcName, err)
 		}
 	}
-	return nil
+	if globalSvc, err = kube.SetServiceFinalizer(gr.ctx, gr.client, globalSvc, serviceFinalizer, gr.finalizer); err != nil {
+		return fmt.Errorf("setting finalizer of service %s/%s: %v", gr.namespace, globalSvcName, err)
+	}
+	recordConflictEvents(globalSvc, gsvc.conflicts)
+	return gr.reconcileServiceImport(gsvc, globalSvc)
 }
 
+// getRemoteService returns the remote service from the cache. Services that are
+// not exported are reported as not found.
 func (gr *GlobalRunner) getRemoteService(name, namespace string) (*v1.Service, error) {
-	return gr.serviceWatcher.Get(name, namespace)
+	svc, err := gr.serviceWatcher.Get(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !gr.exported(name, namespace) {
+		return nil, errors.NewNotFound(kube.ServiceExportResource.GroupResource(), namespace+"/"+name)
+	}
+	return svc, nil
 }
 
-// updateGlobalService is UpdateService that will also update the annotations to reflect clusters
-func (gr *GlobalRunner) updateGlobalService(service *v1.Service, ports []v1.ServicePort, annotations map[string]string) (*v1.Service, error) {
-	service.ObjectMeta.Annotations = annotations
-	return kube.UpdateService(gr.ctx, gr.client, service, ports)
+// updateGlobalService is UpdateService that will also update the labels and
+// annotations to reflect clusters and propagated metadata. The service is
+// recreated if immutable fields changed, and the runners of all clusters
+// requeue their endpointslices when they see the new service.
+func (gr *GlobalRunner) updateGlobalService(service *v1.Service, gsvc *GlobalService) (*v1.Service, error) {
+	meta := globalServiceMetadata(service.ObjectMeta.DeepCopy(), gsvc)
+	updated, err := kube.UpdateService(gr.ctx, gr.client, service, meta.Labels, meta.Annotations, gsvc.spec)
+	if err == nil && updated != service {
+		kube.EventRecorder.Eventf(updated, v1.EventTypeNormal, eventReasonUpdated, "Updated global service of %s/%s from clusters %s", gsvc.namespace, gsvc.name, strings.Join(gsvc.clusters, ","))
+	}
+	if err == kube.ErrServiceRecreateRequired {
+		return recreateService(gr.ctx, gr.client, gr.name, service, meta.Labels, meta.Annotations, gsvc.spec)
+	}
+	return updated, err
+}
+
+// globalServiceMetadata sets the labels and annotations of a global service.
+// The controller owned annotations replace all annotations that were not
+// propagated, so that annotations of dropped features do not linger. The
+// global service labels are never overwritten or removed.
+func globalServiceMetadata(meta *metav1.ObjectMeta, gsvc *GlobalService) *metav1.ObjectMeta {
+	propagateMetadata(meta, gsvc.propagatedLabels, gsvc.propagatedAnnotations, gsvc.labels, gsvc.annotations)
+	annotations := map[string]string{}
+	for _, anno := range []string{propagatedLabelsAnno, propagatedAnnotationsAnno} {
+		if v, ok := meta.Annotations[anno]; ok {
+			annotations[anno] = v
+		}
+	}
+	for _, k := range propagatedKeys(meta.Annotations[propagatedAnnotationsAnno]) {
+		annotations[k] = meta.Annotations[k]
+	}
+	for k, v := range gsvc.annotations {
+		annotations[k] = v
+	}
+	meta.Annotations = annotations
+	return meta
 }
 
 // ServiceEventHandler adds Service resource events to the respective queue
 func (gr *GlobalRunner) ServiceEventHandler(eventType watch.EventType, old *v1.Service, new *v1.Service) {
+	if namespace := eventNamespace(eventType, old, new); !gr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping service event from excluded namespace", "namespace", namespace, "runner", gr.name)
+		return
+	}
+	// Exporting and unexporting services is handled by the ServiceExport
+	// events
+	svc := new
+	if eventType == watch.Deleted {
+		svc = old
+	}
+	if !gr.exported(svc.Name, svc.Namespace) {
+		log.Logger.Debug("skipping event of service that is not exported", "namespace", svc.Namespace, "name", svc.Name, "runner", gr.name)
+		return
+	}
 	switch eventType {
 	case watch.Added:
 		log.Logger.Debug("service added", "namespace", new.Namespace, "name", new.Name, "runner", gr.name)
@@ -233,53 +554,144 @@ func (gr *GlobalRunner) ServiceEventHandler(eventType watch.EventType, old *v1.S
 	}
 }
 
+// getRemoteEndpointSlice returns the remote endpointslice from the cache.
+// Endpointslices of services that are not exported are reported as not found.
 func (gr *GlobalRunner) getRemoteEndpointSlice(name, namespace string) (*discoveryv1.EndpointSlice, error) {
-	return gr.endpointSliceWatcher.Get(name, namespace)
+	es, err := gr.endpointSliceWatcher.Get(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !gr.endpointSliceExported(es) {
+		return nil, errors.NewNotFound(kube.ServiceExportResource.GroupResource(), namespace+"/"+es.Labels["kubernetes.io/service-name"])
+	}
+	return es, nil
 }
 
-// EndpointSliceSync checks for stale mirrors (endpointslices) under the local
-// namespace and deletes them
-func (gr *GlobalRunner) EndpointSliceSync() error {
+// orphanEndpointSlices returns the names of mirrored endpointslices under the
+// local namespace whose remote endpointslice does not exist or is in an
+// excluded namespace
+func (gr *GlobalRunner) orphanEndpointSlices() ([]string, error) {
 	storeEnpointSlices, err := gr.endpointSliceWatcher.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mirrorEndpointSliceList := []string{}
 	for _, es := range storeEnpointSlices {
+		if !gr.namespaceFilter.Allowed(es.Namespace) || !gr.endpointSliceExported(es) {
+			continue
+		}
 		mirrorEndpointSliceList = append(
 			mirrorEndpointSliceList,
-			generateGlobalEndpointSliceName(es.Name),
+			generateGlobalEndpointSliceName(gr.name, es.Namespace, es.Name),
 		)
 	}
 
 	currEndpointSlices, err := gr.mirrorEndpointSliceWatcher.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	orphans := []string{}
 	for _, es := range currEndpointSlices {
-		_, inSlice := inSlice(mirrorEndpointSliceList, es.Name)
-		if !inSlice {
-			log.Logger.Info(
-				"Deleting old endpointslice",
-				"service", es.Name,
-				"runner", gr.name,
-			)
-			if err := gr.deleteEndpointSlice(es.Name, es.Namespace); err != nil {
-				log.Logger.Error(
-					"Error clearing endpointslice",
-					"endpointslice", es.Name,
-					"err", err,
-					"runner", gr.name,
-				)
-				return err
-			}
+		if _, inSlice := inSlice(mirrorEndpointSliceList, es.Name); !inSlice {
+			orphans = append(orphans, es.Name)
+		}
+	}
+	return orphans, nil
+}
+
+// EndpointSliceSync checks for stale mirrors (endpointslices) under the local
+// namespace and deletes them
+func (gr *GlobalRunner) EndpointSliceSync() error {
+	orphans, err := gr.orphanEndpointSlices()
+	if err != nil {
+		return err
+	}
+	for _, name := range orphans {
+		if err := gr.deleteOrphanEndpointSlice(name); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// MigrateLegacyEndpointSlices replaces mirrored endpointslices named after the
+// legacy gl-<name> scheme with endpointslices named after the cluster and
+// namespace of the remote endpointslice. The new endpointslice is created
+// before the legacy one is deleted, so that the global service does not lose
+// endpoints in between.
+func (gr *GlobalRunner) MigrateLegacyEndpointSlices() error {
+	remoteEndpointSlices, err := gr.endpointSliceWatcher.List()
+	if err != nil {
+		return err
+	}
+	mirroredEndpointSlices, err := gr.mirrorEndpointSliceWatcher.List()
+	if err != nil {
+		return err
+	}
+	mirrored := map[string]bool{}
+	for _, es := range mirroredEndpointSlices {
+		mirrored[es.Name] = true
+	}
+	// Legacy names that match a current name belong to another remote
+	// endpointslice and must be kept
+	current := map[string]bool{}
+	for _, es := range remoteEndpointSlices {
+		current[generateGlobalEndpointSliceName(gr.name, es.Namespace, es.Name)] = true
+	}
+	for _, es := range remoteEndpointSlices {
+		if !gr.namespaceFilter.Allowed(es.Namespace) {
+			continue
+		}
+		legacyName := legacyGlobalEndpointSliceName(es.Name)
+		if !mirrored[legacyName] || current[legacyName] {
+			continue
+		}
+		log.Logger.Info("migrating legacy endpointslice", "namespace", es.Namespace, "name", es.Name, "legacy", legacyName, "runner", gr.name)
+		if err := gr.reconcileEndpointSlice(es.Name, es.Namespace); err != nil {
+			return fmt.Errorf("migrating endpointslice %s/%s: %v", es.Namespace, es.Name, err)
+		}
+		if err := gr.deleteEndpointSlice(legacyName, gr.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting legacy endpointslice %s/%s: %v", gr.namespace, legacyName, err)
+		}
+	}
+	return nil
+}
+
+func (gr *GlobalRunner) deleteOrphanEndpointSlice(name string) error {
+	log.Logger.Info(
+		"Deleting old endpointslice",
+		"service", name,
+		"runner", gr.name,
+	)
+	target := gr.mirrorEndpointSliceService(name)
+	if err := gr.deleteEndpointSlice(name, gr.namespace); err != nil && !errors.IsNotFound(err) {
+		log.Logger.Error(
+			"Error clearing endpointslice",
+			"endpointslice", name,
+			"err", err,
+			"runner", gr.name,
+		)
+		return err
+	}
+	if target != nil {
+		kube.EventRecorder.Eventf(target, v1.EventTypeNormal, eventReasonGarbageCollected, "Deleted orphaned endpointslice %s, its remote endpointslice is not mirrored from cluster %s anymore", name, gr.name)
+	}
+	return nil
+}
+
+// GarbageCollect deletes mirrored endpointslices that have been orphaned for
+// longer than the grace period
+func (gr *GlobalRunner) GarbageCollect() {
+	// An unsynced remote cache would make all mirrors look orphaned
+	if !gr.endpointSliceWatcher.HasSynced() {
+		log.Logger.Warn("remote endpointslice cache not synced, skipping garbage collection", "runner", gr.name)
+		return
+	}
+	gr.gc.collect("endpointslice", gr.orphanEndpointSlices, gr.deleteOrphanEndpointSlice)
+}
+
 func (gr *GlobalRunner) getEndpointSlice(name, namespace string) (*discoveryv1.EndpointSlice, error) {
 	return gr.client.DiscoveryV1().EndpointSlices(namespace).Get(
 		gr.ctx,
@@ -319,9 +731,10 @@ func (gr *GlobalRunner) createEndpointSlice(name, namespace, targetService strin
 		gr.ctx,
 		&discoveryv1.EndpointSlice{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: namespace,
-				Labels:    generateEndpointSliceLabels(gr.syncMirrorLabels, targetService),
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          generateEndpointSliceLabels(gr.syncMirrorLabels, targetService),
+				OwnerReferences: gr.ownerReferences(targetService),
 			},
 			AddressType: at,
 			Endpoints:   gr.ensureEndpointSliceZones(endpoints),
@@ -336,9 +749,10 @@ func (gr *GlobalRunner) updateEndpointSlice(name, namespace, targetService strin
 		gr.ctx,
 		&discoveryv1.EndpointSlice{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: namespace,
-				Labels:    generateEndpointSliceLabels(gr.syncMirrorLabels, targetService),
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          generateEndpointSliceLabels(gr.syncMirrorLabels, targetService),
+				OwnerReferences: gr.ownerReferences(targetService),
 			},
 			AddressType: at,
 			Endpoints:   gr.ensureEndpointSliceZones(endpoints),
@@ -348,6 +762,19 @@ func (gr *GlobalRunner) updateEndpointSlice(name, namespace, targetService strin
 	)
 }
 
+// ownerReferences returns the owner references of the endpointslices of a
+// global service, so that they are deleted with it. The service is read from
+// the cache, which may lag behind a recreated service: GlobalServiceEventHandler
+// requeues the endpointslices once the cache holds the new service, which
+// repairs their owner. Returns nil if the service is not cached yet.
+func (gr *GlobalRunner) ownerReferences(globalSvcName string) []metav1.OwnerReference {
+	svc, err := gr.globalServiceWatcher.Get(globalSvcName, gr.namespace)
+	if err != nil {
+		return nil
+	}
+	return kube.ServiceOwnerReferences(svc)
+}
+
 func (gr *GlobalRunner) deleteEndpointSlice(name, namespace string) error {
 	return gr.client.DiscoveryV1().EndpointSlices(namespace).Delete(
 		gr.ctx,
@@ -356,8 +783,16 @@ func (gr *GlobalRunner) deleteEndpointSlice(name, namespace string) error {
 	)
 }
 
-func (gr *GlobalRunner) reconcileEndpointSlice(name, namespace string) error {
-	mirrorName := generateGlobalEndpointSliceName(name)
+func (gr *GlobalRunner) reconcileEndpointSlice(name, namespace string) (err error) {
+	mirrorName := generateGlobalEndpointSliceName(gr.name, namespace, name)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if target := gr.endpointSliceEventTarget(mirrorName, name, namespace); target != nil {
+			kube.EventRecorder.Eventf(target, v1.EventTypeWarning, eventReasonReconcileFailed, "Failed to mirror endpointslice %s/%s from cluster %s: %v", namespace, name, gr.name, err)
+		}
+	}()
 	// Get the remote endpointslice
 	log.Logger.Info("getting remote endpointslice", "namespace", namespace, "name", name, "runner", gr.name)
 	remoteEndpointSlice, err := gr.getRemoteEndpointSlice(name, namespace)
@@ -382,7 +817,7 @@ func (gr *GlobalRunner) reconcileEndpointSlice(name, namespace string) error {
 	_, err = gr.getEndpointSlice(mirrorName, gr.namespace)
 	if errors.IsNotFound(err) {
 		log.Logger.Info("local endpointslice not found, creating", "namespace", gr.namespace, "name", mirrorName, "runner", gr.name)
-		if _, err := gr.createEndpointSlice(mirrorName, gr.namespace, targetGlobalService, remoteEndpointSlice.AddressType, remoteEndpointSlice.Endpoints, remoteEndpointSlice.Ports); err != nil {
+		if _, err := gr.createEndpointSlice(mirrorName, gr.namespace, targetGlobalService, remoteEndpointSlice.AddressType, gr.staleEndpoints.Endpoints(remoteEndpointSlice.Endpoints), remoteEndpointSlice.Ports); err != nil {
 			return fmt.Errorf("creating endpointslice %s/%s: %v", gr.namespace, mirrorName, err)
 
 		}
@@ -390,26 +825,222 @@ func (gr *GlobalRunner) reconcileEndpointSlice(name, namespace string) error {
 		return fmt.Errorf("getting endpointslice %s/%s: %v", gr.namespace, mirrorName, err)
 	} else {
 		log.Logger.Info("local endpointslice found, updating", "namespace", gr.namespace, "name", mirrorName, "runner", gr.name)
-		if _, err := gr.updateEndpointSlice(mirrorName, gr.namespace, targetGlobalService, remoteEndpointSlice.AddressType, remoteEndpointSlice.Endpoints, remoteEndpointSlice.Ports); err != nil {
+		if _, err := gr.updateEndpointSlice(mirrorName, gr.namespace, targetGlobalService, remoteEndpointSlice.AddressType, gr.staleEndpoints.Endpoints(remoteEndpointSlice.Endpoints), remoteEndpointSlice.Ports); err != nil {
 			return fmt.Errorf("updating endpointslice %s/%s: %v", gr.namespace, mirrorName, err)
 		}
 	}
 	return nil
 }
 
+// finalizeService deletes the endpointslices mirrored from all clusters and the
+// status resource of a global service that is being deleted and removes its
+// finalizer, to let the deletion complete
+func (gr *GlobalRunner) finalizeService(name, namespace string) error {
+	svc, err := gr.globalServiceWatcher.Get(name, namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting service %s/%s: %v", namespace, name, err)
+	}
+	if !finalizing(svc) {
+		return nil
+	}
+	log.Logger.Info("cleaning up after deleted service", "namespace", namespace, "name", name, "runner", gr.name)
+	endpointSlices, err := gr.client.DiscoveryV1().EndpointSlices(namespace).List(
+		gr.ctx,
+		metav1.ListOptions{LabelSelector: labels.Set{
+			"kubernetes.io/service-name": name,
+			"mirrored-endpoint-slice":    "true",
+		}.String()},
+	)
+	if err != nil {
+		return fmt.Errorf("listing endpointslices of service %s/%s: %v", namespace, name, err)
+	}
+	for _, es := range endpointSlices.Items {
+		if err := gr.deleteEndpointSlice(es.Name, es.Namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", es.Namespace, es.Name, err)
+		}
+	}
+	if err := gr.status.DeleteGlobalService(gr.ctx, name); err != nil {
+		return err
+	}
+	if _, err := kube.SetServiceFinalizer(gr.ctx, gr.client, svc, serviceFinalizer, false); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("removing finalizer of service %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// GlobalServiceEventHandler queues global services that are being deleted to
+// clean up after them. For new or recreated global services, and ones with
+// endpointslices mirrored from the runner's cluster that they do not own, it
+// queues the remote endpointslices to set the owner of their mirrors.
+func (gr *GlobalRunner) GlobalServiceEventHandler(eventType watch.EventType, old *v1.Service, new *v1.Service) {
+	switch eventType {
+	case watch.Added, watch.Modified:
+		if finalizing(new) {
+			log.Logger.Debug("global service deleted, queueing clean up", "namespace", new.Namespace, "name", new.Name, "runner", gr.name)
+			gr.finalizerQueue.Add(new)
+			return
+		}
+		if new.DeletionTimestamp != nil {
+			return
+		}
+		if eventType == watch.Added || old.UID != new.UID || !gr.endpointSlicesOwnedBy(new) {
+			gr.requeueGlobalServiceEndpointSlices(new.Name)
+		}
+	}
+}
+
+// endpointSlicesOwnedBy returns true if all the endpointslices mirrored by the
+// runner for a global service are owned by it
+func (gr *GlobalRunner) endpointSlicesOwnedBy(globalSvc *v1.Service) bool {
+	endpointSlices, err := gr.mirrorEndpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing mirrored endpointslices", "err", err, "runner", gr.name)
+		return true
+	}
+	for _, es := range endpointSlices {
+		if es.Labels["kubernetes.io/service-name"] == globalSvc.Name && !kube.OwnedByService(es.OwnerReferences, globalSvc) {
+			return false
+		}
+	}
+	return true
+}
+
+// requeueGlobalServiceEndpointSlices queues the remote endpointslices that are
+// mirrored for a global service
+func (gr *GlobalRunner) requeueGlobalServiceEndpointSlices(globalSvcName string) {
+	endpointSlices, err := gr.endpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote endpointslices", "err", err, "runner", gr.name)
+		return
+	}
+	for _, es := range endpointSlices {
+		name, ok := es.Labels["kubernetes.io/service-name"]
+		if !ok || generateGlobalServiceName(name, es.Namespace) != globalSvcName {
+			continue
+		}
+		if gr.namespaceFilter.Allowed(es.Namespace) && gr.endpointSliceExported(es) {
+			gr.endpointSliceQueue.Add(es)
+		}
+	}
+}
+
 // EndpointSliceEventHandler adds EndpointSlice resource events to the respective queue
 func (gr *GlobalRunner) EndpointSliceEventHandler(eventType watch.EventType, old *discoveryv1.EndpointSlice, new *discoveryv1.EndpointSlice) {
+	if namespace := eventNamespace(eventType, old, new); !gr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping endpointslice event from excluded namespace", "namespace", namespace, "runner", gr.name)
+		return
+	}
+	es := new
+	if eventType == watch.Deleted {
+		es = old
+	}
+	if !gr.endpointSliceExported(es) {
+		log.Logger.Debug("skipping event of endpointslice of a service that is not exported", "namespace", es.Namespace, "name", es.Name, "runner", gr.name)
+		return
+	}
 	switch eventType {
 	case watch.Added:
 		log.Logger.Debug("endpoints added", "namespace", new.Namespace, "name", new.Name, "runner", gr.name)
 		gr.endpointSliceQueue.Add(new)
+		gr.queueStatus(new.Labels["kubernetes.io/service-name"], new.Namespace)
 	case watch.Modified:
 		log.Logger.Debug("endpoints modified", "namespace", new.Namespace, "name", new.Name, "runner", gr.name)
 		gr.endpointSliceQueue.Add(new)
+		gr.queueStatus(new.Labels["kubernetes.io/service-name"], new.Namespace)
 	case watch.Deleted:
 		log.Logger.Debug("endpoints deleted", "namespace", old.Namespace, "name", old.Name, "runner", gr.name)
 		gr.endpointSliceQueue.Add(old)
+		gr.queueStatus(old.Labels["kubernetes.io/service-name"], old.Namespace)
 	default:
 		log.Logger.Info("Unknown endpoints event received: %v", eventType, "runner", gr.name)
 	}
 }
+
+// queueStatus queues the remote service to report its state in the global
+// service
+func (gr *GlobalRunner) queueStatus(name, namespace string) {
+	if gr.status == nil || name == "" {
+		return
+	}
+	gr.statusQueue.Add(&metav1.ObjectMeta{Name: name, Namespace: namespace})
+}
+
+// reconcileStatus reports the state of a remote service in the status of its
+// global service, or removes the runner's cluster from it if the service is
+// gone
+func (gr *GlobalRunner) reconcileStatus(name, namespace string) error {
+	globalSvcName := generateGlobalServiceName(name, namespace)
+	var conflicts []string
+	if gsvc, err := gr.globalServiceStore.Get(name, namespace); err == nil {
+		conflicts = gsvc.conflicts
+	}
+	remoteSvc, err := gr.getRemoteService(name, namespace)
+	if errors.IsNotFound(err) {
+		return gr.status.RemoveGlobalServiceCluster(gr.ctx, globalSvcName, name, namespace, conflicts)
+	} else if err != nil {
+		return fmt.Errorf("getting remote service: %v", err)
+	}
+	endpointSlices, err := gr.endpointSliceWatcher.List()
+	if err != nil {
+		return fmt.Errorf("listing remote endpointslices: %v", err)
+	}
+	endpoints, ready := countEndpointSliceEndpoints(endpointSlices, name, namespace)
+	return gr.status.ReportGlobalService(gr.ctx, globalSvcName, remoteSvc, endpoints, ready, conflicts)
+}
+
+// removeStatus removes the runner's cluster from the status of a global
+// service. It is called while the queues may be stopped, so errors are only
+// logged.
+func (gr *GlobalRunner) removeStatus(name, namespace string, gsvc *GlobalService) {
+	var conflicts []string
+	if gsvc != nil {
+		conflicts = gsvc.conflicts
+	}
+	if err := gr.status.RemoveGlobalServiceCluster(gr.ctx, generateGlobalServiceName(name, namespace), name, namespace, conflicts); err != nil {
+		log.Logger.Warn("removing cluster from global service status", "namespace", namespace, "name", name, "err", err, "runner", gr.name)
+	}
+}
+
+// deleteGlobalService deletes a global service that no cluster exports anymore
+func (gr *GlobalRunner) deleteGlobalService(globalSvcName, name, namespace string) error {
+	target := gr.eventTarget(globalSvcName)
+	if err := kube.DeleteService(gr.ctx, gr.client, globalSvcName, gr.namespace); err == nil {
+		kube.EventRecorder.Eventf(target, v1.EventTypeNormal, eventReasonDeleted, "Deleted global service of %s/%s, which is not mirrored from any cluster anymore", namespace, name)
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting service %s/%s: %v", gr.namespace, globalSvcName, err)
+	}
+	return nil
+}
+
+// eventTarget returns the global service to record events on
+func (gr *GlobalRunner) eventTarget(globalSvcName string) runtime.Object {
+	svc, err := gr.globalServiceWatcher.Get(globalSvcName, gr.namespace)
+	return serviceEventTarget(svc, err, globalSvcName, gr.namespace)
+}
+
+// endpointSliceEventTarget returns the global service of a remote endpointslice
+// to record events on, or nil if the service is unknown
+func (gr *GlobalRunner) endpointSliceEventTarget(mirrorName, name, namespace string) runtime.Object {
+	if es, err := gr.endpointSliceWatcher.Get(name, namespace); err == nil {
+		if svc, ok := es.Labels["kubernetes.io/service-name"]; ok {
+			return gr.eventTarget(generateGlobalServiceName(svc, namespace))
+		}
+	}
+	return gr.mirrorEndpointSliceService(mirrorName)
+}
+
+// mirrorEndpointSliceService returns the global service of a mirrored
+// endpointslice to record events on, or nil if the endpointslice is unknown
+func (gr *GlobalRunner) mirrorEndpointSliceService(name string) runtime.Object {
+	es, err := gr.mirrorEndpointSliceWatcher.Get(name, gr.namespace)
+	if err != nil {
+		return nil
+	}
+	svc, ok := es.Labels["kubernetes.io/service-name"]
+	if !ok {
+		return nil
+	}
+	return gr.eventTarget(svc)
+}
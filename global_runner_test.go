@@ -45,21 +45,19 @@ func TestAddSingleRemoteGlobalService(t *testing.T) {
 		},
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
-	testGlobalStore := newGlobalServiceStore()
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 
 	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
-	testRunner := newGlobalRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -107,21 +105,19 @@ func TestAddSingleRemoteGlobalHeadlessService(t *testing.T) {
 		},
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
-	testGlobalStore := newGlobalServiceStore()
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 
 	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
-	testRunner := newGlobalRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -171,7 +167,7 @@ func TestModifySingleRemoteGlobalService(t *testing.T) {
 		},
 	}
 	fakeClient := fake.NewSimpleClientset(existingSvc)
-	existingGlobalStore := newGlobalServiceStore()
+	existingGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 	existingGlobalStore.store[fmt.Sprintf("gl-remote-ns-%s-test-svc", Separator)] = &GlobalService{
 		name:        "test-svc",
 		namespace:   "remote-ns",
@@ -197,18 +193,16 @@ func TestModifySingleRemoteGlobalService(t *testing.T) {
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
 
 	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
-	testRunner := newGlobalRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		existingGlobalStore,
-		false,
-		selector,
-		false,
-	)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   existingGlobalStore,
+		routingStrategyLabel: selector,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -268,33 +262,29 @@ func TestAddGlobalServiceMultipleClusters(t *testing.T) {
 	}
 	fakeWatchClientA := fake.NewSimpleClientset(testSvcA)
 	fakeWatchClientB := fake.NewSimpleClientset(testSvcB)
-	testGlobalStore := newGlobalServiceStore()
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 
 	selector, _ := labels.Parse("mirror.semaphore.uw.io/test=true")
-	testRunnerA := newGlobalRunner(
-		fakeClient,
-		fakeWatchClientA,
-		"runnerA",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
-	testRunnerB := newGlobalRunner(
-		fakeClient,
-		fakeWatchClientB,
-		"runnerB",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
+	testRunnerA := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClientA,
+		name:                 "runnerA",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
+	testRunnerB := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClientB,
+		name:                 "runnerB",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
 
 	go testRunnerA.serviceWatcher.Run()
 	go testRunnerB.serviceWatcher.Run()
@@ -347,7 +337,7 @@ func TestDeleteGlobalServiceMultipleClusters(t *testing.T) {
 	existingSvc.Annotations[globalSvcClustersAnno] = "runnerA,runnerB"
 
 	fakeClient := fake.NewSimpleClientset(existingSvc)
-	testGlobalStore := newGlobalServiceStore()
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 	// Add the existing service into global store from both clusters
 	testLabels := globalSvcLabels
 	annotations := globalSvcAnnotations
@@ -359,6 +349,10 @@ func TestDeleteGlobalServiceMultipleClusters(t *testing.T) {
 		labels:      testLabels,
 		annotations: annotations,
 		clusters:    []string{"runnerA", "runnerB"},
+		views: map[string]clusterServiceView{
+			"runnerA": clusterServiceView{ports: existingPorts, topologyAwareHints: true},
+			"runnerB": clusterServiceView{ports: existingPorts, topologyAwareHints: true},
+		},
 	}
 
 	// Remote fake clients won't have any services as we are deleting
@@ -366,30 +360,26 @@ func TestDeleteGlobalServiceMultipleClusters(t *testing.T) {
 	fakeWatchClientB := fake.NewSimpleClientset()
 
 	selector, _ := labels.Parse("mirror.semaphore.uw.io/test=true")
-	testRunnerA := newGlobalRunner(
-		fakeClient,
-		fakeWatchClientA,
-		"runnerA",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
-	testRunnerB := newGlobalRunner(
-		fakeClient,
-		fakeWatchClientB,
-		"runnerB",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		false,
-	)
+	testRunnerA := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClientA,
+		name:                 "runnerA",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
+	testRunnerB := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClientB,
+		name:                 "runnerB",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
 
 	go testRunnerA.serviceWatcher.Run()
 	go testRunnerB.serviceWatcher.Run()
@@ -443,7 +433,7 @@ func TestEndpointSliceSync(t *testing.T) {
 	// Create mirrored endpointslice
 	mirroredEndpointSlice := &discoveryv1.EndpointSlice{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      generateGlobalEndpointSliceName("test-slice"),
+			Name:      generateGlobalEndpointSliceName("test-runner", "remote-ns", "test-slice"),
 			Namespace: "local-ns",
 			Labels:    generateEndpointSliceLabels(testMirrorLabels, "test-svc"),
 		},
@@ -451,7 +441,7 @@ func TestEndpointSliceSync(t *testing.T) {
 	// Create stale endpointslice
 	staleEndpointSlice := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      generateGlobalEndpointSliceName("old-slice"),
+			Name:      generateGlobalEndpointSliceName("test-runner", "remote-ns", "old-slice"),
 			Namespace: "local-ns",
 			Labels:    generateEndpointSliceLabels(testMirrorLabels, "test-svc"),
 		},
@@ -462,20 +452,19 @@ func TestEndpointSliceSync(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	testGlobalStore := newGlobalServiceStore()
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
-	testRunner := newGlobalRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		testGlobalSvcLabelString,
-		60*time.Minute,
-		testGlobalStore,
-		false,
-		selector,
-		true,
-	)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+		sync:                 true,
+	})
 	go testRunner.endpointSliceWatcher.Run()
 	go testRunner.mirrorEndpointSliceWatcher.Run()
 	cache.WaitForNamedCacheSync(fmt.Sprintf("gl-%s-endpointSliceWatcher", testRunner.name), ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
@@ -495,7 +484,262 @@ func TestEndpointSliceSync(t *testing.T) {
 	assert.Equal(t, 1, len(endpointslices.Items))
 	assert.Equal(
 		t,
-		generateGlobalEndpointSliceName("test-slice"),
+		generateGlobalEndpointSliceName("test-runner", "remote-ns", "test-slice"),
 		endpointslices.Items[0].Name,
 	)
 }
+
+func TestMigrateLegacyEndpointSlices(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	testMirrorLabels := map[string]string{
+		"mirrored-endpoint-slice":        "true",
+		"mirror-endpointslice-sync-name": "test-runner",
+	}
+	testPort := int32(80)
+	// EndpointSlice on the remote cluster
+	testEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc-abcde",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"kubernetes.io/service-name":            "test-svc",
+				"mirror.semaphore.uw.io/global-service": "true",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		Ports:       []discoveryv1.EndpointPort{{Port: &testPort}},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testEndpointSlice)
+
+	globalSvcName := generateGlobalServiceName("test-svc", "remote-ns")
+	// Endpointslice mirrored under the legacy name
+	legacyEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      legacyGlobalEndpointSliceName("test-svc-abcde"),
+			Namespace: "local-ns",
+			Labels:    generateEndpointSliceLabels(testMirrorLabels, globalSvcName),
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+	}
+	// Legacy endpointslice of another cluster
+	otherEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      legacyGlobalEndpointSliceName("test-svc-fghij"),
+			Namespace: "local-ns",
+			Labels: generateEndpointSliceLabels(map[string]string{
+				"mirrored-endpoint-slice":        "true",
+				"mirror-endpointslice-sync-name": "other-runner",
+			}, globalSvcName),
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(legacyEndpointSlice, otherEndpointSlice)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+		sync:                 true,
+	})
+	go testRunner.endpointSliceWatcher.Run()
+	go testRunner.mirrorEndpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync(fmt.Sprintf("gl-%s-endpointSliceWatcher", testRunner.name), ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync(fmt.Sprintf("mirror-%s-endpointSliceWatcher", testRunner.name), ctx.Done(), testRunner.mirrorEndpointSliceWatcher.HasSynced)
+
+	// The legacy endpointslice is replaced, the one of the other cluster is
+	// left alone
+	if err := testRunner.MigrateLegacyEndpointSlices(); err != nil {
+		t.Fatal(err)
+	}
+	endpointslices, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(
+		ctx,
+		metav1.ListOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, es := range endpointslices.Items {
+		names = append(names, es.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		generateGlobalEndpointSliceName("test-runner", "remote-ns", "test-svc-abcde"),
+		legacyGlobalEndpointSliceName("test-svc-fghij"),
+	}, names)
+	es, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(
+		ctx,
+		generateGlobalEndpointSliceName("test-runner", "remote-ns", "test-svc-abcde"),
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, globalSvcName, es.Labels["kubernetes.io/service-name"])
+	assert.Equal(t, []string{"10.0.0.1"}, es.Endpoints[0].Addresses)
+}
+
+func TestGlobalRunnerCleanup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	existingPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
+	existingSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("gl-remote-ns-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    globalSvcLabels,
+			Annotations: map[string]string{
+				globalSvcClustersAnno: "runnerA,runnerB",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     existingPorts,
+			ClusterIP: "",
+		},
+	}
+	// EndpointSlices mirrored from both clusters
+	endpointSliceA := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateGlobalEndpointSliceName("runnerA", "remote-ns", "test-slice-a"),
+			Namespace: "local-ns",
+			Labels: generateEndpointSliceLabels(map[string]string{
+				"mirrored-endpoint-slice":        "true",
+				"mirror-endpointslice-sync-name": "runnerA",
+			}, existingSvc.Name),
+		},
+	}
+	endpointSliceB := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateGlobalEndpointSliceName("runnerB", "remote-ns", "test-slice-b"),
+			Namespace: "local-ns",
+			Labels: generateEndpointSliceLabels(map[string]string{
+				"mirrored-endpoint-slice":        "true",
+				"mirror-endpointslice-sync-name": "runnerB",
+			}, existingSvc.Name),
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(existingSvc, endpointSliceA, endpointSliceB)
+
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    testGlobalSvcLabel,
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     existingPorts,
+			Selector:  testServiceSelector,
+			ClusterIP: "1.1.1.1",
+		},
+	}
+	fakeWatchClientA := fake.NewSimpleClientset(testSvc)
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	testGlobalStore.AddOrUpdateClusterServiceTarget(testSvc, "runnerA", false, nil, nil)
+	testGlobalStore.AddOrUpdateClusterServiceTarget(testSvc, "runnerB", false, nil, nil)
+
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunnerA := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClientA,
+		name:                 "runnerA",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
+	go testRunnerA.serviceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunnerA.serviceWatcher.HasSynced)
+
+	// Cleanup should remove cluster A from the global service and delete
+	// only the endpointslices mirrored from cluster A
+	if err := testRunnerA.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	expectedSvcs := []TestSvc{TestSvc{
+		Name:      fmt.Sprintf("gl-remote-ns-%s-test-svc", Separator),
+		Namespace: "local-ns",
+		Spec: TestSpec{
+			Ports:     existingPorts,
+			ClusterIP: "",
+			Selector:  nil,
+		},
+		Labels: testGlobalLabels,
+		Annotations: map[string]string{
+			globalSvcClustersAnno: "runnerB",
+		},
+	}}
+	assertExpectedGlobalServices(ctx, t, expectedSvcs, fakeClient)
+	endpointslices, err := fakeClient.DiscoveryV1().EndpointSlices("").List(
+		ctx,
+		metav1.ListOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(endpointslices.Items))
+	assert.Equal(t, generateGlobalEndpointSliceName("runnerB", "remote-ns", "test-slice-b"), endpointslices.Items[0].Name)
+}
+
+func TestGlobalRunnerCleanupUnsyncedCluster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	existingSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generateGlobalServiceName("test-svc", "remote-ns"),
+			Namespace:   "local-ns",
+			Labels:      globalSvcLabels,
+			Annotations: map[string]string{globalSvcClustersAnno: "runnerA,runnerB"},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 1}}},
+	}
+	fakeClient := fake.NewSimpleClientset(existingSvc)
+	testSvc := createTestService("test-svc", "remote-ns", "1.1.1.1", []int32{1})
+	testGlobalStore := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	testGlobalStore.AddOrUpdateClusterServiceTarget(testSvc, "runnerA", false, nil, nil)
+	testGlobalStore.AddOrUpdateClusterServiceTarget(testSvc, "runnerB", false, nil, nil)
+
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunnerA := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fake.NewSimpleClientset(),
+		name:                 "runnerA",
+		namespace:            "local-ns",
+		labelSelector:        testGlobalSvcLabelString,
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   testGlobalStore,
+		routingStrategyLabel: selector,
+	})
+
+	// The remote cluster was unreachable and its cache never synced, the
+	// cluster is still removed from the global service
+	if err := testRunnerA.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, existingSvc.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "runnerB", svc.Annotations[globalSvcClustersAnno])
+	gsvc, err := testGlobalStore.Get("test-svc", "remote-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"runnerB"}, gsvc.clusters)
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
 )
 
 // GlobalService represents a global multicluster service
@@ -13,9 +19,71 @@ type GlobalService struct {
 	namespace   string
 	ports       []v1.ServicePort
 	headless    bool
+	spec        v1.ServiceSpec // Mirrored spec fields, with the merged ports and headless flag
 	labels      map[string]string
 	annotations map[string]string
 	clusters    []string
+	views       map[string]clusterServiceView // The service as seen in each cluster, keyed by cluster name
+	conflicts   []string                      // Mismatches between clusters that the merge policy cannot resolve
+	// Labels and annotations propagated from the services of all clusters,
+	// kept apart from the controller owned labels and annotations
+	propagatedLabels      map[string]string
+	propagatedAnnotations map[string]string
+}
+
+// clusterServiceView holds the attributes of the service in a single cluster
+// that are merged into the global service
+type clusterServiceView struct {
+	ports              []v1.ServicePort
+	headless           bool
+	spec               v1.ServiceSpec // Mirrored spec fields
+	topologyAwareHints bool
+	created            time.Time
+	updated            uint64            // Sequence number of the last update of the view
+	labels             map[string]string // Labels to propagate
+	annotations        map[string]string // Annotations to propagate
+}
+
+// mergePolicy decides how the views of a service from different clusters are
+// merged into the global service
+type mergePolicy string
+
+const (
+	mergePolicyLatest       mergePolicy = "latest"       // The most recently updated service wins
+	mergePolicyUnion        mergePolicy = "union"        // Expose the ports of all clusters
+	mergePolicyIntersection mergePolicy = "intersection" // Expose the ports common to all clusters
+	mergePolicyLocal        mergePolicy = "local"        // The local cluster's service wins, falls back to oldest
+	mergePolicyOldest       mergePolicy = "oldest"       // The oldest service wins
+)
+
+func (p mergePolicy) valid() bool {
+	switch p {
+	case mergePolicyLatest, mergePolicyUnion, mergePolicyIntersection, mergePolicyLocal, mergePolicyOldest:
+		return true
+	}
+	return false
+}
+
+// Prefix of the conflicts between headless and non headless services
+const headlessMismatchConflict = "headless mismatch"
+
+// headlessPolicy decides whether a global service is headless. Headless and
+// ClusterIP services cannot be merged, so the decision applies to the global
+// service as a whole.
+type headlessPolicy string
+
+const (
+	headlessPolicyReference headlessPolicy = "reference" // Follow the service that wins under the merge policy
+	headlessPolicyHeadless  headlessPolicy = "headless"  // Always headless
+	headlessPolicyClusterIP headlessPolicy = "clusterIP" // Never headless
+)
+
+func (p headlessPolicy) valid() bool {
+	switch p {
+	case headlessPolicyReference, headlessPolicyHeadless, headlessPolicyClusterIP:
+		return true
+	}
+	return false
 }
 
 const (
@@ -24,84 +92,344 @@ const (
 )
 
 var (
-	globalSvcLabels       = map[string]string{"global-svc": "true"}
-	globalSvcAnnotations  = map[string]string{kubeSeviceTopologyAwareHintsAnno: kubeSeviceTopologyAwareHintsAnnoVal} // Kube annotation to enable topolgy aware routing
-	globalSvcClustersAnno = "global-svc-clusters"
+	globalSvcLabels        = map[string]string{"global-svc": "true"}
+	globalSvcAnnotations   = map[string]string{kubeSeviceTopologyAwareHintsAnno: kubeSeviceTopologyAwareHintsAnnoVal} // Kube annotation to enable topolgy aware routing
+	globalSvcClustersAnno  = "global-svc-clusters"
+	globalSvcConflictsAnno = "global-svc-conflicts"
 )
 
-// GlobalServiceStore keeps a list of global services
+// copy returns a deep copy of the global service
+func (gsvc *GlobalService) copy() *GlobalService {
+	c := *gsvc
+	c.ports = append([]v1.ServicePort(nil), gsvc.ports...)
+	c.spec = *gsvc.spec.DeepCopy()
+	c.clusters = append([]string(nil), gsvc.clusters...)
+	c.conflicts = append([]string(nil), gsvc.conflicts...)
+	c.labels = make(map[string]string, len(gsvc.labels))
+	for k, v := range gsvc.labels {
+		c.labels[k] = v
+	}
+	c.annotations = make(map[string]string, len(gsvc.annotations))
+	for k, v := range gsvc.annotations {
+		c.annotations[k] = v
+	}
+	c.propagatedLabels = mergeMetadata(gsvc.propagatedLabels)
+	c.propagatedAnnotations = mergeMetadata(gsvc.propagatedAnnotations)
+	// Views are replaced, never modified, so copying the map is enough
+	c.views = make(map[string]clusterServiceView, len(gsvc.views))
+	for k, v := range gsvc.views {
+		c.views[k] = v
+	}
+	return &c
+}
+
+// GlobalServiceStore keeps a list of global services. It is shared between
+// runners and safe for concurrent use. Stored services are never modified in
+// place: every change replaces the stored service with an updated copy, so the
+// GlobalService values handed out by the store are immutable snapshots that
+// callers must not modify.
 type GlobalServiceStore struct {
-	store map[string]*GlobalService
+	mu             sync.RWMutex
+	store          map[string]*GlobalService
+	policy         mergePolicy
+	headlessPolicy headlessPolicy
+	localCluster   string
+	updates        uint64 // Sequence of view updates, orders views for the latest policy
 }
 
-func newGlobalServiceStore() *GlobalServiceStore {
+func newGlobalServiceStore(policy mergePolicy, headless headlessPolicy, localCluster string) *GlobalServiceStore {
 	return &GlobalServiceStore{
-		store: make(map[string]*GlobalService),
+		store:          make(map[string]*GlobalService),
+		policy:         policy,
+		headlessPolicy: headless,
+		localCluster:   localCluster,
 	}
 }
 
-// AddOrUpdateClusterServiceTarget will append a cluster to the GlobalService
-// clusters list. In case there is no global service in the store, it creates
-// the GlobalService.
-func (gss *GlobalServiceStore) AddOrUpdateClusterServiceTarget(svc *v1.Service, cluster string, topologyAwareHints bool) (*GlobalService, error) {
+// AddOrUpdateClusterServiceTarget records the cluster's view of the service and
+// appends the cluster to the GlobalService clusters list. In case there is no
+// global service in the store, it creates the GlobalService. Views are merged
+// according to the store's policy. Returns a snapshot of the updated
+// GlobalService. The passed labels and annotations are the ones of the service
+// to propagate to the global service.
+func (gss *GlobalServiceStore) AddOrUpdateClusterServiceTarget(svc *v1.Service, cluster string, topologyAwareHints bool, labels, annotations map[string]string) *GlobalService {
 	gsvcName := generateGlobalServiceName(svc.Name, svc.Namespace)
-	gsvcAnnotations := map[string]string{}
-	if topologyAwareHints {
-		gsvcAnnotations[kubeSeviceTopologyAwareHintsAnno] = kubeSeviceTopologyAwareHintsAnnoVal
+	spec := kube.MirroredServiceSpec(svc.Spec)
+	view := clusterServiceView{
+		ports:              spec.Ports,
+		headless:           isHeadless(svc),
+		spec:               spec,
+		topologyAwareHints: topologyAwareHints,
+		created:            svc.CreationTimestamp.Time,
+		labels:             labels,
+		annotations:        annotations,
 	}
-	gsvc, ok := gss.store[gsvcName]
-	// Add new service in the store if it doesn't exist
-	if !ok {
+
+	gss.mu.Lock()
+	defer gss.mu.Unlock()
+
+	gss.updates++
+	view.updated = gss.updates
+
+	var gsvc *GlobalService
+	if existing, ok := gss.store[gsvcName]; ok {
+		gsvc = existing.copy()
+	} else {
+		// Add new service in the store if it doesn't exist
 		gsvc = &GlobalService{
-			name:        svc.Name,
-			namespace:   svc.Namespace,
-			ports:       svc.Spec.Ports,
-			headless:    isHeadless(svc),
-			labels:      globalSvcLabels,
-			annotations: gsvcAnnotations,
-			clusters:    []string{cluster},
+			name:      svc.Name,
+			namespace: svc.Namespace,
+			labels:    globalSvcLabels,
+			views:     map[string]clusterServiceView{},
 		}
-		gsvc.annotations[globalSvcClustersAnno] = fmt.Sprintf("%s", cluster)
-		gss.store[gsvcName] = gsvc
-		return gsvc, nil
-	}
-	// If service exists, check and update global service
-	if gsvc.headless != isHeadless(svc) {
-		return nil, fmt.Errorf("Mismatch between existing headless service and requested")
+		gsvc = gsvc.copy()
 	}
 	if _, found := inSlice(gsvc.clusters, cluster); !found {
 		gsvc.clusters = append(gsvc.clusters, cluster)
 	}
-	gsvcAnnotations[globalSvcClustersAnno] = strings.Join(gsvc.clusters, ",")
-	gsvc.annotations = gsvcAnnotations
-	gsvc.ports = svc.Spec.Ports
-	return gsvc, nil
+	gsvc.views[cluster] = view
+	gss.merge(gsvc)
+	gss.store[gsvcName] = gsvc
+	return gsvc
 }
 
 // DeleteClusterServiceTarget removes a cluster from the GlobalService's
 // clusters list. If the list is empty it deletes the GlobalService. Returns a
-// pointer to a GlobalService or nil if completely deleted
+// snapshot of the updated GlobalService or nil if completely deleted
 func (gss *GlobalServiceStore) DeleteClusterServiceTarget(name, namespace, cluster string) *GlobalService {
 	gsvcName := generateGlobalServiceName(name, namespace)
-	gsvc, ok := gss.store[gsvcName]
+
+	gss.mu.Lock()
+	defer gss.mu.Unlock()
+
+	existing, ok := gss.store[gsvcName]
 	if !ok {
 		return nil
 	}
+	gsvc := existing.copy()
+	// Preserve the order of the rest, it breaks ties between the oldest
+	// services
 	if i, found := inSlice(gsvc.clusters, cluster); found {
-		gsvc.clusters = removeFromSlice(gsvc.clusters, i)
+		gsvc.clusters = slices.Delete(gsvc.clusters, i, i+1)
 	}
-	gss.store[gsvcName] = gsvc
+	delete(gsvc.views, cluster)
 	if len(gsvc.clusters) == 0 {
 		delete(gss.store, gsvcName)
 		return nil
 	}
-	gsvc.annotations[globalSvcClustersAnno] = strings.Join(gsvc.clusters, ",")
+	gss.merge(gsvc)
+	gss.store[gsvcName] = gsvc
 	return gsvc
 }
 
-// Get returns a service from the store or errors
+// merge sets the ports, headless flag, spec and annotations of the global service
+// from the views of its clusters, according to the store's policy. Mismatches
+// that the policy cannot resolve are recorded as conflicts and resolved in
+// favour of the reference cluster: the most recently updated service under
+// the latest policy, the local cluster under the local policy and the oldest
+// service otherwise. Propagated labels and annotations are
+// merged from all clusters, the reference cluster's values win.
+func (gss *GlobalServiceStore) merge(gsvc *GlobalService) {
+	ref := gss.referenceCluster(gsvc)
+	refView := gsvc.views[ref]
+	conflicts := []string{}
+
+	// Headless and non headless services cannot be merged, unless the
+	// headless policy decides explicitly
+	switch gss.headlessPolicy {
+	case headlessPolicyHeadless:
+		gsvc.headless = true
+	case headlessPolicyClusterIP:
+		gsvc.headless = false
+	default:
+		gsvc.headless = refView.headless
+		for _, c := range gsvc.clusters {
+			if gsvc.views[c].headless != refView.headless {
+				conflicts = append(conflicts, fmt.Sprintf("%s between clusters %s and %s", headlessMismatchConflict, ref, c))
+			}
+		}
+	}
+
+	topologyAwareHints := refView.topologyAwareHints
+	switch gss.policy {
+	case mergePolicyIntersection:
+		ports := intersectPorts(gsvc.orderedViews(ref))
+		if len(ports) == 0 && len(refView.ports) > 0 {
+			conflicts = append(conflicts, "no ports common to all clusters")
+			ports = refView.ports
+		}
+		gsvc.ports = ports
+		topologyAwareHints = allTopologyAwareHints(gsvc.views)
+	case mergePolicyUnion:
+		ports, err := unionPorts(gsvc.orderedViews(ref))
+		if err != nil {
+			conflicts = append(conflicts, err.Error())
+			ports = refView.ports
+		}
+		gsvc.ports = ports
+		topologyAwareHints = allTopologyAwareHints(gsvc.views)
+	default:
+		gsvc.ports = refView.ports
+	}
+	sort.Strings(conflicts)
+	gsvc.conflicts = conflicts
+
+	// The rest of the spec comes from the reference cluster. Global services
+	// are backed by endpointslices, so never ExternalName services.
+	gsvc.spec = *refView.spec.DeepCopy()
+	gsvc.spec.Type = ""
+	gsvc.spec.ExternalName = ""
+	gsvc.spec.Ports = gsvc.ports
+	gsvc.spec.ClusterIP = ""
+	if gsvc.headless {
+		gsvc.spec.ClusterIP = v1.ClusterIPNone
+	}
+
+	annotations := generateMirrorAnnotations(gsvc.name, gsvc.namespace)
+	if topologyAwareHints {
+		annotations[kubeSeviceTopologyAwareHintsAnno] = kubeSeviceTopologyAwareHintsAnnoVal
+	}
+	annotations[globalSvcClustersAnno] = strings.Join(gsvc.clusters, ",")
+	if len(conflicts) > 0 {
+		annotations[globalSvcConflictsAnno] = strings.Join(conflicts, "; ")
+	}
+	gsvc.annotations = annotations
+
+	views := gsvc.orderedViews(ref)
+	labelMaps := make([]map[string]string, 0, len(views))
+	annotationMaps := make([]map[string]string, 0, len(views))
+	for _, view := range views {
+		labelMaps = append(labelMaps, view.labels)
+		annotationMaps = append(annotationMaps, view.annotations)
+	}
+	gsvc.propagatedLabels = mergeMetadata(labelMaps...)
+	gsvc.propagatedAnnotations = mergeMetadata(annotationMaps...)
+}
+
+// referenceCluster returns the cluster whose view wins under the latest, local
+// and oldest policies. The union and intersection policies use it to resolve
+// conflicts.
+func (gss *GlobalServiceStore) referenceCluster(gsvc *GlobalService) string {
+	if gss.policy == mergePolicyLatest {
+		latest := gsvc.clusters[0]
+		for _, c := range gsvc.clusters[1:] {
+			if gsvc.views[c].updated > gsvc.views[latest].updated {
+				latest = c
+			}
+		}
+		return latest
+	}
+	if gss.policy == mergePolicyLocal {
+		if _, ok := gsvc.views[gss.localCluster]; ok {
+			return gss.localCluster
+		}
+	}
+	// Ties are broken by the order in which clusters were added
+	oldest := gsvc.clusters[0]
+	for _, c := range gsvc.clusters[1:] {
+		if gsvc.views[c].created.Before(gsvc.views[oldest].created) {
+			oldest = c
+		}
+	}
+	return oldest
+}
+
+// orderedViews returns the views of the service with the reference cluster's
+// view first, followed by the rest in the order clusters were added
+func (gsvc *GlobalService) orderedViews(ref string) []clusterServiceView {
+	views := []clusterServiceView{gsvc.views[ref]}
+	for _, c := range gsvc.clusters {
+		if c != ref {
+			views = append(views, gsvc.views[c])
+		}
+	}
+	return views
+}
+
+// servicePortKey identifies a service port regardless of its name
+func servicePortKey(p v1.ServicePort) string {
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = v1.ProtocolTCP
+	}
+	return fmt.Sprintf("%s/%d", protocol, p.Port)
+}
+
+// unionPorts returns the ports of all the views. Ports are identified by port
+// and protocol and the definition of the first view wins. Errors if the result
+// is not a valid list of service ports.
+func unionPorts(views []clusterServiceView) ([]v1.ServicePort, error) {
+	ports := []v1.ServicePort{}
+	seen := map[string]bool{}
+	names := map[string]string{}
+	for _, view := range views {
+		for _, p := range view.ports {
+			key := servicePortKey(p)
+			if seen[key] {
+				continue
+			}
+			if k, ok := names[p.Name]; ok {
+				return nil, fmt.Errorf("port name %q used for both %s and %s", p.Name, k, key)
+			}
+			seen[key] = true
+			names[p.Name] = key
+			ports = append(ports, p)
+		}
+	}
+	// Services with multiple ports require all ports to be named
+	if len(ports) > 1 {
+		if _, ok := names[""]; ok {
+			return nil, fmt.Errorf("cannot merge unnamed ports")
+		}
+	}
+	return ports, nil
+}
+
+// intersectPorts returns the ports present in all the views. Ports are
+// identified by port and protocol and the definition of the first view wins.
+func intersectPorts(views []clusterServiceView) []v1.ServicePort {
+	ports := []v1.ServicePort{}
+	for _, p := range views[0].ports {
+		key := servicePortKey(p)
+		inAll := true
+		for _, view := range views[1:] {
+			found := false
+			for _, vp := range view.ports {
+				if servicePortKey(vp) == key {
+					found = true
+					break
+				}
+			}
+			if !found {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// allTopologyAwareHints returns true if topology aware hints are requested in
+// all the clusters
+func allTopologyAwareHints(views map[string]clusterServiceView) bool {
+	for _, view := range views {
+		if !view.topologyAwareHints {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns a snapshot of a service from the store or errors
 func (gss *GlobalServiceStore) Get(name, namespace string) (*GlobalService, error) {
 	gsvcName := generateGlobalServiceName(name, namespace)
+
+	gss.mu.RLock()
+	defer gss.mu.RUnlock()
+
 	gsvc, ok := gss.store[gsvcName]
 	if !ok {
 		return nil, fmt.Errorf("not found")
@@ -109,7 +437,35 @@ func (gss *GlobalServiceStore) Get(name, namespace string) (*GlobalService, erro
 	return gsvc, nil
 }
 
+// Lookup returns a snapshot of the global service stored under the passed
+// local global service name
+func (gss *GlobalServiceStore) Lookup(globalName string) (*GlobalService, bool) {
+	gss.mu.RLock()
+	defer gss.mu.RUnlock()
+
+	gsvc, ok := gss.store[globalName]
+	return gsvc, ok
+}
+
+// ClusterServices returns snapshots of the services in the store that the
+// passed cluster is a target of
+func (gss *GlobalServiceStore) ClusterServices(cluster string) []*GlobalService {
+	gss.mu.RLock()
+	defer gss.mu.RUnlock()
+
+	gsvcs := []*GlobalService{}
+	for _, gsvc := range gss.store {
+		if _, found := inSlice(gsvc.clusters, cluster); found {
+			gsvcs = append(gsvcs, gsvc)
+		}
+	}
+	return gsvcs
+}
+
 // Len returns the length of the list of services in store
 func (gss *GlobalServiceStore) Len() int {
+	gss.mu.RLock()
+	defer gss.mu.RUnlock()
+
 	return len(gss.store)
 }
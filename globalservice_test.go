@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 type testService struct {
@@ -36,11 +46,10 @@ func createTestService(name, namespace, clusterIP string, ports []int32) *v1.Ser
 }
 
 func createTestStore(t *testing.T, services []testService, topologyAwareHints bool) *GlobalServiceStore {
-	store := newGlobalServiceStore()
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 	for _, s := range services {
 		svc := createTestService(s.name, s.namespace, s.clusterIP, s.ports)
-		_, err := store.AddOrUpdateClusterServiceTarget(svc, s.cluster, topologyAwareHints)
-		assert.Equal(t, nil, err)
+		store.AddOrUpdateClusterServiceTarget(svc, s.cluster, topologyAwareHints, nil, nil)
 	}
 	return store
 }
@@ -80,37 +89,176 @@ func TestAddOrUpdateClusterServiceTarget_AddMultipleServices(t *testing.T) {
 }
 
 func TestAddOrUpdateClusterServiceTarget_HeadlessMisMatch(t *testing.T) {
-	store := newGlobalServiceStore()
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
 	svcA := createTestService("name", "namespace", "1.1.1.1", []int32{80})
 	clusterA := "a"
-	_, err := store.AddOrUpdateClusterServiceTarget(svcA, clusterA, false)
-	assert.Equal(t, nil, err)
+	store.AddOrUpdateClusterServiceTarget(svcA, clusterA, false, nil, nil)
 	svcB := createTestService("name", "namespace", "None", []int32{80})
 	clusterB := "b"
-	_, err = store.AddOrUpdateClusterServiceTarget(svcB, clusterB, false)
-	assert.Equal(t, fmt.Errorf("Mismatch between existing headless service and requested"), err)
+	gsvc := store.AddOrUpdateClusterServiceTarget(svcB, clusterB, false, nil, nil)
+	// The mismatch should be reported and the oldest service should win
+	assert.Equal(t, []string{"a", "b"}, gsvc.clusters)
+	assert.Equal(t, false, gsvc.headless)
+	assert.Equal(t, "headless mismatch between clusters a and b", gsvc.annotations[globalSvcConflictsAnno])
+
+	// Removing the conflicting cluster should clear the conflict
+	gsvc = store.DeleteClusterServiceTarget("name", "namespace", clusterB)
+	assert.Equal(t, 0, len(gsvc.conflicts))
+	assert.NotContains(t, gsvc.annotations, globalSvcConflictsAnno)
 }
 
-func TestAddOrUpdateClusterServiceTarget_UpdateFungiblePorts(t *testing.T) {
+func TestAddOrUpdateClusterServiceTarget_UpdatePorts(t *testing.T) {
 	store := createTestStore(t, []testService{
 		testService{cluster: "a", name: "name", namespace: "namespace", clusterIP: "1.1.1.1", ports: []int32{80}},
 	}, false)
-	svcB := createTestService("name", "namespace", "2.2.2.2", []int32{8080})
-	clusterB := "b"
-	_, err := store.AddOrUpdateClusterServiceTarget(svcB, clusterB, false)
-	if err != nil {
-		t.Fatal(err)
-	}
+	svcA := createTestService("name", "namespace", "1.1.1.1", []int32{8080})
+	store.AddOrUpdateClusterServiceTarget(svcA, "a", false, nil, nil)
 	assert.Equal(t, 1, store.Len())
 	gsvc, err := store.Get("name", "namespace")
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, []string{"a", "b"}, gsvc.clusters)
+	assert.Equal(t, []string{"a"}, gsvc.clusters)
 	assert.Equal(t, 1, len(gsvc.ports))
 	assert.Equal(t, int32(8080), gsvc.ports[0].Port)
 }
 
+func createNamedPortsTestService(name, namespace string, created time.Time, ports map[string]int32) *v1.Service {
+	svc := createTestService(name, namespace, "", nil)
+	svc.CreationTimestamp = metav1.NewTime(created)
+	for n, p := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Name: n, Port: p})
+	}
+	sort.Slice(svc.Spec.Ports, func(i, j int) bool { return svc.Spec.Ports[i].Port < svc.Spec.Ports[j].Port })
+	return svc
+}
+
+func portNumbers(ports []v1.ServicePort) []int32 {
+	numbers := []int32{}
+	for _, p := range ports {
+		numbers = append(numbers, p.Port)
+	}
+	return numbers
+}
+
+func TestAddOrUpdateClusterServiceTarget_MergePolicies(t *testing.T) {
+	now := time.Now()
+	// The local cluster's service is the newest one
+	svcs := map[string]*v1.Service{
+		"a":     createNamedPortsTestService("name", "namespace", now.Add(-2*time.Hour), map[string]int32{"http": 80, "grpc": 9000}),
+		"b":     createNamedPortsTestService("name", "namespace", now.Add(-3*time.Hour), map[string]int32{"http": 80, "metrics": 8081}),
+		"local": createNamedPortsTestService("name", "namespace", now, map[string]int32{"http": 80}),
+	}
+	tests := []struct {
+		policy   mergePolicy
+		expected []int32
+	}{
+		{mergePolicyLatest, []int32{80}},
+		{mergePolicyUnion, []int32{80, 8081, 9000}},
+		{mergePolicyIntersection, []int32{80}},
+		{mergePolicyLocal, []int32{80}},
+		{mergePolicyOldest, []int32{80, 8081}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			store := newGlobalServiceStore(test.policy, headlessPolicyReference, "local")
+			for _, cluster := range []string{"a", "b", "local"} {
+				store.AddOrUpdateClusterServiceTarget(svcs[cluster], cluster, false, nil, nil)
+			}
+			gsvc, err := store.Get("name", "namespace")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.ElementsMatch(t, test.expected, portNumbers(gsvc.ports))
+			assert.Equal(t, 0, len(gsvc.conflicts))
+		})
+	}
+}
+
+func TestAddOrUpdateClusterServiceTarget_LatestPolicy(t *testing.T) {
+	store := newGlobalServiceStore(mergePolicyLatest, headlessPolicyReference, "")
+	// Single unnamed ports with different numbers do not conflict, the
+	// latest service wins
+	store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "", []int32{80}), "a", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "", []int32{8080}), "b", false, nil, nil)
+	assert.Equal(t, []int32{8080}, portNumbers(gsvc.ports))
+	assert.Equal(t, 0, len(gsvc.conflicts))
+	// An update makes the older service win again
+	gsvc = store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "", []int32{81}), "a", false, nil, nil)
+	assert.Equal(t, []int32{81}, portNumbers(gsvc.ports))
+	// Removing the latest falls back to the next most recently updated
+	store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "", []int32{8080}), "b", false, nil, nil)
+	gsvc = store.DeleteClusterServiceTarget("name", "namespace", "b")
+	assert.Equal(t, []int32{81}, portNumbers(gsvc.ports))
+}
+
+func TestAddOrUpdateClusterServiceTarget_LocalPolicyWithoutLocalService(t *testing.T) {
+	now := time.Now()
+	store := newGlobalServiceStore(mergePolicyLocal, headlessPolicyReference, "local")
+	store.AddOrUpdateClusterServiceTarget(createNamedPortsTestService("name", "namespace", now, map[string]int32{"http": 80}), "a", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(createNamedPortsTestService("name", "namespace", now.Add(-time.Hour), map[string]int32{"http": 8080}), "b", false, nil, nil)
+	// Should fall back to the oldest service
+	assert.Equal(t, []int32{8080}, portNumbers(gsvc.ports))
+}
+
+func TestAddOrUpdateClusterServiceTarget_Spec(t *testing.T) {
+	now := time.Now()
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "local")
+	svcA := createNamedPortsTestService("name", "namespace", now.Add(-time.Hour), map[string]int32{"http": 80})
+	svcA.Spec.ClusterIP = "None"
+	svcA.Spec.PublishNotReadyAddresses = true
+	svcB := createNamedPortsTestService("name", "namespace", now, map[string]int32{"grpc": 9000})
+	svcB.Spec.ClusterIP = "None"
+	svcB.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	store.AddOrUpdateClusterServiceTarget(svcB, "b", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(svcA, "a", false, nil, nil)
+
+	// The oldest service's spec wins, with the merged ports
+	assert.Equal(t, true, gsvc.spec.PublishNotReadyAddresses)
+	assert.Equal(t, v1.ServiceAffinity(""), gsvc.spec.SessionAffinity)
+	assert.Equal(t, "None", gsvc.spec.ClusterIP)
+	assert.Equal(t, []int32{80, 9000}, portNumbers(gsvc.spec.Ports))
+	assert.Nil(t, gsvc.spec.Selector)
+}
+
+func TestAddOrUpdateClusterServiceTarget_HeadlessPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy    headlessPolicy
+		headless  bool
+		conflicts int
+	}{
+		{headlessPolicyReference, false, 1},
+		{headlessPolicyHeadless, true, 0},
+		{headlessPolicyClusterIP, false, 0},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			store := newGlobalServiceStore(mergePolicyUnion, test.policy, "local")
+			store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "1.1.1.1", []int32{80}), "a", false, nil, nil)
+			gsvc := store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "None", []int32{80}), "b", false, nil, nil)
+			assert.Equal(t, test.headless, gsvc.headless)
+			assert.Equal(t, test.headless, gsvc.spec.ClusterIP == "None")
+			assert.Equal(t, test.conflicts, len(gsvc.conflicts))
+		})
+	}
+}
+
+func TestAddOrUpdateClusterServiceTarget_UnionConflict(t *testing.T) {
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "1.1.1.1", []int32{80}), "a", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "2.2.2.2", []int32{8080}), "b", false, nil, nil)
+	// Unnamed ports cannot be merged, the oldest service should win
+	assert.Equal(t, []int32{80}, portNumbers(gsvc.ports))
+	assert.Equal(t, `port name "" used for both TCP/80 and TCP/8080`, gsvc.annotations[globalSvcConflictsAnno])
+}
+
+func TestAddOrUpdateClusterServiceTarget_IntersectionConflict(t *testing.T) {
+	store := newGlobalServiceStore(mergePolicyIntersection, headlessPolicyReference, "")
+	store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "1.1.1.1", []int32{80}), "a", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "2.2.2.2", []int32{8080}), "b", false, nil, nil)
+	assert.Equal(t, []int32{80}, portNumbers(gsvc.ports))
+	assert.Equal(t, "no ports common to all clusters", gsvc.annotations[globalSvcConflictsAnno])
+}
+
 func TestAddOrUpdateClusterServiceTarget_UpdateFungibleTopologyAnnotations(t *testing.T) {
 	store := createTestStore(t, []testService{
 		testService{cluster: "a", name: "name", namespace: "namespace", clusterIP: "1.1.1.1", ports: []int32{80}},
@@ -119,16 +267,14 @@ func TestAddOrUpdateClusterServiceTarget_UpdateFungibleTopologyAnnotations(t *te
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, 2, len(gsvc.annotations))
+	// Topology, clusters and remote name and namespace annotations
+	assert.Equal(t, 4, len(gsvc.annotations))
 	assert.Equal(t, kubeSeviceTopologyAwareHintsAnnoVal, gsvc.annotations[kubeSeviceTopologyAwareHintsAnno])
 
 	// Add a service with topolofy aware flag set to false
-	svcB := createTestService("name", "namespace", "2.2.2.2", []int32{8080})
+	svcB := createTestService("name", "namespace", "2.2.2.2", []int32{80})
 	clusterB := "b"
-	_, err = store.AddOrUpdateClusterServiceTarget(svcB, clusterB, false)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store.AddOrUpdateClusterServiceTarget(svcB, clusterB, false, nil, nil)
 	// This should keep a single service in the store, but delete the
 	// topology aware hints annotation
 	assert.Equal(t, 1, store.Len())
@@ -137,7 +283,8 @@ func TestAddOrUpdateClusterServiceTarget_UpdateFungibleTopologyAnnotations(t *te
 		t.Fatal(err)
 	}
 	assert.Equal(t, []string{"a", "b"}, gsvc.clusters)
-	assert.Equal(t, 1, len(gsvc.annotations))
+	assert.Equal(t, 3, len(gsvc.annotations))
+	assert.NotContains(t, gsvc.annotations, kubeSeviceTopologyAwareHintsAnno)
 }
 
 func TestDeleteClusterServiceTarget_DeleteServiceLastTarget(t *testing.T) {
@@ -164,9 +311,31 @@ func TestDeleteClusterServiceTarget_DeleteServiceTarget(t *testing.T) {
 	svcA := createTestService("name", "namespace", "1.1.1.1", []int32{80})
 	store.DeleteClusterServiceTarget(svcA.Name, svcA.Namespace, "a")
 	assert.Equal(t, 1, store.Len())
+	gsvc, err = store.Get("name", "namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
 	assert.Equal(t, []string{"b"}, gsvc.clusters)
 }
 
+func TestDeleteClusterServiceTarget_PreservesClusterOrder(t *testing.T) {
+	// Services created at the same time, the first cluster added is the
+	// reference
+	store := newGlobalServiceStore(mergePolicyOldest, headlessPolicyReference, "")
+	for i, cluster := range []string{"a", "b", "c", "d"} {
+		store.AddOrUpdateClusterServiceTarget(createTestService("name", "namespace", "1.1.1.1", []int32{int32(80 + i)}), cluster, false, nil, nil)
+	}
+	gsvc := store.DeleteClusterServiceTarget("name", "namespace", "b")
+	assert.Equal(t, []string{"a", "c", "d"}, gsvc.clusters)
+	assert.Equal(t, "a", store.referenceCluster(gsvc))
+	assert.Equal(t, []int32{80}, portNumbers(gsvc.ports))
+
+	gsvc = store.DeleteClusterServiceTarget("name", "namespace", "a")
+	assert.Equal(t, []string{"c", "d"}, gsvc.clusters)
+	assert.Equal(t, "c", store.referenceCluster(gsvc))
+	assert.Equal(t, []int32{82}, portNumbers(gsvc.ports))
+}
+
 func TestDeleteClusterServiceTarget_NotPresent(t *testing.T) {
 	store := createTestStore(t, []testService{
 		testService{cluster: "cluster", name: "name", namespace: "namespace", clusterIP: "1.1.1.1", ports: []int32{80}},
@@ -177,3 +346,120 @@ func TestDeleteClusterServiceTarget_NotPresent(t *testing.T) {
 	store.DeleteClusterServiceTarget(svcB.Name, svcB.Namespace, clusterB)
 	assert.Equal(t, 1, store.Len())
 }
+
+func TestGlobalServiceStore_SnapshotsAreImmutable(t *testing.T) {
+	store := createTestStore(t, []testService{
+		testService{cluster: "a", name: "name", namespace: "namespace", clusterIP: "1.1.1.1", ports: []int32{80}},
+	}, false)
+	snapshot, err := store.Get("name", "namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svcB := createTestService("name", "namespace", "2.2.2.2", []int32{8080})
+	store.AddOrUpdateClusterServiceTarget(svcB, "b", false, nil, nil)
+	store.DeleteClusterServiceTarget("name", "namespace", "a")
+
+	// Changes in the store should not leak to previously handed out
+	// snapshots
+	assert.Equal(t, []string{"a"}, snapshot.clusters)
+	assert.Equal(t, "a", snapshot.annotations[globalSvcClustersAnno])
+	assert.Equal(t, int32(80), snapshot.ports[0].Port)
+
+	gsvc, err := store.Get("name", "namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"b"}, gsvc.clusters)
+	assert.Equal(t, "b", gsvc.annotations[globalSvcClustersAnno])
+	assert.Equal(t, int32(8080), gsvc.ports[0].Port)
+}
+
+// TestGlobalServiceStore_MultipleRunners reconciles the same services from
+// multiple runners concurrently against a shared store. Run with -race.
+func TestGlobalServiceStore_MultipleRunners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "error")
+
+	const (
+		numRunners  = 5
+		numServices = 10
+		iterations  = 20
+	)
+	fakeClient := fake.NewSimpleClientset()
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	selector, _ := labels.Parse("mirror.semaphore.uw.io/test=true")
+
+	runners := []*GlobalRunner{}
+	for r := 0; r < numRunners; r++ {
+		svcs := []runtime.Object{}
+		for s := 0; s < numServices; s++ {
+			svc := createTestService(fmt.Sprintf("svc-%d", s), "remote-ns", fmt.Sprintf("10.0.%d.%d", r, s), []int32{80})
+			svc.Labels = testGlobalSvcLabel
+			svcs = append(svcs, svc)
+		}
+		runner := newGlobalRunner(globalRunnerOptions{
+			client:               fakeClient,
+			watchClient:          fake.NewSimpleClientset(svcs...),
+			name:                 fmt.Sprintf("runner-%d", r),
+			namespace:            "local-ns",
+			labelSelector:        testGlobalSvcLabelString,
+			resyncPeriod:         60 * time.Minute,
+			globalServiceStore:   store,
+			routingStrategyLabel: selector,
+		})
+		go runner.serviceWatcher.Run()
+		cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), runner.serviceWatcher.HasSynced)
+		runners = append(runners, runner)
+	}
+
+	var wg sync.WaitGroup
+	for _, runner := range runners {
+		wg.Go(func() {
+			for i := 0; i < iterations; i++ {
+				for s := 0; s < numServices; s++ {
+					// Conflicting writes to the local service are
+					// expected and would be retried by the queue
+					runner.reconcileGlobalService(fmt.Sprintf("svc-%d", s), "remote-ns")
+				}
+			}
+		})
+		// Readers of the store, like the lookup endpoint
+		wg.Go(func() {
+			for i := 0; i < iterations; i++ {
+				for s := 0; s < numServices; s++ {
+					gsvc, err := store.Get(fmt.Sprintf("svc-%d", s), "remote-ns")
+					if err == nil {
+						_ = strings.Join(gsvc.clusters, ",")
+						_ = gsvc.annotations[globalSvcClustersAnno]
+					}
+				}
+				store.Len()
+			}
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, numServices, store.Len())
+	for s := 0; s < numServices; s++ {
+		gsvc, err := store.Get(fmt.Sprintf("svc-%d", s), "remote-ns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, numRunners, len(gsvc.clusters))
+	}
+
+	// Removing the services from all clusters concurrently should empty
+	// the store
+	for _, runner := range runners {
+		wg.Go(func() {
+			for s := 0; s < numServices; s++ {
+				store.DeleteClusterServiceTarget(fmt.Sprintf("svc-%d", s), "remote-ns", runner.name)
+			}
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, 0, store.Len())
+}
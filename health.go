@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+)
+
+// healthPolicy decides which health check fails when a cluster is unhealthy
+type healthPolicy string
+
+const (
+	healthPolicyLiveness  healthPolicy = "liveness"  // Fail liveness and readiness, so that the pod is restarted
+	healthPolicyReadiness healthPolicy = "readiness" // Only fail readiness
+)
+
+func (p healthPolicy) valid() bool {
+	switch p {
+	case healthPolicyLiveness, healthPolicyReadiness:
+		return true
+	}
+	return false
+}
+
+// clusterState summarises the health of a cluster
+type clusterState string
+
+const (
+	clusterStateSyncing   clusterState = "syncing"   // No watcher has listed objects yet
+	clusterStateHealthy   clusterState = "healthy"   // The last request succeeded
+	clusterStateDegraded  clusterState = "degraded"  // Requests fail, but fewer than the error threshold in a row
+	clusterStateUnhealthy clusterState = "unhealthy" // At least the error threshold requests failed in a row
+)
+
+// clusterStatus is the health of a cluster as reported by the status endpoint
+type clusterStatus struct {
+	kube.ClusterHealthStatus
+	State clusterState `json:"state"`
+}
+
+// healthStatus is the response of the status endpoint
+type healthStatus struct {
+	Leader   bool            `json:"leader"`
+	Policy   healthPolicy    `json:"policy"`
+	Ready    bool            `json:"ready"`
+	Clusters []clusterStatus `json:"clusters"`
+}
+
+// stateOf returns the state of a cluster from its health
+func stateOf(h kube.ClusterHealthStatus, errorThreshold int) clusterState {
+	switch {
+	case h.ErrorStreak >= errorThreshold:
+		return clusterStateUnhealthy
+	case h.LastSync.IsZero():
+		return clusterStateSyncing
+	case h.ErrorStreak > 0:
+		return clusterStateDegraded
+	}
+	return clusterStateHealthy
+}
+
+// clusterStatuses returns the state of all the clusters the operator talks to
+func clusterStatuses(errorThreshold int) []clusterStatus {
+	statuses := []clusterStatus{}
+	for _, h := range kube.HealthStatuses() {
+		statuses = append(statuses, clusterStatus{
+			ClusterHealthStatus: h,
+			State:               stateOf(h, errorThreshold),
+		})
+	}
+	return statuses
+}
+
+// ready returns true if all clusters have synced and none is unhealthy
+func ready(statuses []clusterStatus) bool {
+	for _, s := range statuses {
+		if s.State == clusterStateSyncing || s.State == clusterStateUnhealthy {
+			return false
+		}
+	}
+	return true
+}
+
+// live returns false if the policy is to fail liveness and any cluster is
+// unhealthy
+func live(statuses []clusterStatus, policy healthPolicy) bool {
+	if policy != healthPolicyLiveness {
+		return true
+	}
+	for _, s := range statuses {
+		if s.State == clusterStateUnhealthy {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+)
+
+func TestStateOf(t *testing.T) {
+	synced := time.Now()
+	assert.Equal(t, clusterStateSyncing, stateOf(kube.ClusterHealthStatus{}, 3))
+	assert.Equal(t, clusterStateHealthy, stateOf(kube.ClusterHealthStatus{LastSync: synced}, 3))
+	assert.Equal(t, clusterStateDegraded, stateOf(kube.ClusterHealthStatus{LastSync: synced, ErrorStreak: 2}, 3))
+	assert.Equal(t, clusterStateUnhealthy, stateOf(kube.ClusterHealthStatus{LastSync: synced, ErrorStreak: 3}, 3))
+	// Clusters that never synced are unhealthy once the threshold is reached
+	assert.Equal(t, clusterStateUnhealthy, stateOf(kube.ClusterHealthStatus{ErrorStreak: 3}, 3))
+}
+
+func TestHealthPolicy(t *testing.T) {
+	healthy := []clusterStatus{{State: clusterStateHealthy}, {State: clusterStateDegraded}}
+	syncing := []clusterStatus{{State: clusterStateHealthy}, {State: clusterStateSyncing}}
+	unhealthy := []clusterStatus{{State: clusterStateHealthy}, {State: clusterStateUnhealthy}}
+
+	assert.Equal(t, true, ready(healthy))
+	assert.Equal(t, false, ready(syncing))
+	assert.Equal(t, false, ready(unhealthy))
+
+	assert.Equal(t, true, live(unhealthy, healthPolicyReadiness))
+	assert.Equal(t, false, live(unhealthy, healthPolicyLiveness))
+	assert.Equal(t, true, live(syncing, healthPolicyLiveness))
+}
@@ -0,0 +1,182 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+// tokenReloadPeriod is how often the token file is re-read
+const tokenReloadPeriod = time.Minute
+
+var bearerRe = regexp.MustCompile(`[A-Z|a-z0-9\-\._~\+\/]+=*`)
+
+// fileTokenAuth authenticates requests with a bearer token read from a file.
+// The file is re-read periodically and after the API rejects the token, so that
+// rotated service account tokens are picked up by the existing client. Watches
+// authenticate with the new token when they reconnect and keep their caches.
+type fileTokenAuth struct {
+	path    string
+	cluster string
+	mu      sync.Mutex
+	token   string
+	readAt  time.Time
+	now     func() time.Time
+}
+
+// newFileTokenAuth reads the token from the file and errors if it cannot be
+// read or is invalid
+func newFileTokenAuth(path, cluster string) (*fileTokenAuth, error) {
+	a := &fileTokenAuth{
+		path:    path,
+		cluster: cluster,
+		now:     time.Now,
+	}
+	token, err := readToken(path)
+	if err != nil {
+		return nil, err
+	}
+	a.token = token
+	a.readAt = a.now()
+	return a, nil
+}
+
+// readToken reads and validates a bearer token from a file
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read file: %s: %v", path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token != "" && !bearerRe.MatchString(token) {
+		return "", fmt.Errorf("The provided token does not match regex: %s", bearerRe.String())
+	}
+	return token, nil
+}
+
+// Token returns the current token, re-reading the file if the token is older
+// than the reload period. If the file cannot be read the last known token is
+// returned.
+func (a *fileTokenAuth) Token() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.now().Sub(a.readAt) < tokenReloadPeriod {
+		return a.token
+	}
+	a.readAt = a.now()
+	token, err := readToken(a.path)
+	if err != nil {
+		log.Logger.Error("cannot reload service account token", "cluster", a.cluster, "err", err)
+		metrics.IncRemoteTokenReloads(a.cluster, "error")
+		return a.token
+	}
+	if token != a.token {
+		log.Logger.Info("reloaded service account token", "cluster", a.cluster, "path", a.path)
+		metrics.IncRemoteTokenReloads(a.cluster, "success")
+		a.token = token
+	}
+	return a.token
+}
+
+// expire makes the next call to Token re-read the file
+func (a *fileTokenAuth) expire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.readAt = time.Time{}
+}
+
+// wrap returns a http.RoundTripper that sets the Authorization header of
+// requests to the current token
+func (a *fileTokenAuth) wrap(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if token := a.Token(); token != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := next.RoundTrip(req)
+		// The token may have been rotated since it was last read
+		if err == nil && resp.StatusCode == http.StatusUnauthorized {
+			a.expire()
+		}
+		return resp, err
+	})
+}
+
+// staticTokenAuth returns a function that wraps a http.RoundTripper to set the
+// Authorization header of requests to a static token
+func staticTokenAuth(token string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if token != "" {
+				req = req.Clone(req.Context())
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// authFailureRecorder returns a function that wraps a http.RoundTripper to count
+// the requests rejected by the API of a remote cluster
+func authFailureRecorder(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+				metrics.IncRemoteAuthFailures(cluster, strconv.Itoa(resp.StatusCode))
+			}
+			return resp, err
+		})
+	}
+}
+
+// credentialsObtainedKey is the context key of the flag that marks requests for
+// which credentials were obtained
+type credentialsObtainedKey struct{}
+
+// credentialErrorRecorder returns wrappers that count the requests to the API
+// of a remote cluster that failed because credentials could not be obtained
+// from an exec plugin or auth provider. The outer wrapper must wrap the auth
+// provider and the inner must be wrapped by it: requests that fail without
+// reaching the inner wrapper failed to get credentials.
+func credentialErrorRecorder(cluster string) (outer, inner func(http.RoundTripper) http.RoundTripper) {
+	outer = func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			obtained := &atomic.Bool{}
+			req = req.WithContext(context.WithValue(req.Context(), credentialsObtainedKey{}, obtained))
+			resp, err := next.RoundTrip(req)
+			if err != nil && !obtained.Load() {
+				metrics.IncRemoteCredentialErrors(cluster)
+			}
+			return resp, err
+		})
+	}
+	inner = func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if obtained, ok := req.Context().Value(credentialsObtainedKey{}).(*atomic.Bool); ok {
+				obtained.Store(true)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+	return outer, inner
+}
+
+// roundTripperFunc is a function that implements http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
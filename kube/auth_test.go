@@ -0,0 +1,167 @@
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func TestFileTokenAuthReload(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("token-a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	validToken := "token-a"
+	headers := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers = append(headers, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer "+validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	auth, err := newFileTokenAuth(tokenPath, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+	client := &http.Client{Transport: auth.wrap(http.DefaultTransport)}
+	get := func() int {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get())
+
+	// Rotate the token, the old one is still used until the reload period
+	// passes
+	if err := os.WriteFile(tokenPath, []byte("token-b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, get())
+	now = now.Add(tokenReloadPeriod)
+	assert.Equal(t, http.StatusUnauthorized, get())
+
+	// After the API rejects a token, the file is re-read on the next request
+	mu.Lock()
+	validToken = "token-c"
+	mu.Unlock()
+	if err := os.WriteFile(tokenPath, []byte("token-c\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, get())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"Bearer token-a",
+		"Bearer token-a",
+		"Bearer token-b",
+		"Bearer token-c",
+	}, headers)
+}
+
+func TestFileTokenAuthInvalidToken(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("%%%"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newFileTokenAuth(tokenPath, "test-cluster")
+	assert.NotEqual(t, nil, err)
+
+	_, err = newFileTokenAuth(filepath.Join(t.TempDir(), "missing"), "test-cluster")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCredentialErrorRecorder(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	outer, inner := credentialErrorRecorder("test")
+	// Simulates an exec plugin that fails to get credentials for the first
+	// request
+	failing := true
+	plugin := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if failing {
+				return nil, fmt.Errorf("exec plugin failed")
+			}
+			return next.RoundTrip(req)
+		})
+	}
+	client := &http.Client{Transport: outer(plugin(inner(http.DefaultTransport)))}
+
+	before := credentialErrors(t, "test")
+	_, err := client.Get(server.URL)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, before+1, credentialErrors(t, "test"))
+
+	failing = false
+	resp, err := client.Get(server.URL)
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.Equal(t, before+1, credentialErrors(t, "test"))
+}
+
+func TestCheckAuthMethod(t *testing.T) {
+	execConf := &rest.Config{ExecProvider: &clientcmdapi.ExecConfig{Command: "aws"}}
+	oidcConf := &rest.Config{AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "oidc"}}
+	tokenConf := &rest.Config{BearerToken: "token"}
+
+	assert.Equal(t, nil, checkAuthMethod(tokenConf, ""))
+	assert.Equal(t, nil, checkAuthMethod(tokenConf, AuthMethodKubeConfig))
+	assert.Equal(t, nil, checkAuthMethod(execConf, AuthMethodExec))
+	assert.Equal(t, nil, checkAuthMethod(oidcConf, AuthMethodOIDC))
+	assert.NotEqual(t, nil, checkAuthMethod(tokenConf, AuthMethodExec))
+	assert.NotEqual(t, nil, checkAuthMethod(execConf, AuthMethodOIDC))
+	assert.NotEqual(t, nil, checkAuthMethod(tokenConf, AuthMethodToken))
+}
+
+// credentialErrors returns the credential errors recorded for the cluster
+func credentialErrors(t *testing.T, cluster string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "semaphore_service_mirror_remote_credential_errors_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "cluster" && l.GetValue() == cluster {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+const (
+	// DefaultCARefreshInterval is how often the CA bundle of a remote
+	// cluster is refreshed by default
+	DefaultCARefreshInterval = time.Hour
+	// caRetryPeriod is how long to wait before retrying a failed refresh
+	caRetryPeriod = 30 * time.Second
+)
+
+// caFetchTimeout bounds fetching the CA bundle from a URL, so that a hanging
+// CA URL does not hold up refreshes
+var caFetchTimeout = 10 * time.Second
+
+// caBundle caches the CA certificates used to verify the API server of a
+// remote cluster. Certificates are loaded from a URL, a file or inline PEM data
+// and refreshed in the background by Run. The bundle may contain multiple
+// certificates, to allow for CA rotation. If a refresh fails the last known
+// good bundle is kept. TLS handshakes only read the cached certificates.
+type caBundle struct {
+	source          string // Where the bundle is loaded from, for logging
+	cluster         string
+	load            func() ([]byte, error)
+	refreshInterval time.Duration
+	mu              sync.RWMutex
+	roots           *x509.CertPool
+}
+
+// newCABundle returns a CA bundle that is loaded from caURL, caFile or caData,
+// in that order of precedence. Bundles from a file or inline data must load
+// successfully. Failures to fetch from a URL are retried by Run, so that the
+// remote cluster's CA URL being down does not block startup.
+func newCABundle(caURL, caFile, caData string, refreshInterval time.Duration, cluster string) (*caBundle, error) {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultCARefreshInterval
+	}
+	b := &caBundle{
+		cluster:         cluster,
+		refreshInterval: refreshInterval,
+	}
+	switch {
+	case caURL != "":
+		b.source = caURL
+		b.load = func() ([]byte, error) { return fetchCA(caURL) }
+	case caFile != "":
+		b.source = caFile
+		b.load = func() ([]byte, error) { return os.ReadFile(caFile) }
+	case caData != "":
+		b.source = "inline"
+		b.load = func() ([]byte, error) { return []byte(caData), nil }
+	default:
+		return nil, fmt.Errorf("no CA URL, file or data provided")
+	}
+	if err := b.refresh(); err != nil {
+		if caURL == "" {
+			return nil, err
+		}
+		log.Logger.Warn("cannot fetch remote CA, will retry", "cluster", cluster, "url", caURL, "err", err)
+	}
+	return b, nil
+}
+
+// fetchCA gets a PEM encoded CA bundle from a URL
+func fetchCA(caURL string) ([]byte, error) {
+	client := &http.Client{Timeout: caFetchTimeout}
+	resp, err := client.Get(caURL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting remote CA from %s: %v", caURL, err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected %d response from %s, got %d", http.StatusOK, caURL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body from %s: %v", caURL, err)
+	}
+	return body, nil
+}
+
+// refresh loads the bundle and replaces the cached certificates if it is
+// valid. The lock is only held to replace the certificates, so that loading
+// does not block TLS handshakes.
+func (b *caBundle) refresh() error {
+	data, err := b.load()
+	if err == nil {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			err = fmt.Errorf("failed to parse root certificate from %s", b.source)
+		} else {
+			b.mu.Lock()
+			b.roots = roots
+			b.mu.Unlock()
+		}
+	}
+	if err != nil {
+		metrics.IncRemoteCARefreshes(b.cluster, "error")
+		return err
+	}
+	metrics.IncRemoteCARefreshes(b.cluster, "success")
+	return nil
+}
+
+// Run refreshes the bundle every refresh interval until the context is
+// cancelled. Failed refreshes are retried sooner.
+func (b *caBundle) Run(ctx context.Context) {
+	wait := b.refreshInterval
+	// The first load failed
+	if _, err := b.Roots(); err != nil {
+		wait = min(caRetryPeriod, b.refreshInterval)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = b.refreshInterval
+		if err := b.refresh(); err != nil {
+			log.Logger.Warn("cannot refresh remote CA, using last known good", "cluster", b.cluster, "source", b.source, "err", err)
+			wait = min(caRetryPeriod, b.refreshInterval)
+		}
+	}
+}
+
+// Roots returns the cached certificates. It errors if no certificates were
+// ever loaded.
+func (b *caBundle) Roots() (*x509.CertPool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.roots == nil {
+		return nil, fmt.Errorf("remote CA from %s not loaded yet", b.source)
+	}
+	return b.roots, nil
+}
+
+// verifyConn verifies the certificate chain of the remote API server against
+// the cached CA bundle
+func (b *caBundle) verifyConn(cs tls.ConnectionState) error {
+	roots, err := b.Roots()
+	if err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificates presented by %s", cs.ServerName)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,218 @@
+package kube
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func testCAClient(b *caBundle) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				VerifyConnection:   b.verifyConn,
+			},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// testSelfSignedCA returns a PEM encoded self signed CA certificate
+func testSelfSignedCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCABundleFromURL(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer apiServer.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw})
+
+	var mu sync.Mutex
+	fetches := 0
+	caAvailable := true
+	caServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if !caAvailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(caPEM)
+	}))
+	defer caServer.Close()
+
+	b, err := newCABundle(caServer.URL, "", "", time.Hour, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testCAClient(b)
+	get := func() error {
+		resp, err := client.Get(apiServer.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	// The bundle is fetched once and cached for all handshakes
+	assert.Equal(t, nil, get())
+	assert.Equal(t, nil, get())
+	mu.Lock()
+	assert.Equal(t, 1, fetches)
+	caAvailable = false
+	mu.Unlock()
+
+	// A failed refresh keeps the last known good bundle
+	assert.NotEqual(t, nil, b.refresh())
+	assert.Equal(t, nil, get())
+	mu.Lock()
+	assert.Equal(t, 2, fetches)
+	mu.Unlock()
+}
+
+func TestCABundleRun(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	fetches := 0
+	caServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.Write(testSelfSignedCA(t))
+	}))
+	defer caServer.Close()
+
+	// The bundle is refreshed in the background
+	b, err := newCABundle(caServer.URL, "", "", 10*time.Millisecond, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches >= 3
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestCABundleHangingURL(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer apiServer.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw})
+
+	hang := make(chan struct{})
+	caServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer caServer.Close()
+	defer close(hang)
+	defer func(timeout time.Duration) { caFetchTimeout = timeout }(caFetchTimeout)
+	caFetchTimeout = 100 * time.Millisecond
+
+	// A hanging CA URL times out instead of blocking
+	b, err := newCABundle(caServer.URL, "", "", time.Hour, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Roots()
+	assert.NotEqual(t, nil, err)
+
+	// Handshakes use the cached bundle while a refresh hangs
+	b.load = func() ([]byte, error) { return caPEM, nil }
+	assert.Equal(t, nil, b.refresh())
+	b.load = func() ([]byte, error) { return fetchCA(caServer.URL) }
+	refreshed := make(chan error)
+	go func() { refreshed <- b.refresh() }()
+	resp, err := testCAClient(b).Get(apiServer.URL)
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.NotEqual(t, nil, <-refreshed)
+}
+
+func TestCABundleFromFileAndData(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer apiServer.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw})
+	otherPEM := testSelfSignedCA(t)
+
+	// A bundle with multiple certificates, as during CA rotation
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, append(otherPEM, caPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := newCABundle("", caFile, "", time.Hour, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := testCAClient(b).Get(apiServer.URL)
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+
+	// A bundle that does not contain the server's CA fails verification
+	b, err = newCABundle("", "", string(otherPEM), time.Hour, "test-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testCAClient(b).Get(apiServer.URL)
+	assert.NotEqual(t, nil, err)
+
+	// Invalid file and data fail early
+	_, err = newCABundle("", filepath.Join(t.TempDir(), "missing"), "", time.Hour, "test-cluster")
+	assert.NotEqual(t, nil, err)
+	_, err = newCABundle("", "", "not a pem", time.Hour, "test-cluster")
+	assert.NotEqual(t, nil, err)
+}
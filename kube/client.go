@@ -1,76 +1,212 @@
 package kube
 
 import (
-	"fmt"
-	"io"
-
+	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"io/ioutil"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	// in case of local kube config
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	// OIDC auth provider for kubeconfigs, exec plugins are built in
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
-type certMan struct {
-	caURL string
+// Clientset is a Kubernetes clientset together with a dynamic client, which is
+// used for the custom resources of the Multi-Cluster Services API
+type Clientset struct {
+	*kubernetes.Clientset
+	Dynamic dynamic.Interface
+	ca      *caBundle // CA bundle of the remote API server, nil if the config verifies it
 }
 
-func (cm *certMan) verifyConn(cs tls.ConnectionState) error {
-	resp, err := http.Get(cm.caURL)
-	if err != nil {
-		return fmt.Errorf("error getting remote CA from %s: %v", cm.caURL, err)
+// Run refreshes the CA bundle of the client in the background until the
+// context is cancelled. Clients without a CA bundle return immediately.
+func (c *Clientset) Run(ctx context.Context) {
+	if c.ca == nil {
+		return
 	}
-	defer func() {
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-	}()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected %d response from %s, got %d", http.StatusOK, cm.caURL, resp.StatusCode)
+	c.ca.Run(ctx)
+}
+
+// newClientset returns the clientset and dynamic client for the config. Both
+// share the http client, which is created from the config if nil.
+func newClientset(conf *rest.Config, httpClient *http.Client) (*Clientset, error) {
+	if httpClient == nil {
+		var err error
+		if httpClient, err = rest.HTTPClientFor(conf); err != nil {
+			return nil, fmt.Errorf("failed to create http client: %v", err)
+		}
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	client, err := kubernetes.NewForConfigAndClient(conf, httpClient)
 	if err != nil {
-		return fmt.Errorf("error reading response body from %s: %v", cm.caURL, err)
+		return nil, err
 	}
-	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM(body)
-	if !ok {
-		return fmt.Errorf("failed to parse root certificate from %s", cm.caURL)
+	dynamicClient, err := dynamic.NewForConfigAndClient(conf, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &Clientset{Clientset: client, Dynamic: dynamicClient}, nil
+}
+
+// Client returns a Kubernetes client (clientset) for a remote cluster from
+// tokenPath, apiURL and a CA bundle from caURL, caFile or caData. The token is
+// re-read from tokenPath when it is rotated and the CA bundle is refreshed
+// every caRefreshInterval while the client runs.
+func Client(tokenPath, apiURL, caURL, caFile, caData string, caRefreshInterval time.Duration, cluster string) (*Clientset, error) {
+	auth, err := newFileTokenAuth(tokenPath, cluster)
+	if err != nil {
+		return nil, err
 	}
-	opts := x509.VerifyOptions{
-		DNSName: cs.ServerName,
-		Roots:   roots,
+	return remoteClient(auth.wrap, apiURL, caURL, caFile, caData, caRefreshInterval, cluster)
+}
+
+// TokenClient returns a Kubernetes client (clientset) for a remote cluster that
+// authenticates with a static token
+func TokenClient(token, apiURL, caURL, caFile, caData string, caRefreshInterval time.Duration, cluster string) (*Clientset, error) {
+	token = strings.TrimSpace(token)
+	if token != "" && !bearerRe.MatchString(token) {
+		return nil, fmt.Errorf("The provided token does not match regex: %s", bearerRe.String())
 	}
-	_, err = cs.PeerCertificates[0].Verify(opts)
-	return err
+	return remoteClient(staticTokenAuth(token), apiURL, caURL, caFile, caData, caRefreshInterval, cluster)
 }
 
-// Client returns a Kubernetes client (clientset) from token, apiURL and caURL
-func Client(token, apiURL, caURL string) (*kubernetes.Clientset, error) {
-	cm := &certMan{caURL}
+// remoteClient returns a Kubernetes client (clientset) for a remote cluster
+// that verifies the API server against a cached CA bundle and authenticates
+// requests with the auth wrapper
+func remoteClient(auth func(http.RoundTripper) http.RoundTripper, apiURL, caURL, caFile, caData string, caRefreshInterval time.Duration, cluster string) (*Clientset, error) {
+	ca, err := newCABundle(caURL, caFile, caData, caRefreshInterval, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("loading CA bundle: %v", err)
+	}
 	conf := &rest.Config{
 		Host: apiURL,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
+				// Verification is done against the cached CA bundle
 				InsecureSkipVerify: true,
-				VerifyConnection:   cm.verifyConn}},
-		BearerToken: token,
+				VerifyConnection:   ca.verifyConn}},
+	}
+	conf.Wrap(auth)
+	conf.Wrap(authFailureRecorder(cluster))
+	conf.Wrap(HealthOf(cluster).wrap)
+	client, err := newClientset(conf, nil)
+	if err != nil {
+		return nil, err
 	}
-	return kubernetes.NewForConfig(conf)
+	client.ca = ca
+	return client, nil
 }
 
 // ClientFromConfig returns a Kubernetes client (clientset) from the kubeconfig
-// path or from the in-cluster service account environment.
-func ClientFromConfig(path string) (*kubernetes.Clientset, error) {
+// path or from the in-cluster service account environment. In dry run mode
+// the client only logs the mutating calls it would have made, instead of
+// sending them to the API. Request results are recorded in the health of the
+// cluster.
+func ClientFromConfig(path, cluster string, dryRun bool) (*Clientset, error) {
+	conf, err := getClientConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes client config: %v", err)
+	}
+	conf.Wrap(HealthOf(cluster).wrap)
+	if dryRun {
+		withDryRun(conf)
+	}
+	return newClientset(conf, nil)
+}
+
+// Auth methods of remote cluster clients
+const (
+	AuthMethodToken      = "token"      // Service account token and CA
+	AuthMethodKubeConfig = "kubeconfig" // Any auth method of the kubeconfig
+	AuthMethodExec       = "exec"       // Exec credential plugin from the kubeconfig
+	AuthMethodOIDC       = "oidc"       // OIDC auth provider from the kubeconfig
+)
+
+// RemoteClientFromConfig returns a Kubernetes client (clientset) for a remote
+// cluster from the kubeconfig path. Credentials from exec plugins and the OIDC
+// auth provider are refreshed when they expire. If authMethod is set, the
+// kubeconfig must use it.
+func RemoteClientFromConfig(path, authMethod, cluster string) (*Clientset, error) {
 	conf, err := getClientConfig(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Kubernetes client config: %v", err)
 	}
-	return kubernetes.NewForConfig(conf)
+	return remoteClientFromRESTConfig(conf, authMethod, cluster)
+}
+
+// RemoteClientFromKubeConfig returns a Kubernetes client (clientset) for a
+// remote cluster from the contents of a kubeconfig. If authMethod is set, the
+// kubeconfig must use it.
+func RemoteClientFromKubeConfig(kubeConfig []byte, authMethod, cluster string) (*Clientset, error) {
+	conf, err := clientcmd.RESTConfigFromKubeConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes client config: %v", err)
+	}
+	return remoteClientFromRESTConfig(conf, authMethod, cluster)
+}
+
+// remoteClientFromRESTConfig returns a Kubernetes client (clientset) for a
+// remote cluster that records auth failures, credential errors and the health
+// of the cluster
+func remoteClientFromRESTConfig(conf *rest.Config, authMethod, cluster string) (*Clientset, error) {
+	if err := checkAuthMethod(conf, authMethod); err != nil {
+		return nil, err
+	}
+	// Exec plugins and auth providers wrap the transport after the config
+	// wrappers, so credential errors are recorded around the http client's
+	// transport
+	outer, inner := credentialErrorRecorder(cluster)
+	conf.Wrap(inner)
+	conf.Wrap(authFailureRecorder(cluster))
+	httpClient, err := rest.HTTPClientFor(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http client: %v", err)
+	}
+	httpClient.Transport = HealthOf(cluster).wrap(outer(httpClient.Transport))
+	return newClientset(conf, httpClient)
+}
+
+// CheckKubeConfigCredentialPlugins errors if any user of the kubeconfig gets
+// its credentials from an exec plugin or an auth provider. Both run code in the
+// operator's pod, so they are only allowed in kubeconfigs from trusted sources.
+func CheckKubeConfigCredentialPlugins(kubeConfig []byte) error {
+	conf, err := clientcmd.Load(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	for name, user := range conf.AuthInfos {
+		if user.Exec != nil {
+			return fmt.Errorf("user %s of the kubeconfig uses an exec credential plugin", name)
+		}
+		if user.AuthProvider != nil {
+			return fmt.Errorf("user %s of the kubeconfig uses the %s auth provider", name, user.AuthProvider.Name)
+		}
+	}
+	return nil
+}
+
+// checkAuthMethod errors if the client config does not use the auth method
+func checkAuthMethod(conf *rest.Config, authMethod string) error {
+	switch authMethod {
+	case "", AuthMethodKubeConfig:
+		return nil
+	case AuthMethodExec:
+		if conf.ExecProvider == nil {
+			return fmt.Errorf("auth method is %s but the kubeconfig has no exec credential plugin", authMethod)
+		}
+	case AuthMethodOIDC:
+		if conf.AuthProvider == nil || conf.AuthProvider.Name != "oidc" {
+			return fmt.Errorf("auth method is %s but the kubeconfig has no oidc auth provider", authMethod)
+		}
+	default:
+		return fmt.Errorf("unsupported auth method for kubeconfig: %s", authMethod)
+	}
+	return nil
 }
 
 // getClientConfig returns a Kubernetes client Config.
@@ -2,8 +2,13 @@ package kube
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -17,8 +22,47 @@ func GetService(ctx context.Context, client kubernetes.Interface, name, namespac
 	)
 }
 
-// CreateService creates a clusterIP or headless type service.
-func CreateService(ctx context.Context, client kubernetes.Interface, name, namespace string, labels, annotations map[string]string, ports []v1.ServicePort, headless bool) (*v1.Service, error) {
+// ErrServiceRecreateRequired is returned by UpdateService when the desired spec
+// changes immutable fields of the service, which can only be applied by
+// deleting and creating the service again
+var ErrServiceRecreateRequired = errors.New("immutable service fields changed, service needs to be recreated")
+
+// MirroredServiceSpec returns the fields of a remote service spec that are
+// mirrored. Selectors, cluster IPs and node ports belong to the remote cluster
+// and are never mirrored, only whether the service is headless. ExternalName
+// services are mirrored as ExternalName services, all other types as ClusterIP
+// services.
+func MirroredServiceSpec(spec v1.ServiceSpec) v1.ServiceSpec {
+	mirrored := v1.ServiceSpec{
+		Ports:                    make([]v1.ServicePort, 0, len(spec.Ports)),
+		SessionAffinity:          spec.SessionAffinity,
+		SessionAffinityConfig:    spec.SessionAffinityConfig.DeepCopy(),
+		IPFamilies:               append([]v1.IPFamily(nil), spec.IPFamilies...),
+		IPFamilyPolicy:           spec.IPFamilyPolicy,
+		PublishNotReadyAddresses: spec.PublishNotReadyAddresses,
+		InternalTrafficPolicy:    spec.InternalTrafficPolicy,
+		TrafficDistribution:      spec.TrafficDistribution,
+	}
+	for _, p := range spec.Ports {
+		p.NodePort = 0
+		mirrored.Ports = append(mirrored.Ports, p)
+	}
+	if spec.Type == v1.ServiceTypeExternalName {
+		return v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: spec.ExternalName,
+			Ports:        mirrored.Ports,
+		}
+	}
+	if spec.ClusterIP == v1.ClusterIPNone {
+		mirrored.ClusterIP = v1.ClusterIPNone
+	}
+	return mirrored
+}
+
+// CreateService creates a clusterIP, headless or ExternalName type service with
+// the mirrored fields of the passed spec.
+func CreateService(ctx context.Context, client kubernetes.Interface, name, namespace string, labels, annotations map[string]string, spec v1.ServiceSpec) (*v1.Service, error) {
 	svc := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        name,
@@ -26,13 +70,10 @@ func CreateService(ctx context.Context, client kubernetes.Interface, name, names
 			Labels:      labels,
 			Annotations: annotations,
 		},
-		Spec: v1.ServiceSpec{
-			Ports:    ports,
-			Selector: nil,
-		},
 	}
-	if headless {
-		svc.Spec.ClusterIP = "None"
+	applyServiceSpec(&svc.Spec, spec)
+	if spec.ClusterIP == v1.ClusterIPNone {
+		svc.Spec.ClusterIP = v1.ClusterIPNone
 	}
 	return client.CoreV1().Services(namespace).Create(
 		ctx,
@@ -41,19 +82,94 @@ func CreateService(ctx context.Context, client kubernetes.Interface, name, names
 	)
 }
 
-// UpdateService updates service ports. No need to cater for headless services
-// as clusterIP field is immutable and will fail.
-func UpdateService(ctx context.Context, client kubernetes.Interface, service *v1.Service, ports []v1.ServicePort) (*v1.Service, error) {
-	service.Spec.Ports = ports
-	service.Spec.Selector = nil
-
-	return client.CoreV1().Services(service.Namespace).Update(
+// UpdateService updates the labels, annotations and mirrored spec fields of a
+// service. The service is returned as is, without calling the API, if nothing
+// changed. Returns ErrServiceRecreateRequired if the spec changes whether the
+// service is an ExternalName or headless service, or its primary IP family,
+// which are immutable.
+func UpdateService(ctx context.Context, client kubernetes.Interface, service *v1.Service, labels, annotations map[string]string, spec v1.ServiceSpec) (*v1.Service, error) {
+	if ServiceRecreateReason(service, spec) != "" {
+		return nil, ErrServiceRecreateRequired
+	}
+	updated := service.DeepCopy()
+	updated.Labels = labels
+	updated.Annotations = annotations
+	applyServiceSpec(&updated.Spec, spec)
+	if equality.Semantic.DeepEqual(service.ObjectMeta, updated.ObjectMeta) && equality.Semantic.DeepEqual(service.Spec, updated.Spec) {
+		return service, nil
+	}
+	return client.CoreV1().Services(updated.Namespace).Update(
 		ctx,
-		service,
+		updated,
 		metav1.UpdateOptions{},
 	)
 }
 
+// Reasons for recreating a service, returned by ServiceRecreateReason
+const (
+	RecreateReasonType     = "type"     // The service switches to or from ExternalName
+	RecreateReasonHeadless = "headless" // The service switches between headless and ClusterIP
+	RecreateReasonIPFamily = "ipFamily" // The primary IP family of the service changes
+)
+
+// ServiceRecreateReason returns why applying the mirrored fields of the passed
+// spec to the service requires recreating it, or an empty string if it can be
+// updated in place
+func ServiceRecreateReason(service *v1.Service, spec v1.ServiceSpec) string {
+	if (service.Spec.Type == v1.ServiceTypeExternalName) != (spec.Type == v1.ServiceTypeExternalName) {
+		return RecreateReasonType
+	}
+	if (service.Spec.ClusterIP == v1.ClusterIPNone) != (spec.ClusterIP == v1.ClusterIPNone) {
+		return RecreateReasonHeadless
+	}
+	if len(service.Spec.IPFamilies) > 0 && len(spec.IPFamilies) > 0 && service.Spec.IPFamilies[0] != spec.IPFamilies[0] {
+		return RecreateReasonIPFamily
+	}
+	return ""
+}
+
+// RecreateService deletes the service and creates it again with the passed
+// labels, annotations and spec. The delete is conditional on the UID of the
+// passed service, so that a service created in the meantime is not deleted.
+func RecreateService(ctx context.Context, client kubernetes.Interface, service *v1.Service, labels, annotations map[string]string, spec v1.ServiceSpec) (*v1.Service, error) {
+	err := client.CoreV1().Services(service.Namespace).Delete(
+		ctx,
+		service.Name,
+		metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(service.UID))},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("deleting service: %v", err)
+	}
+	return CreateService(ctx, client, service.Name, service.Namespace, labels, annotations, spec)
+}
+
+// applyServiceSpec sets the mirrored fields of spec on the service spec.
+// Optional fields that are not set in spec are left to the API server
+// defaults.
+func applyServiceSpec(svcSpec *v1.ServiceSpec, spec v1.ServiceSpec) {
+	svcSpec.Ports = spec.Ports
+	svcSpec.Selector = nil
+	if spec.Type != "" {
+		svcSpec.Type = spec.Type
+	}
+	svcSpec.ExternalName = spec.ExternalName
+	svcSpec.PublishNotReadyAddresses = spec.PublishNotReadyAddresses
+	svcSpec.TrafficDistribution = spec.TrafficDistribution
+	if spec.SessionAffinity != "" {
+		svcSpec.SessionAffinity = spec.SessionAffinity
+		svcSpec.SessionAffinityConfig = spec.SessionAffinityConfig
+	}
+	if len(spec.IPFamilies) > 0 {
+		svcSpec.IPFamilies = spec.IPFamilies
+	}
+	if spec.IPFamilyPolicy != nil {
+		svcSpec.IPFamilyPolicy = spec.IPFamilyPolicy
+	}
+	if spec.InternalTrafficPolicy != nil {
+		svcSpec.InternalTrafficPolicy = spec.InternalTrafficPolicy
+	}
+}
+
 // DeleteService returns a client delete service request
 func DeleteService(ctx context.Context, client kubernetes.Interface, name, namespace string) error {
 	return client.CoreV1().Services(namespace).Delete(
@@ -62,3 +178,57 @@ func DeleteService(ctx context.Context, client kubernetes.Interface, name, names
 		metav1.DeleteOptions{},
 	)
 }
+
+// ServiceOwnerReferences returns the owner references of objects derived from a
+// service, so that the garbage collector deletes them with it. Returns nil for
+// services without a UID, which the API server did not create. Deletion of the
+// service is not blocked by its dependents.
+func ServiceOwnerReferences(svc *v1.Service) []metav1.OwnerReference {
+	if svc == nil || svc.UID == "" {
+		return nil
+	}
+	controller := true
+	return []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       svc.Name,
+		UID:        svc.UID,
+		Controller: &controller,
+	}}
+}
+
+// OwnedByService returns true if the owner references include the service.
+// Services without a UID cannot be referenced, so everything is considered
+// owned by them.
+func OwnedByService(refs []metav1.OwnerReference, svc *v1.Service) bool {
+	if svc.UID == "" {
+		return true
+	}
+	return slices.ContainsFunc(refs, func(ref metav1.OwnerReference) bool {
+		return ref.UID == svc.UID
+	})
+}
+
+// SetServiceFinalizer adds the finalizer to the service if present is true, or
+// removes it otherwise. The service is returned as is, without calling the API,
+// if nothing changed. Finalizers cannot be added to services that are being
+// deleted, so these are returned as is too.
+func SetServiceFinalizer(ctx context.Context, client kubernetes.Interface, service *v1.Service, finalizer string, present bool) (*v1.Service, error) {
+	if slices.Contains(service.Finalizers, finalizer) == present {
+		return service, nil
+	}
+	if present && service.DeletionTimestamp != nil {
+		return service, nil
+	}
+	updated := service.DeepCopy()
+	if present {
+		updated.Finalizers = append(updated.Finalizers, finalizer)
+	} else {
+		updated.Finalizers = slices.DeleteFunc(updated.Finalizers, func(f string) bool { return f == finalizer })
+	}
+	return client.CoreV1().Services(updated.Namespace).Update(
+		ctx,
+		updated,
+		metav1.UpdateOptions{},
+	)
+}
@@ -0,0 +1,172 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMirroredServiceSpec(t *testing.T) {
+	timeout := int32(60)
+	local := v1.ServiceInternalTrafficPolicyLocal
+	singleStack := v1.IPFamilyPolicySingleStack
+	spec := MirroredServiceSpec(v1.ServiceSpec{
+		Type:                     v1.ServiceTypeNodePort,
+		Ports:                    []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}},
+		Selector:                 map[string]string{"app": "x"},
+		ClusterIP:                "10.0.0.1",
+		ClusterIPs:               []string{"10.0.0.1"},
+		SessionAffinity:          v1.ServiceAffinityClientIP,
+		SessionAffinityConfig:    &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}},
+		IPFamilies:               []v1.IPFamily{v1.IPv4Protocol},
+		IPFamilyPolicy:           &singleStack,
+		PublishNotReadyAddresses: true,
+		InternalTrafficPolicy:    &local,
+	})
+	assert.Equal(t, v1.ServiceSpec{
+		Ports:                    []v1.ServicePort{{Name: "http", Port: 80}},
+		SessionAffinity:          v1.ServiceAffinityClientIP,
+		SessionAffinityConfig:    &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}},
+		IPFamilies:               []v1.IPFamily{v1.IPv4Protocol},
+		IPFamilyPolicy:           &singleStack,
+		PublishNotReadyAddresses: true,
+		InternalTrafficPolicy:    &local,
+	}, spec)
+
+	assert.Equal(t, v1.ClusterIPNone, MirroredServiceSpec(v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}).ClusterIP)
+	assert.Equal(t, v1.ServiceSpec{
+		Type:         v1.ServiceTypeExternalName,
+		ExternalName: "example.com",
+		Ports:        []v1.ServicePort{},
+	}, MirroredServiceSpec(v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "example.com", SessionAffinity: v1.ServiceAffinityNone}))
+}
+
+func TestServiceRecreateReason(t *testing.T) {
+	clusterIP := &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, ClusterIP: "10.0.0.1", IPFamilies: []v1.IPFamily{v1.IPv4Protocol}}}
+	assert.Equal(t, "", ServiceRecreateReason(clusterIP, v1.ServiceSpec{}))
+	assert.Equal(t, "", ServiceRecreateReason(clusterIP, v1.ServiceSpec{IPFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}}))
+	assert.Equal(t, RecreateReasonHeadless, ServiceRecreateReason(clusterIP, v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}))
+	assert.Equal(t, RecreateReasonIPFamily, ServiceRecreateReason(clusterIP, v1.ServiceSpec{IPFamilies: []v1.IPFamily{v1.IPv6Protocol}}))
+	assert.Equal(t, RecreateReasonType, ServiceRecreateReason(clusterIP, v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "example.com"}))
+
+	externalName := &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "example.com"}}
+	assert.Equal(t, "", ServiceRecreateReason(externalName, v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "example.org"}))
+	assert.Equal(t, RecreateReasonType, ServiceRecreateReason(externalName, v1.ServiceSpec{}))
+}
+
+func TestUpdateService(t *testing.T) {
+	ctx := context.Background()
+	existing := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", Labels: map[string]string{"a": "b"}},
+		Spec: v1.ServiceSpec{
+			Ports:           []v1.ServicePort{{Port: 80}},
+			ClusterIP:       "10.0.0.1",
+			SessionAffinity: v1.ServiceAffinityNone,
+			IPFamilies:      []v1.IPFamily{v1.IPv4Protocol},
+		},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	// Nothing changed, no update request
+	spec := v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}}
+	_, err := UpdateService(ctx, client, existing, existing.Labels, nil, spec)
+	assert.Equal(t, nil, err)
+	for _, a := range client.Actions() {
+		assert.NotEqual(t, "update", a.GetVerb())
+	}
+
+	spec.PublishNotReadyAddresses = true
+	updated, err := UpdateService(ctx, client, existing, existing.Labels, nil, spec)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, updated.Spec.PublishNotReadyAddresses)
+	assert.Equal(t, "10.0.0.1", updated.Spec.ClusterIP)
+	assert.Equal(t, v1.ServiceAffinityNone, updated.Spec.SessionAffinity)
+	assert.Equal(t, false, existing.Spec.PublishNotReadyAddresses)
+
+	// Headless and IP family flips need a recreate
+	_, err = UpdateService(ctx, client, existing, existing.Labels, nil, v1.ServiceSpec{ClusterIP: v1.ClusterIPNone})
+	assert.Equal(t, ErrServiceRecreateRequired, err)
+	_, err = UpdateService(ctx, client, existing, existing.Labels, nil, v1.ServiceSpec{IPFamilies: []v1.IPFamily{v1.IPv6Protocol}})
+	assert.Equal(t, ErrServiceRecreateRequired, err)
+}
+
+func TestRecreateService(t *testing.T) {
+	ctx := context.Background()
+	existing := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", UID: "uid"},
+		Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.1"},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	svc, err := RecreateService(ctx, client, existing, map[string]string{"a": "b"}, nil, v1.ServiceSpec{ClusterIP: v1.ClusterIPNone})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v1.ClusterIPNone, svc.Spec.ClusterIP)
+	assert.Equal(t, map[string]string{"a": "b"}, svc.Labels)
+
+	del := client.Actions()[0].(k8stesting.DeleteActionImpl)
+	assert.Equal(t, "svc", del.Name)
+	assert.Equal(t, "uid", string(*del.DeleteOptions.Preconditions.UID))
+}
+
+func TestServiceOwnerReferences(t *testing.T) {
+	assert.Equal(t, []metav1.OwnerReference(nil), ServiceOwnerReferences(nil))
+	assert.Equal(t, []metav1.OwnerReference(nil), ServiceOwnerReferences(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc"}}))
+
+	refs := ServiceOwnerReferences(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", UID: "uid"}})
+	assert.Equal(t, 1, len(refs))
+	assert.Equal(t, "Service", refs[0].Kind)
+	assert.Equal(t, "svc", refs[0].Name)
+	assert.Equal(t, "uid", string(refs[0].UID))
+	assert.Equal(t, true, *refs[0].Controller)
+	assert.Equal(t, (*bool)(nil), refs[0].BlockOwnerDeletion)
+}
+
+func TestOwnedByService(t *testing.T) {
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", UID: "uid"}}
+	assert.Equal(t, true, OwnedByService(ServiceOwnerReferences(svc), svc))
+	assert.Equal(t, false, OwnedByService(nil, svc))
+
+	recreated := svc.DeepCopy()
+	recreated.UID = "new-uid"
+	assert.Equal(t, false, OwnedByService(ServiceOwnerReferences(svc), recreated))
+
+	// Services without a UID cannot be referenced
+	assert.Equal(t, true, OwnedByService(nil, &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc"}}))
+}
+
+func TestSetServiceFinalizer(t *testing.T) {
+	ctx := context.Background()
+	existing := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", Finalizers: []string{"other"}},
+	}
+	client := fake.NewSimpleClientset(existing)
+
+	svc, err := SetServiceFinalizer(ctx, client, existing, "test/finalizer", true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"other", "test/finalizer"}, svc.Finalizers)
+	assert.Equal(t, []string{"other"}, existing.Finalizers)
+
+	// Nothing changed, no update request
+	client.ClearActions()
+	same, err := SetServiceFinalizer(ctx, client, svc, "test/finalizer", true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, svc, same)
+	assert.Equal(t, 0, len(client.Actions()))
+
+	svc, err = SetServiceFinalizer(ctx, client, svc, "test/finalizer", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"other"}, svc.Finalizers)
+
+	// Finalizers are not added to services being deleted
+	now := metav1.Now()
+	svc.DeletionTimestamp = &now
+	client.ClearActions()
+	_, err = SetServiceFinalizer(ctx, client, svc, "test/finalizer", true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(client.Actions()))
+}
@@ -0,0 +1,137 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+	"k8s.io/client-go/rest"
+)
+
+// withDryRun configures the client to record mutating requests instead of
+// sending them. Requests are JSON encoded so that the recorded objects can be
+// logged.
+func withDryRun(conf *rest.Config) {
+	conf.ContentType = "application/json"
+	conf.Wrap(newDryRunRecorder)
+}
+
+// dryRunRecorder is a http.RoundTripper that passes read requests to the
+// Kubernetes API through and records mutating requests instead of executing
+// them. Recorded requests are logged, counted in metrics and answered with a
+// successful response, so that callers carry on as if they had succeeded.
+type dryRunRecorder struct {
+	next http.RoundTripper
+}
+
+func newDryRunRecorder(next http.RoundTripper) http.RoundTripper {
+	return &dryRunRecorder{next: next}
+}
+
+func (d *dryRunRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var verb string
+	switch req.Method {
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	case http.MethodPatch:
+		verb = "patch"
+	case http.MethodDelete:
+		verb = "delete"
+	default:
+		return d.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading dry run request body: %v", err)
+		}
+	}
+	resource, namespace, name := parseResourcePath(req.URL.Path)
+	if name == "" {
+		name = objectName(body)
+	}
+	log.Logger.Info(
+		"dry run: skipping request",
+		"verb", verb,
+		"resource", resource,
+		"namespace", namespace,
+		"name", name,
+	)
+	log.Logger.Debug("dry run: skipped request body", "body", string(body))
+	metrics.IncDryRunRequests(verb, resource, namespace)
+
+	// Echo the object back for creates and updates, as the API would
+	code := http.StatusOK
+	contentType := req.Header.Get("Content-Type")
+	switch verb {
+	case "create":
+		code = http.StatusCreated
+	case "patch":
+		// The patch cannot be applied to an object here, return an
+		// empty object instead
+		body = []byte("{}")
+		contentType = "application/json"
+	case "delete":
+		body = []byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`)
+		contentType = "application/json"
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// parseResourcePath returns the resource, namespace and name from a Kubernetes
+// API path, like /api/v1/namespaces/<namespace>/<resource>/<name> or
+// /apis/<group>/<version>/namespaces/<namespace>/<resource>
+func parseResourcePath(path string) (resource, namespace, name string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) > 0 && parts[0] == "api":
+		parts = parts[min(2, len(parts)):]
+	case len(parts) > 0 && parts[0] == "apis":
+		parts = parts[min(3, len(parts)):]
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		namespace = parts[1]
+		parts = parts[2:]
+	}
+	if len(parts) > 0 {
+		resource = parts[0]
+	}
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	return resource, namespace, name
+}
+
+// objectName returns the name of a JSON encoded object, or an empty string
+func objectName(body []byte) string {
+	obj := struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return ""
+	}
+	return obj.Metadata.Name
+}
@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestDryRunRecorder(t *testing.T) {
+	ctx := context.Background()
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	var mu sync.Mutex
+	methods := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Service","apiVersion":"v1","metadata":{"name":"existing","namespace":"ns"}}`))
+	}))
+	defer server.Close()
+
+	conf := &rest.Config{Host: server.URL}
+	withDryRun(conf)
+	client, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "ns"}}
+	created, err := client.CoreV1().Services("ns").Create(ctx, svc, metav1.CreateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test-svc", created.Name)
+	updated, err := client.CoreV1().Services("ns").Update(ctx, svc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test-svc", updated.Name)
+	err = client.CoreV1().Services("ns").Delete(ctx, "test-svc", metav1.DeleteOptions{})
+	assert.Equal(t, nil, err)
+
+	// Reads reach the API
+	got, err := client.CoreV1().Services("ns").Get(ctx, "existing", metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "existing", got.Name)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{http.MethodGet}, methods)
+}
+
+func TestParseResourcePath(t *testing.T) {
+	testCases := []struct {
+		path      string
+		resource  string
+		namespace string
+		name      string
+	}{
+		{"/api/v1/namespaces/ns/services/svc", "services", "ns", "svc"},
+		{"/api/v1/namespaces/ns/services", "services", "ns", ""},
+		{"/apis/discovery.k8s.io/v1/namespaces/ns/endpointslices/slice", "endpointslices", "ns", "slice"},
+		{"/api/v1/namespaces/ns", "namespaces", "", "ns"},
+	}
+	for _, tc := range testCases {
+		resource, namespace, name := parseResourcePath(tc.path)
+		assert.Equal(t, tc.resource, resource, tc.path)
+		assert.Equal(t, tc.namespace, namespace, tc.path)
+		assert.Equal(t, tc.name, name, tc.path)
+	}
+}
@@ -0,0 +1,83 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Helpers to manage the locally registered custom resources with the dynamic
+// client, converting them from and to their typed objects
+
+func getObject[T any](ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, name, namespace string) (*T, error) {
+	u, err := client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured[T](u)
+}
+
+func createObject[T any](ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, gvk schema.GroupVersionKind, obj *T, namespace string) (*T, error) {
+	u, err := toUnstructured(obj, gvk)
+	if err != nil {
+		return nil, err
+	}
+	created, err := client.Resource(gvr).Namespace(namespace).Create(ctx, u, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured[T](created)
+}
+
+func updateObject[T any](ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, gvk schema.GroupVersionKind, obj *T, namespace string) (*T, error) {
+	u, err := toUnstructured(obj, gvk)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := client.Resource(gvr).Namespace(namespace).Update(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured[T](updated)
+}
+
+func updateObjectStatus[T any](ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, gvk schema.GroupVersionKind, obj *T, namespace string) (*T, error) {
+	u, err := toUnstructured(obj, gvk)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := client.Resource(gvr).Namespace(namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured[T](updated)
+}
+
+func deleteObject(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, name, namespace string) error {
+	return client.Resource(gvr).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func fromUnstructured[T any](u *unstructured.Unstructured) (*T, error) {
+	obj := new(T)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return nil, fmt.Errorf("converting %s %s/%s: %v", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+	}
+	return obj, nil
+}
+
+// toUnstructured converts a typed object of the kind to an unstructured object
+// for the dynamic client
+func toUnstructured(obj interface{}, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("converting %s: %v", gvk.Kind, err)
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
@@ -30,10 +30,11 @@ type EndpointsWatcher struct {
 	labelSelector string
 	name          string
 	namespace     string
-	runner        string // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	runner        string         // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	health        *ClusterHealth // Health of the watched cluster, nil to not record it
 }
 
-func NewEndpointsWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler EndpointsEventHandler, labelSelector, namespace, runner string) *EndpointsWatcher {
+func NewEndpointsWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler EndpointsEventHandler, labelSelector, namespace, runner string, health *ClusterHealth) *EndpointsWatcher {
 	return &EndpointsWatcher{
 		ctx:           context.Background(),
 		client:        client,
@@ -44,6 +45,7 @@ func NewEndpointsWatcher(name string, client kubernetes.Interface, resyncPeriod
 		name:          name,
 		namespace:     namespace,
 		runner:        runner,
+		health:        health,
 	}
 }
 
@@ -54,6 +56,9 @@ func (ew *EndpointsWatcher) Init() {
 			l, err := ew.client.CoreV1().Endpoints(ew.namespace).List(ew.ctx, options)
 			if err != nil {
 				log.Logger.Error("endpoints list error", "watcher", ew.name, "err", err)
+				ew.health.RecordWatcherError(err)
+			} else {
+				ew.health.RecordSync()
 			}
 			return l, err
 		},
@@ -62,6 +67,7 @@ func (ew *EndpointsWatcher) Init() {
 			w, err := ew.client.CoreV1().Endpoints(ew.namespace).Watch(ew.ctx, options)
 			if err != nil {
 				log.Logger.Error("endpoints watch error", "watcher", ew.name, "err", err)
+				ew.health.RecordWatcherError(err)
 			}
 			return w, err
 		},
@@ -77,7 +83,7 @@ func (ew *EndpointsWatcher) Init() {
 			ew.handleEvent(watch.Deleted, obj.(*v1.Endpoints), nil)
 		},
 	}
-	ew.store, ew.controller = cache.NewInformer(listWatch, &v1.Endpoints{}, ew.resyncPeriod, eventHandler)
+	ew.store, ew.controller = cache.NewInformer(cache.ToListWatcherWithWatchListSemantics(listWatch, ew.client), &v1.Endpoints{}, ew.resyncPeriod, eventHandler)
 }
 
 func (ew *EndpointsWatcher) handleEvent(eventType watch.EventType, oldObj, newObj *v1.Endpoints) {
@@ -101,6 +107,10 @@ func (ew *EndpointsWatcher) Stop() {
 	close(ew.stopChannel)
 }
 
+func (ew *EndpointsWatcher) HasSynced() bool {
+	return ew.controller.HasSynced()
+}
+
 func (ew *EndpointsWatcher) Get(name, namespace string) (*v1.Endpoints, error) {
 	key := namespace + "/" + name
 
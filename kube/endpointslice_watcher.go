@@ -30,10 +30,11 @@ type EndpointSliceWatcher struct {
 	labelSelector string
 	name          string
 	namespace     string
-	runner        string // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	runner        string         // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	health        *ClusterHealth // Health of the watched cluster, nil to not record it
 }
 
-func NewEndpointSliceWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler EndpointSliceEventHandler, labelSelector, namespace, runner string) *EndpointSliceWatcher {
+func NewEndpointSliceWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler EndpointSliceEventHandler, labelSelector, namespace, runner string, health *ClusterHealth) *EndpointSliceWatcher {
 	return &EndpointSliceWatcher{
 		ctx:           context.Background(),
 		client:        client,
@@ -44,6 +45,7 @@ func NewEndpointSliceWatcher(name string, client kubernetes.Interface, resyncPer
 		name:          name,
 		namespace:     namespace,
 		runner:        runner,
+		health:        health,
 	}
 }
 
@@ -54,7 +56,9 @@ func (esw *EndpointSliceWatcher) Init() {
 			l, err := esw.client.DiscoveryV1().EndpointSlices(esw.namespace).List(esw.ctx, options)
 			if err != nil {
 				log.Logger.Error("EndpointSlice list error", "watcher", esw.name, "err", err)
+				esw.health.RecordWatcherError(err)
 			} else {
+				esw.health.RecordSync()
 			}
 			return l, err
 		},
@@ -63,7 +67,7 @@ func (esw *EndpointSliceWatcher) Init() {
 			w, err := esw.client.DiscoveryV1().EndpointSlices(esw.namespace).Watch(esw.ctx, options)
 			if err != nil {
 				log.Logger.Error("EndpointSlice watch error", "watcher", esw.name, "err", err)
-			} else {
+				esw.health.RecordWatcherError(err)
 			}
 			return w, err
 		},
@@ -79,7 +83,7 @@ func (esw *EndpointSliceWatcher) Init() {
 			esw.handleEvent(watch.Deleted, obj.(*discoveryv1.EndpointSlice), nil)
 		},
 	}
-	esw.store, esw.controller = cache.NewInformer(listWatch, &discoveryv1.EndpointSlice{}, esw.resyncPeriod, eventHandler)
+	esw.store, esw.controller = cache.NewInformer(cache.ToListWatcherWithWatchListSemantics(listWatch, esw.client), &discoveryv1.EndpointSlice{}, esw.resyncPeriod, eventHandler)
 }
 
 func (esw *EndpointSliceWatcher) handleEvent(eventType watch.EventType, oldObj, newObj *discoveryv1.EndpointSlice) {
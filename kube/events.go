@@ -0,0 +1,69 @@
+package kube
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// EventRecorder records Kubernetes events on objects of the local cluster.
+// Events are dropped until InitEventRecorder is called.
+var EventRecorder record.EventRecorder = &record.FakeRecorder{}
+
+// Limits of the event correlator. Each object gets a burst of events of each
+// reason, refilled at one event per 5 minutes. Similar events of an object,
+// which only differ in their message, are combined into one after 10 events in
+// 10 minutes.
+const (
+	eventBurst              = 25
+	eventQPS                = 1. / 300
+	eventMaxSimilar         = 10
+	eventSimilarIntervalSec = 600
+)
+
+// InitEventRecorder sends the events recorded by EventRecorder to the API server
+// through the passed client. Repeated events are rate limited and aggregated.
+// Returns a function that flushes and stops the recorder.
+func InitEventRecorder(client kubernetes.Interface, component string) func() {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize:            eventBurst,
+		QPS:                  eventQPS,
+		MaxEvents:            eventMaxSimilar,
+		MaxIntervalInSeconds: eventSimilarIntervalSec,
+		SpamKeyFunc:          eventSpamKey,
+	}))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	EventRecorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+	return broadcaster.Shutdown
+}
+
+// eventSpamKey rate limits events per object and reason, so that a flood of
+// failures does not drop the lifecycle events of the object
+func eventSpamKey(event *v1.Event) string {
+	return strings.Join([]string{
+		event.Source.Component,
+		event.Source.Host,
+		event.InvolvedObject.Kind,
+		event.InvolvedObject.Namespace,
+		event.InvolvedObject.Name,
+		string(event.InvolvedObject.UID),
+		event.InvolvedObject.APIVersion,
+		event.Type,
+		event.Reason,
+	}, "")
+}
+
+// ServiceReference returns a reference to a service, to record events on
+// services that do not exist or are already deleted
+func ServiceReference(name, namespace string) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       name,
+		Namespace:  namespace,
+	}
+}
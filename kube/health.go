@@ -0,0 +1,168 @@
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClusterHealth tracks the connectivity to the API server of a cluster. It is
+// fed by the results of the requests of the cluster's client and by the list
+// and watch results of the watchers. A nil ClusterHealth ignores all results.
+type ClusterHealth struct {
+	mu            sync.Mutex
+	cluster       string
+	lastSuccess   time.Time // Last successful request to the API server
+	lastSync      time.Time // Last successful list of a watcher
+	lastError     string
+	lastErrorTime time.Time
+	errorStreak   int       // Number of consecutive failed requests
+	failingSince  time.Time // First failed request of the error streak
+	now           func() time.Time
+}
+
+// ClusterHealthStatus is a snapshot of the health of a cluster
+type ClusterHealthStatus struct {
+	Cluster       string    `json:"cluster"`
+	LastSuccess   time.Time `json:"lastSuccess"`
+	LastSync      time.Time `json:"lastSync"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+	ErrorStreak   int       `json:"errorStreak"`
+	FailingSince  time.Time `json:"failingSince"`
+}
+
+var (
+	healthMu      sync.Mutex
+	clusterHealth = map[string]*ClusterHealth{}
+)
+
+// HealthOf returns the health tracker of a cluster, creating it if needed
+func HealthOf(cluster string) *ClusterHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	if h, ok := clusterHealth[cluster]; ok {
+		return h
+	}
+	h := &ClusterHealth{cluster: cluster, now: time.Now}
+	clusterHealth[cluster] = h
+	return h
+}
+
+// ForgetHealth removes the health tracker of a cluster that is no longer
+// mirrored
+func ForgetHealth(cluster string) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	delete(clusterHealth, cluster)
+}
+
+// HealthStatuses returns the health of all the tracked clusters, sorted by
+// cluster name
+func HealthStatuses() []ClusterHealthStatus {
+	healthMu.Lock()
+	trackers := make([]*ClusterHealth, 0, len(clusterHealth))
+	for _, h := range clusterHealth {
+		trackers = append(trackers, h)
+	}
+	healthMu.Unlock()
+
+	statuses := make([]ClusterHealthStatus, 0, len(trackers))
+	for _, h := range trackers {
+		statuses = append(statuses, h.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Cluster < statuses[j].Cluster })
+	return statuses
+}
+
+// RecordSuccess records a successful request to the API server
+func (h *ClusterHealth) RecordSuccess() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSuccess = h.now()
+	h.errorStreak = 0
+	h.failingSince = time.Time{}
+}
+
+// RecordError records a failed request to the API server
+func (h *ClusterHealth) RecordError(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastError = err.Error()
+	h.lastErrorTime = h.now()
+	if h.errorStreak == 0 {
+		h.failingSince = h.lastErrorTime
+	}
+	h.errorStreak++
+}
+
+// RecordSync records a successful list of a watcher
+func (h *ClusterHealth) RecordSync() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSync = h.now()
+}
+
+// RecordWatcherError records the error of a list or watch call of a watcher.
+// Failed requests are counted by the client, so the error streak is left
+// alone.
+func (h *ClusterHealth) RecordWatcherError(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastError = err.Error()
+	h.lastErrorTime = h.now()
+}
+
+// Status returns a snapshot of the health of the cluster
+func (h *ClusterHealth) Status() ClusterHealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return ClusterHealthStatus{
+		Cluster:       h.cluster,
+		LastSuccess:   h.lastSuccess,
+		LastSync:      h.lastSync,
+		LastError:     h.lastError,
+		LastErrorTime: h.lastErrorTime,
+		ErrorStreak:   h.errorStreak,
+		FailingSince:  h.failingSince,
+	}
+}
+
+// wrap records the result of every request made through the round tripper.
+// Requests that fail to reach the API server, server errors and rejected
+// credentials count as errors.
+func (h *ClusterHealth) wrap(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		switch {
+		case err != nil:
+			h.RecordError(err)
+		case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			h.RecordError(fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status))
+		default:
+			h.RecordSuccess()
+		}
+		return resp, err
+	})
+}
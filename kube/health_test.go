@@ -0,0 +1,82 @@
+package kube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterHealthWrap(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &ClusterHealth{cluster: "test", now: func() time.Time { return now }}
+	client := &http.Client{Transport: h.wrap(http.DefaultTransport)}
+	get := func() {
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	get()
+	assert.Equal(t, now, h.Status().LastSuccess)
+	assert.Equal(t, 0, h.Status().ErrorStreak)
+
+	// Server errors and rejected credentials are errors, other client
+	// errors mean the server is reachable
+	status = http.StatusServiceUnavailable
+	get()
+	status = http.StatusForbidden
+	get()
+	assert.Equal(t, 2, h.Status().ErrorStreak)
+	assert.Contains(t, h.Status().LastError, "403")
+	assert.Equal(t, now, h.Status().FailingSince)
+	status = http.StatusNotFound
+	get()
+	assert.Equal(t, 0, h.Status().ErrorStreak)
+	assert.True(t, h.Status().FailingSince.IsZero())
+
+	// Watcher errors do not count towards the streak
+	h.RecordWatcherError(http.ErrHandlerTimeout)
+	assert.Equal(t, 0, h.Status().ErrorStreak)
+	assert.Equal(t, http.ErrHandlerTimeout.Error(), h.Status().LastError)
+
+	// Unreachable servers are errors
+	server.Close()
+	get()
+	assert.Equal(t, 1, h.Status().ErrorStreak)
+}
+
+func TestClusterHealthNil(t *testing.T) {
+	var h *ClusterHealth
+	h.RecordSuccess()
+	h.RecordError(http.ErrHandlerTimeout)
+	h.RecordSync()
+	h.RecordWatcherError(http.ErrHandlerTimeout)
+}
+
+func TestHealthStatuses(t *testing.T) {
+	HealthOf("health-b").RecordSync()
+	HealthOf("health-a").RecordError(http.ErrHandlerTimeout)
+	defer ForgetHealth("health-a")
+
+	clusters := []string{}
+	for _, s := range HealthStatuses() {
+		clusters = append(clusters, s.Cluster)
+	}
+	assert.Subset(t, clusters, []string{"health-a", "health-b"})
+	assert.Equal(t, HealthOf("health-b"), HealthOf("health-b"))
+
+	ForgetHealth("health-b")
+	for _, s := range HealthStatuses() {
+		assert.NotEqual(t, "health-b", s.Cluster)
+	}
+}
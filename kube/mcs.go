@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"context"
+
+	"k8s.io/client-go/dynamic"
+)
+
+var serviceImportKind = MCSGroupVersion.WithKind("ServiceImport")
+
+// GetServiceImport returns a ServiceImport under a namespace
+func GetServiceImport(ctx context.Context, client dynamic.Interface, name, namespace string) (*ServiceImport, error) {
+	return getObject[ServiceImport](ctx, client, ServiceImportResource, name, namespace)
+}
+
+// CreateServiceImport creates a ServiceImport together with its status, which
+// the API ignores on create
+func CreateServiceImport(ctx context.Context, client dynamic.Interface, serviceImport *ServiceImport) (*ServiceImport, error) {
+	created, err := createObject(ctx, client, ServiceImportResource, serviceImportKind, serviceImport, serviceImport.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	created.Status = serviceImport.Status
+	return UpdateServiceImportStatus(ctx, client, created)
+}
+
+// UpdateServiceImport updates the metadata and spec of a ServiceImport
+func UpdateServiceImport(ctx context.Context, client dynamic.Interface, serviceImport *ServiceImport) (*ServiceImport, error) {
+	return updateObject(ctx, client, ServiceImportResource, serviceImportKind, serviceImport, serviceImport.Namespace)
+}
+
+// UpdateServiceImportStatus updates the status of a ServiceImport
+func UpdateServiceImportStatus(ctx context.Context, client dynamic.Interface, serviceImport *ServiceImport) (*ServiceImport, error) {
+	return updateObjectStatus(ctx, client, ServiceImportResource, serviceImportKind, serviceImport, serviceImport.Namespace)
+}
+
+// DeleteServiceImport deletes a ServiceImport under a namespace
+func DeleteServiceImport(ctx context.Context, client dynamic.Interface, name, namespace string) error {
+	return deleteObject(ctx, client, ServiceImportResource, name, namespace)
+}
@@ -0,0 +1,47 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestServiceImport(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.Equal(t, nil, AddMCSToScheme(scheme))
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		ServiceImportResource: "ServiceImportList",
+	})
+
+	si := &ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "remote-ns"},
+		Spec: ServiceImportSpec{
+			Ports: []ServicePort{{Name: "http", Port: 80}},
+			IPs:   []string{"10.0.0.1"},
+			Type:  ServiceImportClusterSetIP,
+		},
+		Status: ServiceImportStatus{Clusters: []ClusterStatus{{Cluster: "a"}}},
+	}
+	created, err := CreateServiceImport(ctx, client, si)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, si.Spec, created.Spec)
+	assert.Equal(t, si.Status, created.Status)
+
+	created.Spec.IPs = []string{"10.0.0.2"}
+	_, err = UpdateServiceImport(ctx, client, created)
+	assert.Equal(t, nil, err)
+	got, err := GetServiceImport(ctx, client, "test-svc", "remote-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"10.0.0.2"}, got.Spec.IPs)
+	assert.Equal(t, "ServiceImport", got.Kind)
+
+	assert.Equal(t, nil, DeleteServiceImport(ctx, client, "test-svc", "remote-ns"))
+	_, err = GetServiceImport(ctx, client, "test-svc", "remote-ns")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,211 @@
+package kube
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Types of the Kubernetes Multi-Cluster Services API (KEP-1645). They are
+// defined and registered locally instead of depending on the mcs-api module,
+// and only carry the fields used by the operator. The CRDs themselves must be
+// installed in the clusters.
+
+// MCSGroupVersion is the group version of the Multi-Cluster Services API
+var MCSGroupVersion = schema.GroupVersion{Group: "multicluster.x-k8s.io", Version: "v1alpha1"}
+
+var (
+	// ServiceExportResource is the resource of ServiceExport objects
+	ServiceExportResource = MCSGroupVersion.WithResource("serviceexports")
+	// ServiceImportResource is the resource of ServiceImport objects
+	ServiceImportResource = MCSGroupVersion.WithResource("serviceimports")
+
+	mcsSchemeBuilder = runtime.NewSchemeBuilder(addMCSTypes)
+	// AddMCSToScheme registers the Multi-Cluster Services API types
+	AddMCSToScheme = mcsSchemeBuilder.AddToScheme
+)
+
+func addMCSTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(
+		MCSGroupVersion,
+		&ServiceExport{},
+		&ServiceExportList{},
+		&ServiceImport{},
+		&ServiceImportList{},
+	)
+	metav1.AddToGroupVersion(scheme, MCSGroupVersion)
+	return nil
+}
+
+// ServiceExport marks the service with the same name and namespace as exported
+// to the clusterset
+type ServiceExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            ServiceExportStatus `json:"status,omitempty"`
+}
+
+// ServiceExportStatus holds the conditions of a ServiceExport
+type ServiceExportStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ServiceExportList is a list of ServiceExport objects
+type ServiceExportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceExport `json:"items"`
+}
+
+// ServiceImportType is the type of a ServiceImport
+type ServiceImportType string
+
+const (
+	// ServiceImportClusterSetIP imports are reached through the clusterset
+	// IPs of the import
+	ServiceImportClusterSetIP ServiceImportType = "ClusterSetIP"
+	// ServiceImportHeadless imports are reached through the IPs of the
+	// endpoints
+	ServiceImportHeadless ServiceImportType = "Headless"
+)
+
+// ServiceImport describes a service imported from the clusters of the
+// clusterset
+type ServiceImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ServiceImportSpec   `json:"spec,omitempty"`
+	Status            ServiceImportStatus `json:"status,omitempty"`
+}
+
+// ServiceImportSpec describes an imported service
+type ServiceImportSpec struct {
+	Ports                 []ServicePort             `json:"ports"`
+	IPs                   []string                  `json:"ips,omitempty"`
+	Type                  ServiceImportType         `json:"type"`
+	SessionAffinity       v1.ServiceAffinity        `json:"sessionAffinity,omitempty"`
+	SessionAffinityConfig *v1.SessionAffinityConfig `json:"sessionAffinityConfig,omitempty"`
+}
+
+// ServicePort is a port of an imported service
+type ServicePort struct {
+	Name        string      `json:"name,omitempty"`
+	Protocol    v1.Protocol `json:"protocol,omitempty"`
+	AppProtocol *string     `json:"appProtocol,omitempty"`
+	Port        int32       `json:"port"`
+}
+
+// ServiceImportStatus lists the clusters that export the service
+type ServiceImportStatus struct {
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+}
+
+// ClusterStatus is a cluster that exports the service
+type ClusterStatus struct {
+	Cluster string `json:"cluster"`
+}
+
+// ServiceImportList is a list of ServiceImport objects
+type ServiceImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceImport `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *ServiceExport) DeepCopyInto(out *ServiceExport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Status.Conditions != nil {
+		out.Status.Conditions = make([]metav1.Condition, len(in.Status.Conditions))
+		for i := range in.Status.Conditions {
+			in.Status.Conditions[i].DeepCopyInto(&out.Status.Conditions[i])
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of the ServiceExport
+func (in *ServiceExport) DeepCopy() *ServiceExport {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *ServiceExport) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *ServiceExportList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExportList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ServiceExport, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *ServiceImport) DeepCopyInto(out *ServiceImport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.Ports != nil {
+		out.Spec.Ports = make([]ServicePort, len(in.Spec.Ports))
+		for i, p := range in.Spec.Ports {
+			if p.AppProtocol != nil {
+				appProtocol := *p.AppProtocol
+				p.AppProtocol = &appProtocol
+			}
+			out.Spec.Ports[i] = p
+		}
+	}
+	out.Spec.IPs = append([]string(nil), in.Spec.IPs...)
+	out.Spec.SessionAffinityConfig = in.Spec.SessionAffinityConfig.DeepCopy()
+	out.Status.Clusters = append([]ClusterStatus(nil), in.Status.Clusters...)
+}
+
+// DeepCopy returns a deep copy of the ServiceImport
+func (in *ServiceImport) DeepCopy() *ServiceImport {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *ServiceImport) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *ServiceImportList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ServiceImport, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
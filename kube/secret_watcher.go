@@ -0,0 +1,138 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+type SecretEventHandler = func(eventType watch.EventType, old *v1.Secret, new *v1.Secret)
+
+type SecretWatcher struct {
+	ctx           context.Context
+	client        kubernetes.Interface
+	resyncPeriod  time.Duration
+	stopChannel   chan struct{}
+	store         cache.Store
+	controller    cache.Controller
+	eventHandler  SecretEventHandler
+	labelSelector string
+	name          string
+	namespace     string
+	runner        string         // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	health        *ClusterHealth // Health of the watched cluster, nil to not record it
+}
+
+func NewSecretWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler SecretEventHandler, labelSelector, namespace, runner string, health *ClusterHealth) *SecretWatcher {
+	return &SecretWatcher{
+		ctx:           context.Background(),
+		client:        client,
+		resyncPeriod:  resyncPeriod,
+		stopChannel:   make(chan struct{}),
+		eventHandler:  handler,
+		labelSelector: labelSelector,
+		name:          name,
+		namespace:     namespace,
+		runner:        runner,
+		health:        health,
+	}
+}
+
+func (sw *SecretWatcher) Init() {
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = sw.labelSelector
+			l, err := sw.client.CoreV1().Secrets(sw.namespace).List(sw.ctx, options)
+			if err != nil {
+				log.Logger.Error("secret list error", "watcher", sw.name, "err", err)
+				sw.health.RecordWatcherError(err)
+			} else {
+				sw.health.RecordSync()
+			}
+			return l, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = sw.labelSelector
+			w, err := sw.client.CoreV1().Secrets(sw.namespace).Watch(sw.ctx, options)
+			if err != nil {
+				log.Logger.Error("secret watch error", "watcher", sw.name, "err", err)
+				sw.health.RecordWatcherError(err)
+			}
+			return w, err
+		},
+	}
+	eventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			sw.handleEvent(watch.Added, nil, obj.(*v1.Secret))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			sw.handleEvent(watch.Modified, oldObj.(*v1.Secret), newObj.(*v1.Secret))
+		},
+		DeleteFunc: func(obj interface{}) {
+			sw.handleEvent(watch.Deleted, obj.(*v1.Secret), nil)
+		},
+	}
+	sw.store, sw.controller = cache.NewInformer(cache.ToListWatcherWithWatchListSemantics(listWatch, sw.client), &v1.Secret{}, sw.resyncPeriod, eventHandler)
+}
+
+func (sw *SecretWatcher) handleEvent(eventType watch.EventType, oldObj, newObj *v1.Secret) {
+	metrics.IncKubeWatcherEvents(sw.name, "secret", sw.runner, eventType)
+	metrics.SetKubeWatcherObjects(sw.name, "secret", sw.runner, float64(len(sw.store.List())))
+
+	if sw.eventHandler != nil {
+		sw.eventHandler(eventType, oldObj, newObj)
+	}
+}
+
+func (sw *SecretWatcher) Run() {
+	log.Logger.Info("starting secret watcher", "watcher", sw.name)
+	// Running controller will block until writing on the stop channel.
+	sw.controller.Run(sw.stopChannel)
+	log.Logger.Info("stopped secret watcher", "watcher", sw.name)
+}
+
+func (sw *SecretWatcher) Stop() {
+	log.Logger.Info("stopping secret watcher", "watcher", sw.name)
+	close(sw.stopChannel)
+}
+
+func (sw *SecretWatcher) HasSynced() bool {
+	return sw.controller.HasSynced()
+}
+
+func (sw *SecretWatcher) Get(name, namespace string) (*v1.Secret, error) {
+	key := namespace + "/" + name
+
+	obj, exists, err := sw.store.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("secret"), key)
+	}
+
+	return obj.(*v1.Secret), nil
+}
+
+func (sw *SecretWatcher) List() ([]*v1.Secret, error) {
+	var secrets []*v1.Secret
+	for _, obj := range sw.store.List() {
+		secret, ok := obj.(*v1.Secret)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object in store: %+v", obj)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
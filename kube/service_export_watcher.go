@@ -0,0 +1,161 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+type ServiceExportEventHandler = func(eventType watch.EventType, old *ServiceExport, new *ServiceExport)
+
+// ServiceExportWatcher watches ServiceExport objects through the dynamic
+// client and keeps them in its store as typed objects
+type ServiceExportWatcher struct {
+	ctx          context.Context
+	client       dynamic.Interface
+	resyncPeriod time.Duration
+	stopChannel  chan struct{}
+	store        cache.Store
+	controller   cache.Controller
+	eventHandler ServiceExportEventHandler
+	name         string
+	namespace    string
+	runner       string         // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	health       *ClusterHealth // Health of the watched cluster, nil to not record it
+}
+
+func NewServiceExportWatcher(name string, client dynamic.Interface, resyncPeriod time.Duration, handler ServiceExportEventHandler, namespace, runner string, health *ClusterHealth) *ServiceExportWatcher {
+	return &ServiceExportWatcher{
+		ctx:          context.Background(),
+		client:       client,
+		resyncPeriod: resyncPeriod,
+		stopChannel:  make(chan struct{}),
+		eventHandler: handler,
+		name:         name,
+		namespace:    namespace,
+		runner:       runner,
+		health:       health,
+	}
+}
+
+func (sew *ServiceExportWatcher) Init() {
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			l, err := sew.client.Resource(ServiceExportResource).Namespace(sew.namespace).List(sew.ctx, options)
+			if err != nil {
+				log.Logger.Error("service export list error", "watcher", sew.name, "err", err)
+				sew.health.RecordWatcherError(err)
+				return nil, err
+			}
+			sew.health.RecordSync()
+			list := &ServiceExportList{ListMeta: metav1.ListMeta{ResourceVersion: l.GetResourceVersion()}}
+			for i := range l.Items {
+				se, err := fromUnstructured[ServiceExport](&l.Items[i])
+				if err != nil {
+					return nil, err
+				}
+				list.Items = append(list.Items, *se)
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := sew.client.Resource(ServiceExportResource).Namespace(sew.namespace).Watch(sew.ctx, options)
+			if err != nil {
+				log.Logger.Error("service export watch error", "watcher", sew.name, "err", err)
+				sew.health.RecordWatcherError(err)
+				return nil, err
+			}
+			return watch.Filter(w, sew.convertEvent), nil
+		},
+	}
+	eventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			sew.handleEvent(watch.Added, nil, obj.(*ServiceExport))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			sew.handleEvent(watch.Modified, oldObj.(*ServiceExport), newObj.(*ServiceExport))
+		},
+		DeleteFunc: func(obj interface{}) {
+			sew.handleEvent(watch.Deleted, obj.(*ServiceExport), nil)
+		},
+	}
+	sew.store, sew.controller = cache.NewInformer(cache.ToListWatcherWithWatchListSemantics(listWatch, sew.client), &ServiceExport{}, sew.resyncPeriod, eventHandler)
+}
+
+// convertEvent converts the unstructured objects of watch events to typed
+// ServiceExport objects. Error events are passed through.
+func (sew *ServiceExportWatcher) convertEvent(event watch.Event) (watch.Event, bool) {
+	u, ok := event.Object.(*unstructured.Unstructured)
+	if !ok {
+		return event, true
+	}
+	se, err := fromUnstructured[ServiceExport](u)
+	if err != nil {
+		log.Logger.Error("dropping service export event", "watcher", sew.name, "err", err)
+		return event, false
+	}
+	event.Object = se
+	return event, true
+}
+
+func (sew *ServiceExportWatcher) handleEvent(eventType watch.EventType, oldObj, newObj *ServiceExport) {
+	metrics.IncKubeWatcherEvents(sew.name, "serviceexport", sew.runner, eventType)
+	metrics.SetKubeWatcherObjects(sew.name, "serviceexport", sew.runner, float64(len(sew.store.List())))
+
+	if sew.eventHandler != nil {
+		sew.eventHandler(eventType, oldObj, newObj)
+	}
+}
+
+func (sew *ServiceExportWatcher) Run() {
+	log.Logger.Info("starting service export watcher", "watcher", sew.name)
+	// Running controller will block until writing on the stop channel.
+	sew.controller.Run(sew.stopChannel)
+	log.Logger.Info("stopped service export watcher", "watcher", sew.name)
+}
+
+func (sew *ServiceExportWatcher) Stop() {
+	log.Logger.Info("stopping service export watcher", "watcher", sew.name)
+	close(sew.stopChannel)
+}
+
+func (sew *ServiceExportWatcher) HasSynced() bool {
+	return sew.controller.HasSynced()
+}
+
+func (sew *ServiceExportWatcher) Get(name, namespace string) (*ServiceExport, error) {
+	key := namespace + "/" + name
+
+	obj, exists, err := sew.store.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(ServiceExportResource.GroupResource(), key)
+	}
+
+	return obj.(*ServiceExport), nil
+}
+
+func (sew *ServiceExportWatcher) List() ([]*ServiceExport, error) {
+	var ses []*ServiceExport
+	for _, obj := range sew.store.List() {
+		se, ok := obj.(*ServiceExport)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object in store: %+v", obj)
+		}
+		ses = append(ses, se)
+	}
+	return ses, nil
+}
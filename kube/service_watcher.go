@@ -30,10 +30,11 @@ type ServiceWatcher struct {
 	labelSelector string
 	name          string
 	namespace     string
-	runner        string // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	runner        string         // Name of the parent runner of the watcher. Used for metrics to distinguish series.
+	health        *ClusterHealth // Health of the watched cluster, nil to not record it
 }
 
-func NewServiceWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler ServiceEventHandler, labelSelector, namespace, runner string) *ServiceWatcher {
+func NewServiceWatcher(name string, client kubernetes.Interface, resyncPeriod time.Duration, handler ServiceEventHandler, labelSelector, namespace, runner string, health *ClusterHealth) *ServiceWatcher {
 	return &ServiceWatcher{
 		ctx:           context.Background(),
 		client:        client,
@@ -44,6 +45,7 @@ func NewServiceWatcher(name string, client kubernetes.Interface, resyncPeriod ti
 		name:          name,
 		namespace:     namespace,
 		runner:        runner,
+		health:        health,
 	}
 }
 
@@ -54,6 +56,9 @@ func (sw *ServiceWatcher) Init() {
 			l, err := sw.client.CoreV1().Services(sw.namespace).List(sw.ctx, options)
 			if err != nil {
 				log.Logger.Error("service list error", "watcher", sw.name, "err", err)
+				sw.health.RecordWatcherError(err)
+			} else {
+				sw.health.RecordSync()
 			}
 			return l, err
 		},
@@ -62,6 +67,7 @@ func (sw *ServiceWatcher) Init() {
 			w, err := sw.client.CoreV1().Services(sw.namespace).Watch(sw.ctx, options)
 			if err != nil {
 				log.Logger.Error("service watch error", "watcher", sw.name, "err", err)
+				sw.health.RecordWatcherError(err)
 			}
 			return w, err
 		},
@@ -77,7 +83,7 @@ func (sw *ServiceWatcher) Init() {
 			sw.handleEvent(watch.Deleted, obj.(*v1.Service), nil)
 		},
 	}
-	sw.store, sw.controller = cache.NewInformer(listWatch, &v1.Service{}, sw.resyncPeriod, eventHandler)
+	sw.store, sw.controller = cache.NewInformer(cache.ToListWatcherWithWatchListSemantics(listWatch, sw.client), &v1.Service{}, sw.resyncPeriod, eventHandler)
 }
 
 func (sw *ServiceWatcher) handleEvent(eventType watch.EventType, oldObj, newObj *v1.Service) {
@@ -0,0 +1,71 @@
+package kube
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	mirroredServiceKind = StatusGroupVersion.WithKind("MirroredService")
+	globalServiceKind   = StatusGroupVersion.WithKind("GlobalService")
+)
+
+// GetMirroredService returns a MirroredService under a namespace
+func GetMirroredService(ctx context.Context, client dynamic.Interface, name, namespace string) (*MirroredService, error) {
+	return getObject[MirroredService](ctx, client, MirroredServiceResource, name, namespace)
+}
+
+// ListMirroredServices returns the MirroredServices under a namespace that
+// match the label selector
+func ListMirroredServices(ctx context.Context, client dynamic.Interface, namespace, labelSelector string) ([]MirroredService, error) {
+	ul, err := client.Resource(MirroredServiceResource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]MirroredService, 0, len(ul.Items))
+	for i := range ul.Items {
+		ms, err := fromUnstructured[MirroredService](&ul.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *ms)
+	}
+	return items, nil
+}
+
+// CreateMirroredService creates a MirroredService
+func CreateMirroredService(ctx context.Context, client dynamic.Interface, ms *MirroredService) (*MirroredService, error) {
+	return createObject(ctx, client, MirroredServiceResource, mirroredServiceKind, ms, ms.Namespace)
+}
+
+// UpdateMirroredService updates a MirroredService
+func UpdateMirroredService(ctx context.Context, client dynamic.Interface, ms *MirroredService) (*MirroredService, error) {
+	return updateObject(ctx, client, MirroredServiceResource, mirroredServiceKind, ms, ms.Namespace)
+}
+
+// DeleteMirroredService deletes a MirroredService under a namespace
+func DeleteMirroredService(ctx context.Context, client dynamic.Interface, name, namespace string) error {
+	return deleteObject(ctx, client, MirroredServiceResource, name, namespace)
+}
+
+// GetGlobalService returns a GlobalService under a namespace
+func GetGlobalService(ctx context.Context, client dynamic.Interface, name, namespace string) (*GlobalService, error) {
+	return getObject[GlobalService](ctx, client, GlobalServiceResource, name, namespace)
+}
+
+// CreateGlobalService creates a GlobalService
+func CreateGlobalService(ctx context.Context, client dynamic.Interface, gs *GlobalService) (*GlobalService, error) {
+	return createObject(ctx, client, GlobalServiceResource, globalServiceKind, gs, gs.Namespace)
+}
+
+// UpdateGlobalService updates a GlobalService
+func UpdateGlobalService(ctx context.Context, client dynamic.Interface, gs *GlobalService) (*GlobalService, error) {
+	return updateObject(ctx, client, GlobalServiceResource, globalServiceKind, gs, gs.Namespace)
+}
+
+// DeleteGlobalService deletes a GlobalService under a namespace
+func DeleteGlobalService(ctx context.Context, client dynamic.Interface, name, namespace string) error {
+	return deleteObject(ctx, client, GlobalServiceResource, name, namespace)
+}
@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestStatusResources(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, nil, AddStatusToScheme(runtime.NewScheme()))
+	// Objects are created unstructured, the fake client can only list them
+	// without the typed kinds in its scheme
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		MirroredServiceResource: "MirroredServiceList",
+		GlobalServiceResource:   "GlobalServiceList",
+	})
+
+	ms := &MirroredService{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror", Namespace: "local-ns", Labels: map[string]string{"cluster": "a"}},
+		Status: MirroredServiceStatus{
+			Service: "remote-ns/test-svc",
+			ClusterServiceState: ClusterServiceState{
+				Cluster:   "a",
+				Ports:     []ServicePort{{Name: "http", Port: 80}},
+				Endpoints: 2,
+			},
+		},
+	}
+	created, err := CreateMirroredService(ctx, client, ms)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ms.Status, created.Status)
+	assert.Equal(t, "MirroredService", created.Kind)
+
+	created.Status.ReadyEndpoints = 2
+	_, err = UpdateMirroredService(ctx, client, created)
+	assert.Equal(t, nil, err)
+	got, err := GetMirroredService(ctx, client, "mirror", "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, got.Status.ReadyEndpoints)
+
+	list, err := ListMirroredServices(ctx, client, "local-ns", "cluster=a")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(list))
+	list, err = ListMirroredServices(ctx, client, "local-ns", "cluster=b")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(list))
+
+	assert.Equal(t, nil, DeleteMirroredService(ctx, client, "mirror", "local-ns"))
+	_, err = GetMirroredService(ctx, client, "mirror", "local-ns")
+	assert.NotEqual(t, nil, err)
+
+	gs := &GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "global", Namespace: "local-ns"},
+		Status: GlobalServiceStatus{
+			Service:   "remote-ns/test-svc",
+			Clusters:  []ClusterServiceState{{Cluster: "a"}, {Cluster: "b"}},
+			Conflicts: []string{"conflict"},
+		},
+	}
+	_, err = CreateGlobalService(ctx, client, gs)
+	assert.Equal(t, nil, err)
+	gotGS, err := GetGlobalService(ctx, client, "global", "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, gs.Status, gotGS.Status)
+
+	gotGS.Status.Clusters = nil
+	_, err = UpdateGlobalService(ctx, client, gotGS)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, DeleteGlobalService(ctx, client, "global", "local-ns"))
+	_, err = GetGlobalService(ctx, client, "global", "local-ns")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,198 @@
+package kube
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Types of the status resources maintained by the operator, which report the
+// state of mirrored and global services. The CRDs are shipped with the deploy
+// manifests.
+
+// StatusGroupVersion is the group version of the status resources
+var StatusGroupVersion = schema.GroupVersion{Group: "mirror.semaphore.uw.io", Version: "v1alpha1"}
+
+var (
+	// MirroredServiceResource is the resource of MirroredService objects
+	MirroredServiceResource = StatusGroupVersion.WithResource("mirroredservices")
+	// GlobalServiceResource is the resource of GlobalService objects
+	GlobalServiceResource = StatusGroupVersion.WithResource("globalservices")
+
+	statusSchemeBuilder = runtime.NewSchemeBuilder(addStatusTypes)
+	// AddStatusToScheme registers the status resource types
+	AddStatusToScheme = statusSchemeBuilder.AddToScheme
+)
+
+func addStatusTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(
+		StatusGroupVersion,
+		&MirroredService{},
+		&MirroredServiceList{},
+		&GlobalService{},
+		&GlobalServiceList{},
+	)
+	metav1.AddToGroupVersion(scheme, StatusGroupVersion)
+	return nil
+}
+
+// ClusterServiceState is the state of a service in a single source cluster
+type ClusterServiceState struct {
+	Cluster           string        `json:"cluster"`
+	Ports             []ServicePort `json:"ports,omitempty"` // Ports of the service as seen in the cluster
+	Endpoints         int           `json:"endpoints"`
+	ReadyEndpoints    int           `json:"readyEndpoints"`
+	Ready             bool          `json:"ready"` // The last reconcile succeeded and there are ready endpoints
+	LastReconcileTime *metav1.Time  `json:"lastReconcileTime,omitempty"`
+	LastError         string        `json:"lastError,omitempty"`
+}
+
+// MirroredService reports the state of a mirrored service. It has the name of
+// the mirrored service.
+type MirroredService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            MirroredServiceStatus `json:"status,omitempty"`
+}
+
+// MirroredServiceStatus is the state of the remote service of a mirrored
+// service
+type MirroredServiceStatus struct {
+	Service             string `json:"service"` // Namespace and name of the remote service
+	ClusterServiceState `json:",inline"`
+}
+
+// MirroredServiceList is a list of MirroredService objects
+type MirroredServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MirroredService `json:"items"`
+}
+
+// GlobalService reports the state of a global service across the clusters it
+// is mirrored from. It has the name of the global service.
+type GlobalService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            GlobalServiceStatus `json:"status,omitempty"`
+}
+
+// GlobalServiceStatus is the state of a global service in each of its clusters
+type GlobalServiceStatus struct {
+	Service           string                `json:"service"` // Namespace and name of the services in the clusters
+	Clusters          []ClusterServiceState `json:"clusters"`
+	Conflicts         []string              `json:"conflicts,omitempty"` // Mismatches between clusters that the merge policy cannot resolve
+	Ready             bool                  `json:"ready"`               // Any of the clusters is ready
+	LastReconcileTime *metav1.Time          `json:"lastReconcileTime,omitempty"`
+	LastError         string                `json:"lastError,omitempty"` // Last errors of all clusters
+}
+
+// GlobalServiceList is a list of GlobalService objects
+type GlobalServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GlobalService `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *ClusterServiceState) DeepCopyInto(out *ClusterServiceState) {
+	*out = *in
+	if in.Ports != nil {
+		out.Ports = make([]ServicePort, len(in.Ports))
+		for i, p := range in.Ports {
+			if p.AppProtocol != nil {
+				appProtocol := *p.AppProtocol
+				p.AppProtocol = &appProtocol
+			}
+			out.Ports[i] = p
+		}
+	}
+	out.LastReconcileTime = in.LastReconcileTime.DeepCopy()
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *MirroredService) DeepCopyInto(out *MirroredService) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.ClusterServiceState.DeepCopyInto(&out.Status.ClusterServiceState)
+}
+
+// DeepCopy returns a deep copy of the MirroredService
+func (in *MirroredService) DeepCopy() *MirroredService {
+	if in == nil {
+		return nil
+	}
+	out := new(MirroredService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *MirroredService) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *MirroredServiceList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(MirroredServiceList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]MirroredService, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *GlobalService) DeepCopyInto(out *GlobalService) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Status.Clusters != nil {
+		out.Status.Clusters = make([]ClusterServiceState, len(in.Status.Clusters))
+		for i := range in.Status.Clusters {
+			in.Status.Clusters[i].DeepCopyInto(&out.Status.Clusters[i])
+		}
+	}
+	out.Status.Conflicts = append([]string(nil), in.Status.Conflicts...)
+	out.Status.LastReconcileTime = in.Status.LastReconcileTime.DeepCopy()
+}
+
+// DeepCopy returns a deep copy of the GlobalService
+func (in *GlobalService) DeepCopy() *GlobalService {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *GlobalService) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *GlobalServiceList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalServiceList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]GlobalService, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
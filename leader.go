@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+// leaderElector runs lease based leader election between replicas and signals
+// when the replica becomes the leader. When leader election is disabled the
+// replica is always considered the leader.
+type leaderElector struct {
+	ctx      context.Context
+	elected  chan struct{}
+	isLeader atomic.Bool
+	healthz  *leaderelection.HealthzAdaptor
+	elector  *leaderelection.LeaderElector
+}
+
+func newLeaderElector(client kubernetes.Interface, conf leaderElectionConfig) (*leaderElector, error) {
+	l := &leaderElector{
+		elected: make(chan struct{}),
+	}
+	if !conf.Enabled {
+		l.becomeLeader()
+		return l, nil
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      conf.LeaseName,
+			Namespace: conf.LeaseNamespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: conf.Identity,
+		},
+	}
+	// Fail health checks if the leader cannot renew the lease for longer
+	// than the lease duration, so that it gets restarted and another
+	// replica can take over.
+	l.healthz = leaderelection.NewLeaderHealthzAdaptor(conf.LeaseDuration.Duration)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   conf.LeaseDuration.Duration,
+		RenewDeadline:   conf.RenewDeadline.Duration,
+		RetryPeriod:     conf.RetryPeriod.Duration,
+		ReleaseOnCancel: true,
+		WatchDog:        l.healthz,
+		Name:            conf.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				log.Logger.Info("started leading", "identity", conf.Identity)
+				l.becomeLeader()
+			},
+			OnStoppedLeading: func() {
+				metrics.SetLeader(false)
+				// Leadership is released on shutdown
+				if l.ctx.Err() != nil {
+					log.Logger.Info("released leadership", "identity", conf.Identity)
+					return
+				}
+				// Runners cannot go back to standby, exit and let
+				// the replica restart as a follower.
+				log.Logger.Error("lost leadership, exiting", "identity", conf.Identity)
+				os.Exit(1)
+			},
+			OnNewLeader: func(identity string) {
+				log.Logger.Info("new leader elected", "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating leader elector: %v", err)
+	}
+	l.healthz.SetLeaderElection(elector)
+	l.elector = elector
+	metrics.SetLeader(false)
+	return l, nil
+}
+
+// Run runs the leader election loop until the context is cancelled. The lease
+// is released on cancellation.
+func (l *leaderElector) Run(ctx context.Context) {
+	if l.elector == nil {
+		return
+	}
+	l.ctx = ctx
+	l.elector.Run(ctx)
+}
+
+// Elected returns a channel that is closed when the replica becomes the leader
+func (l *leaderElector) Elected() <-chan struct{} {
+	return l.elected
+}
+
+// IsLeader returns true if the replica is the leader
+func (l *leaderElector) IsLeader() bool {
+	return l.isLeader.Load()
+}
+
+// Check returns an error if the replica is the leader but failed to renew the
+// lease in time
+func (l *leaderElector) Check(req *http.Request) error {
+	if l.healthz == nil {
+		return nil
+	}
+	return l.healthz.Check(req)
+}
+
+func (l *leaderElector) becomeLeader() {
+	l.isLeader.Store(true)
+	metrics.SetLeader(true)
+	close(l.elected)
+}
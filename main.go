@@ -1,32 +1,39 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
-	"regexp"
-	"strings"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/utilitywarehouse/semaphore-service-mirror/backoff"
 	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
 	"github.com/utilitywarehouse/semaphore-service-mirror/log"
-	_ "github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 var (
+	flagConfigReloadInterval          = flag.String("config-reload-interval", getEnv("SSM_CONFIG_RELOAD_INTERVAL", "10s"), "How often to check the config file for changes in remote clusters. Set to 0 to disable reloading")
 	flagGlobalSvcLabelSelector        = flag.String("global-svc-label-selector", getEnv("SSM_GLOBAL_SVC_LABEL_SELECTOR", ""), "Label to mark watched services as global services")
 	flagGlobalSvcRoutingStrategyLabel = flag.String("global-svc-routing-strategy-label", getEnv("SSM_GLOBAL_SVC_TOPOLOGY_LABEL", ""), "Label to instruct whether to try topology aware routing for global services")
+	flagDryRun                        = flag.String("dry-run", getEnv("SSM_DRY_RUN", "false"), "Log the changes that would be made to the local cluster instead of making them")
 	flagKubeConfigPath                = flag.String("kube-config", getEnv("SSM_KUBE_CONFIG", ""), "Path of a kube config file, if not provided the app will try to get in cluster config")
 	flagLogLevel                      = flag.String("log-level", getEnv("SSM_LOG_LEVEL", "info"), "Log level")
 	flagMirrorNamespace               = flag.String("mirror-ns", getEnv("SSM_MIRROR_NS", ""), "The namespace to create dummy mirror services in")
 	flagMirrorSvcLabelSelector        = flag.String("mirror-svc-label-selector", getEnv("SSM_MIRROR_SVC_LABEL_SELECTOR", ""), "Label of services and endpoints to watch and mirror")
+	flagShutdownTimeout               = flag.String("shutdown-timeout", getEnv("SSM_SHUTDOWN_TIMEOUT", "20s"), "How long to wait for in-flight reconciles to finish on shutdown before cancelling them")
 	flagSSMConfig                     = flag.String("config", getEnv("SSM_CONFIG", ""), "(required)Path to the json config file")
-
-	bearerRe = regexp.MustCompile(`[A-Z|a-z0-9\-\._~\+\/]+=*`)
 )
 
 func usage() {
@@ -51,20 +58,14 @@ func main() {
 	if *flagSSMConfig == "" {
 		usage()
 	}
-	fileContent, err := os.ReadFile(*flagSSMConfig)
+	configReloadInterval, err := time.ParseDuration(*flagConfigReloadInterval)
 	if err != nil {
-		log.Logger.Error("Cannot read config file", "err", err)
-		os.Exit(1)
+		log.Logger.Error("Cannot parse config reload interval", "err", err)
+		usage()
 	}
-	config, err := parseConfig(
-		fileContent,
-		*flagGlobalSvcLabelSelector,
-		*flagGlobalSvcRoutingStrategyLabel,
-		*flagMirrorSvcLabelSelector,
-		*flagMirrorNamespace,
-	)
+	fileContent, config, err := readConfig(*flagSSMConfig)
 	if err != nil {
-		log.Logger.Error("Cannot parse config", "err", err)
+		log.Logger.Error("Cannot load config", "err", err)
 		os.Exit(1)
 	}
 	// set DefaultLocalEndpointZones value for topology aware routing
@@ -79,8 +80,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	dryRun, err := strconv.ParseBool(*flagDryRun)
+	if err != nil {
+		log.Logger.Error("Cannot parse dry run flag", "err", err)
+		usage()
+	}
+	if dryRun {
+		log.Logger.Warn("running in dry run mode, changes to the local cluster will only be logged")
+		// Taking the lease would stop the replicas that do the actual
+		// work from leading
+		config.Global.LeaderElection.Enabled = false
+	}
+
 	// Get a kube client for the local cluster
-	homeClient, err := kube.ClientFromConfig(*flagKubeConfigPath)
+	homeClient, err := kube.ClientFromConfig(*flagKubeConfigPath, config.LocalCluster.Name, dryRun)
 	if err != nil {
 		log.Logger.Error(
 			"cannot create kube client for local cluster",
@@ -88,99 +101,358 @@ func main() {
 		)
 		usage()
 	}
+	stopEvents := kube.InitEventRecorder(homeClient, "semaphore-service-mirror")
 
-	gst := newGlobalServiceStore()
-	gr := makeGlobalRunner(homeClient, homeClient, config.LocalCluster.Name, config.Global, gst, true, routingStrategyLabel)
-	go func() { backoff.Retry(gr.Run, "start runner") }()
-	runners := []Runner{gr}
-	for _, remote := range config.RemoteClusters {
-		remoteClient, err := makeRemoteKubeClientFromConfig(remote)
-		if err != nil {
-			log.Logger.Error("cannot create kube client for remotecluster", "err", err)
-			os.Exit(1)
+	shutdownTimeout, err := time.ParseDuration(*flagShutdownTimeout)
+	if err != nil {
+		log.Logger.Error("Cannot parse shutdown timeout", "err", err)
+		usage()
+	}
+
+	// signalCtx is cancelled on SIGTERM or SIGINT and stops accepting new
+	// work, runnersCtx is cancelled when the shutdown deadline passes and
+	// cancels all outstanding calls to the Kubernetes APIs.
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignals()
+	runnersCtx, cancelRunners := context.WithCancel(context.Background())
+	defer cancelRunners()
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	defer cancelLeader()
+
+	le, err := newLeaderElector(homeClient, config.Global.LeaderElection)
+	if err != nil {
+		log.Logger.Error("cannot create leader elector", "err", err)
+		os.Exit(1)
+	}
+	leaderDone := make(chan struct{})
+	go func() {
+		le.Run(leaderCtx)
+		close(leaderDone)
+	}()
+
+	gst := newGlobalServiceStore(config.Global.GlobalSvcMergePolicy, config.Global.GlobalSvcHeadlessPolicy, config.LocalCluster.Name)
+	rm, err := newRunnerManager(homeClient, homeClient.Dynamic, config.LocalCluster.Name, config.Global, gst, routingStrategyLabel, le.Elected())
+	if err != nil {
+		log.Logger.Error("cannot create runner manager", "err", err)
+		os.Exit(1)
+	}
+	rm.Run(runnersCtx)
+	if err := rm.ApplyStaticRemoteClusters(config.RemoteClusters); err != nil {
+		log.Logger.Error("cannot start remote cluster runners", "err", err)
+		os.Exit(1)
+	}
+	var discovery *remoteClusterDiscovery
+	if config.Global.RemoteClusterDiscovery.Enabled {
+		discovery = newRemoteClusterDiscovery(homeClient, config.Global.RemoteClusterDiscovery, rm.ApplyDiscoveredRemoteClusters)
+		discovery.Run()
+	}
+	configWatcherDone := make(chan struct{})
+	go func() {
+		if configReloadInterval > 0 {
+			watchConfig(signalCtx, *flagSSMConfig, configReloadInterval, fileContent, config, rm)
+		}
+		close(configWatcherDone)
+	}()
+
+	server := newHTTPServer(rm, le, config.Global.Health)
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
 		}
-		mr := makeMirrorRunner(homeClient, remoteClient, remote, config.Global)
-		runners = append(runners, mr)
-		go func() { backoff.Retry(mr.Run, "start mirror runner") }()
-		gr := makeGlobalRunner(homeClient, remoteClient, remote.Name, config.Global, gst, false, routingStrategyLabel)
-		runners = append(runners, gr)
-		go func() { backoff.Retry(gr.Run, "start mirror runner") }()
+	}()
+
+	exitCode := 0
+	select {
+	case <-signalCtx.Done():
+		log.Logger.Info("Received termination signal, shutting down", "timeout", shutdownTimeout)
+	case err := <-serverErr:
+		log.Logger.Error("Listen and Serve", "err", err)
+		stopSignals()
+		exitCode = 1
+	}
+	<-configWatcherDone
+	if discovery != nil {
+		discovery.Stop()
+	}
+
+	// Stop runners, giving in-flight reconciles until the deadline to finish
+	// before cancelling them
+	stopped := make(chan struct{})
+	go func() {
+		rm.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		log.Logger.Info("All runners stopped")
+	case <-time.After(shutdownTimeout):
+		log.Logger.Warn("Shutdown deadline exceeded, cancelling in-flight reconciles")
+		cancelRunners()
+		<-stopped
 	}
+	// Release the lease only after runners stopped, so that the next leader
+	// does not overlap with in-flight reconciles
+	cancelLeader()
+	<-leaderDone
 
-	listenAndServe(runners)
-	// Stop runners before finishing
-	for _, r := range runners {
-		r.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Logger.Error("Shutting down http server", "err", err)
+	}
+	stopEvents()
+	log.Logger.Info("Shutdown complete")
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// readConfig reads and parses the config file under path, applying overrides
+// from flags
+func readConfig(path string) ([]byte, *Config, error) {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading config file: %v", err)
+	}
+	config, err := parseConfig(
+		fileContent,
+		*flagGlobalSvcLabelSelector,
+		*flagGlobalSvcRoutingStrategyLabel,
+		*flagMirrorSvcLabelSelector,
+		*flagMirrorNamespace,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing config: %v", err)
 	}
+	return fileContent, config, nil
 }
 
-func listenAndServe(runners []Runner) {
+// watchConfig polls the config file for changes and applies the new remote
+// clusters configuration to the runner manager. Changes in the global and local
+// cluster configuration need a restart to take effect.
+func watchConfig(ctx context.Context, path string, interval time.Duration, lastContent []byte, config *Config, rm *runnerManager) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fileContent, err := os.ReadFile(path)
+		if err != nil {
+			log.Logger.Error("Cannot read config file", "err", err)
+			metrics.IncConfigReloads("error")
+			continue
+		}
+		if bytes.Equal(fileContent, lastContent) {
+			continue
+		}
+		log.Logger.Info("Config file changed, reloading")
+		newConfig, err := parseConfig(
+			fileContent,
+			*flagGlobalSvcLabelSelector,
+			*flagGlobalSvcRoutingStrategyLabel,
+			*flagMirrorSvcLabelSelector,
+			*flagMirrorNamespace,
+		)
+		if err != nil {
+			log.Logger.Error("Cannot parse config, keeping the running configuration", "err", err)
+			metrics.IncConfigReloads("error")
+			lastContent = fileContent
+			continue
+		}
+		if !reflect.DeepEqual(newConfig.Global, config.Global) || !reflect.DeepEqual(newConfig.LocalCluster, config.LocalCluster) {
+			log.Logger.Warn("Changes in global and local cluster configuration require a restart, ignoring them")
+		}
+		// The content is not marked as applied on errors, so that
+		// clusters that failed to start are retried on the next tick
+		if err := rm.ApplyStaticRemoteClusters(newConfig.RemoteClusters); err != nil {
+			log.Logger.Error("Cannot apply remote clusters configuration", "err", err)
+			metrics.IncConfigReloads("error")
+			continue
+		}
+		lastContent = fileContent
+		metrics.IncConfigReloads("success")
+	}
+}
+
+// newHTTPServer returns an http server for health checks and metrics
+func newHTTPServer(rm *runnerManager, le *leaderElector, health healthConfig) *http.Server {
 	sm := http.NewServeMux()
-	sm.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+	sm.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
 		// A meaningful health check would be to verify that all runners
 		// have started or kick the app otherwise via a liveness probe.
 		// Client errors should be monitored via metrics.
-		for _, r := range runners {
+		for _, r := range rm.Runners() {
 			if !r.Initialised() {
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
 		}
+		// Fail if the leader cannot renew its lease, to allow another
+		// replica to take over
+		if err := le.Check(req); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "leader: %t, err: %v\n", le.IsLeader(), err)
+			return
+		}
+		if !live(clusterStatuses(health.ErrorThreshold), health.Policy) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "leader: %t, unhealthy clusters\n", le.IsLeader())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "leader: %t\n", le.IsLeader())
+	})
+	sm.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		for _, r := range rm.Runners() {
+			if !r.Initialised() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if !ready(clusterStatuses(health.ErrorThreshold)) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "clusters not synced or unhealthy")
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 	})
+	sm.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		statuses := clusterStatuses(health.ErrorThreshold)
+		status := healthStatus{
+			Leader:   le.IsLeader(),
+			Policy:   health.Policy,
+			Ready:    ready(statuses),
+			Clusters: statuses,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Logger.Error("encoding status response", "err", err)
+		}
+	})
+	sm.HandleFunc("/lookup", func(w http.ResponseWriter, req *http.Request) {
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "missing name parameter", http.StatusBadRequest)
+			return
+		}
+		lookup, ok := rm.Lookup(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("service %s not found", name), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(lookup); err != nil {
+			log.Logger.Error("encoding lookup response", "err", err)
+		}
+	})
 	sm.Handle("/metrics", promhttp.Handler())
-	log.Logger.Error(
-		"Listen and Serve",
-		"err", http.ListenAndServe(":8080", sm),
-	)
+	return &http.Server{
+		Addr:    ":8080",
+		Handler: sm,
+	}
 }
 
-func makeRemoteKubeClientFromConfig(remote *remoteClusterConfig) (*kubernetes.Clientset, error) {
-	if remote.KubeConfigPath != "" {
-		return kube.ClientFromConfig(remote.KubeConfigPath)
-	}
-	// If kubeconfig path is not set, try to use craft it from the rest of the config
-	data, err := os.ReadFile(remote.RemoteSATokenPath)
-	if err != nil {
-		return nil, fmt.Errorf("Cannot read file: %s: %v", remote.RemoteSATokenPath, err)
-	}
-	saToken := string(data)
-	if saToken != "" {
-		saToken = strings.TrimSpace(saToken)
-		if !bearerRe.MatchString(saToken) {
-			return nil, fmt.Errorf("The provided token does not match regex: %s", bearerRe.String())
+func makeRemoteKubeClientFromConfig(remote *remoteClusterConfig) (*kube.Clientset, error) {
+	if remote.AuthMethod != kube.AuthMethodToken {
+		if remote.KubeConfigPath != "" {
+			return kube.RemoteClientFromConfig(remote.KubeConfigPath, remote.AuthMethod, remote.Name)
 		}
+		// Clusters discovered from secrets carry their credentials
+		if len(remote.KubeConfigData) > 0 {
+			return kube.RemoteClientFromKubeConfig(remote.KubeConfigData, remote.AuthMethod, remote.Name)
+		}
+	}
+	if remote.RemoteSAToken != "" {
+		return kube.TokenClient(
+			remote.RemoteSAToken,
+			remote.RemoteAPIURL,
+			remote.RemoteCAURL,
+			remote.RemoteCAFile,
+			remote.RemoteCAData,
+			remote.CARefreshInterval.Duration,
+			remote.Name,
+		)
 	}
-	return kube.Client(saToken, remote.RemoteAPIURL, remote.RemoteCAURL)
+	// If kubeconfig path is not set, try to use craft it from the rest of the config
+	return kube.Client(
+		remote.RemoteSATokenPath,
+		remote.RemoteAPIURL,
+		remote.RemoteCAURL,
+		remote.RemoteCAFile,
+		remote.RemoteCAData,
+		remote.CARefreshInterval.Duration,
+		remote.Name,
+	)
 }
 
-func makeMirrorRunner(homeClient, remoteClient *kubernetes.Clientset, remote *remoteClusterConfig, global globalConfig) *MirrorRunner {
-	return newMirrorRunner(
-		homeClient,
-		remoteClient,
-		remote.Name,
-		global.MirrorNamespace,
-		remote.ServicePrefix,
-		global.MirrorSvcLabelSelector,
+func makeMirrorRunner(homeClient, remoteClient kubernetes.Interface, homeDynamicClient dynamic.Interface, remote *remoteClusterConfig, global globalConfig, propagation *propagationRules, elected <-chan struct{}) *MirrorRunner {
+	return newMirrorRunner(mirrorRunnerOptions{
+		client:          homeClient,
+		watchClient:     remoteClient,
+		name:            remote.Name,
+		namespace:       global.MirrorNamespace,
+		prefix:          remote.ServicePrefix,
+		labelSelector:   global.MirrorSvcLabelSelector,
+		namespaceFilter: newNamespaceFilter(remote.IncludeNamespaces, remote.ExcludeNamespaces),
+		staleEndpoints:  newStaleEndpointGuard(remote.StaleEndpointPolicy, remote.StaleEndpointTimeout.Duration, kube.HealthOf(remote.Name)),
+		propagation:     propagation,
+		status:          makeStatusReporter(homeDynamicClient, global, remote.Name),
 		// Resync will trigger an onUpdate event for everything that is
 		// stored in cache.
-		remote.ResyncPeriod.Duration,
-		global.ServiceSync,
-	)
+		resyncPeriod:        remote.ResyncPeriod.Duration,
+		sync:                global.ServiceSync,
+		endpointSlices:      remote.MirrorEndpointSlices,
+		loadBalancerIngress: remote.MirrorLoadBalancerIngress,
+		finalizer:           global.ServiceFinalizer.Enabled,
+		gcInterval:          global.GCInterval.Duration,
+		gcGracePeriod:       global.GCGracePeriod.Duration,
+		elected:             elected,
+	})
 }
 
-func makeGlobalRunner(homeClient, remoteClient *kubernetes.Clientset, name string, global globalConfig, gst *GlobalServiceStore, localCluster bool, routingStrategyLabel labels.Selector) *GlobalRunner {
-	return newGlobalRunner(
-		homeClient,
-		remoteClient,
-		name,
-		global.MirrorNamespace,
-		global.GlobalSvcLabelSelector,
+func makeGlobalRunner(homeClient, remoteClient kubernetes.Interface, homeDynamicClient, remoteDynamicClient dynamic.Interface, name string, nsFilter *namespaceFilter, staleEndpoints *staleEndpointGuard, propagation *propagationRules, global globalConfig, gst *GlobalServiceStore, localCluster bool, routingStrategyLabel labels.Selector, elected <-chan struct{}) *GlobalRunner {
+	labelSelector := global.GlobalSvcLabelSelector
+	mcsClient, mcsWatchClient := homeDynamicClient, remoteDynamicClient
+	if global.MultiClusterServices.Enabled {
+		// Global services are selected by ServiceExports instead
+		labelSelector = ""
+	} else {
+		mcsClient, mcsWatchClient = nil, nil
+	}
+	return newGlobalRunner(globalRunnerOptions{
+		client:          homeClient,
+		watchClient:     remoteClient,
+		mcsClient:       mcsClient,
+		mcsWatchClient:  mcsWatchClient,
+		name:            name,
+		namespace:       global.MirrorNamespace,
+		labelSelector:   labelSelector,
+		namespaceFilter: nsFilter,
+		staleEndpoints:  staleEndpoints,
+		propagation:     propagation,
+		status:          makeStatusReporter(homeDynamicClient, global, name),
 		// TODO: Need to specify resync period?
-		0,
-		gst,
-		localCluster,
-		routingStrategyLabel,
-		global.EndpointSliceSync,
-	)
+		resyncPeriod:         0,
+		globalServiceStore:   gst,
+		local:                localCluster,
+		routingStrategyLabel: routingStrategyLabel,
+		sync:                 global.EndpointSliceSync,
+		finalizer:            global.ServiceFinalizer.Enabled,
+		gcInterval:           global.GCInterval.Duration,
+		gcGracePeriod:        global.GCGracePeriod.Duration,
+		elected:              elected,
+	})
+}
+
+// makeStatusReporter returns the reporter of the state of services mirrored
+// from a cluster, or nil if the status resources are disabled
+func makeStatusReporter(homeDynamicClient dynamic.Interface, global globalConfig, cluster string) *statusReporter {
+	if !global.StatusResources.Enabled {
+		return nil
+	}
+	return newStatusReporter(homeDynamicClient, global.MirrorNamespace, cluster)
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+// Annotation that holds the name of the local global service on ServiceImports
+const serviceImportGlobalSvcAnno = "global-svc-name"
+
+// exported returns true if the remote service is exported. Without the
+// Multi-Cluster Services API all watched services are exported, since they are
+// selected by label.
+func (gr *GlobalRunner) exported(name, namespace string) bool {
+	if gr.serviceExportWatcher == nil {
+		return true
+	}
+	_, err := gr.serviceExportWatcher.Get(name, namespace)
+	return err == nil
+}
+
+// endpointSliceExported returns true if the service of the remote
+// endpointslice is exported
+func (gr *GlobalRunner) endpointSliceExported(es *discoveryv1.EndpointSlice) bool {
+	if gr.serviceExportWatcher == nil {
+		return true
+	}
+	name, ok := es.Labels["kubernetes.io/service-name"]
+	return ok && gr.exported(name, es.Namespace)
+}
+
+// ServiceExportEventHandler queues the service of added or deleted
+// ServiceExports and its endpointslices, to add them to or remove them from
+// the global service
+func (gr *GlobalRunner) ServiceExportEventHandler(eventType watch.EventType, old *kube.ServiceExport, new *kube.ServiceExport) {
+	if namespace := eventNamespace(eventType, old, new); !gr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping service export event from excluded namespace", "namespace", namespace, "runner", gr.name)
+		return
+	}
+	switch eventType {
+	case watch.Added:
+		log.Logger.Debug("service export added", "namespace", new.Namespace, "name", new.Name, "runner", gr.name)
+		gr.serviceQueue.Add(new)
+		gr.requeueServiceEndpointSlices(new.Name, new.Namespace)
+	case watch.Modified:
+		// Only the status of exports changes, which is not mirrored
+	case watch.Deleted:
+		log.Logger.Debug("service export deleted", "namespace", old.Namespace, "name", old.Name, "runner", gr.name)
+		gr.serviceQueue.Add(old)
+		gr.requeueServiceEndpointSlices(old.Name, old.Namespace)
+	default:
+		log.Logger.Info("Unknown service export event received: %v", eventType, "runner", gr.name)
+	}
+}
+
+// requeueServiceEndpointSlices queues the remote endpointslices of a service to
+// reconcile their mirrors
+func (gr *GlobalRunner) requeueServiceEndpointSlices(name, namespace string) {
+	endpointSlices, err := gr.endpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote endpointslices", "err", err, "runner", gr.name)
+		return
+	}
+	for _, es := range endpointSlices {
+		if es.Namespace == namespace && es.Labels["kubernetes.io/service-name"] == name {
+			gr.endpointSliceQueue.Add(es)
+		}
+	}
+}
+
+// generateServiceImport returns the ServiceImport for a global service. The
+// import has the name and namespace of the exported service and points at the
+// cluster IPs of the local global service.
+func generateServiceImport(gsvc *GlobalService, globalSvc *v1.Service) *kube.ServiceImport {
+	si := &kube.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        gsvc.name,
+			Namespace:   gsvc.namespace,
+			Labels:      mergeMetadata(globalSvcLabels),
+			Annotations: map[string]string{serviceImportGlobalSvcAnno: globalSvc.Name},
+		},
+		Spec: kube.ServiceImportSpec{
+			Ports:                 servicePorts(gsvc.spec.Ports),
+			Type:                  kube.ServiceImportClusterSetIP,
+			SessionAffinity:       gsvc.spec.SessionAffinity,
+			SessionAffinityConfig: gsvc.spec.SessionAffinityConfig.DeepCopy(),
+		},
+	}
+	if gsvc.spec.ClusterIP == v1.ClusterIPNone {
+		si.Spec.Type = kube.ServiceImportHeadless
+	} else if globalSvc.Spec.ClusterIP != "" && globalSvc.Spec.ClusterIP != v1.ClusterIPNone {
+		si.Spec.IPs = append([]string(nil), globalSvc.Spec.ClusterIPs...)
+		if len(si.Spec.IPs) == 0 {
+			si.Spec.IPs = []string{globalSvc.Spec.ClusterIP}
+		}
+	}
+	for _, c := range gsvc.clusters {
+		si.Status.Clusters = append(si.Status.Clusters, kube.ClusterStatus{Cluster: c})
+	}
+	return si
+}
+
+// servicePorts returns the ports of a service in the form of the Multi-Cluster
+// Services API
+func servicePorts(ports []v1.ServicePort) []kube.ServicePort {
+	res := []kube.ServicePort{}
+	for _, p := range ports {
+		res = append(res, kube.ServicePort{
+			Name:        p.Name,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+			Port:        p.Port,
+		})
+	}
+	return res
+}
+
+// ownsServiceImport returns true if the ServiceImport was created by the
+// operator, imports of other controllers are left alone
+func ownsServiceImport(si *kube.ServiceImport) bool {
+	for k, v := range globalSvcLabels {
+		if si.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileServiceImport creates or updates the ServiceImport of a global
+// service under the Multi-Cluster Services API
+func (gr *GlobalRunner) reconcileServiceImport(gsvc *GlobalService, globalSvc *v1.Service) error {
+	if gr.mcsClient == nil {
+		return nil
+	}
+	desired := generateServiceImport(gsvc, globalSvc)
+	si, err := kube.GetServiceImport(gr.ctx, gr.mcsClient, desired.Name, desired.Namespace)
+	if errors.IsNotFound(err) {
+		log.Logger.Info("service import not found, creating", "namespace", desired.Namespace, "name", desired.Name, "runner", gr.name)
+		if _, err := kube.CreateServiceImport(gr.ctx, gr.mcsClient, desired); err != nil {
+			return fmt.Errorf("creating service import %s/%s: %v", desired.Namespace, desired.Name, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting service import %s/%s: %v", desired.Namespace, desired.Name, err)
+	}
+	if !ownsServiceImport(si) {
+		log.Logger.Warn("service import is not managed by the operator, skipping", "namespace", si.Namespace, "name", si.Name, "runner", gr.name)
+		return nil
+	}
+	updated := si.DeepCopy()
+	updated.Labels = mergeMetadata(desired.Labels, si.Labels)
+	updated.Annotations = mergeMetadata(desired.Annotations, si.Annotations)
+	updated.Spec = desired.Spec
+	if !equality.Semantic.DeepEqual(si.ObjectMeta, updated.ObjectMeta) || !equality.Semantic.DeepEqual(si.Spec, updated.Spec) {
+		log.Logger.Info("updating service import", "namespace", si.Namespace, "name", si.Name, "runner", gr.name)
+		if si, err = kube.UpdateServiceImport(gr.ctx, gr.mcsClient, updated); err != nil {
+			return fmt.Errorf("updating service import %s/%s: %v", updated.Namespace, updated.Name, err)
+		}
+	}
+	if !equality.Semantic.DeepEqual(si.Status, desired.Status) {
+		updated = si.DeepCopy()
+		updated.Status = desired.Status
+		if _, err := kube.UpdateServiceImportStatus(gr.ctx, gr.mcsClient, updated); err != nil {
+			return fmt.Errorf("updating service import status %s/%s: %v", updated.Namespace, updated.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteServiceImport deletes the ServiceImport of a global service that no
+// cluster exports anymore
+func (gr *GlobalRunner) deleteServiceImport(name, namespace string) error {
+	if gr.mcsClient == nil {
+		return nil
+	}
+	si, err := kube.GetServiceImport(gr.ctx, gr.mcsClient, name, namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting service import %s/%s: %v", namespace, name, err)
+	}
+	if !ownsServiceImport(si) {
+		return nil
+	}
+	log.Logger.Info("deleting service import", "namespace", namespace, "name", name, "runner", gr.name)
+	if err := kube.DeleteServiceImport(gr.ctx, gr.mcsClient, name, namespace); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting service import %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func newFakeMCSClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	scheme := runtime.NewScheme()
+	kube.AddMCSToScheme(scheme)
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		scheme,
+		map[schema.GroupVersionResource]string{
+			kube.ServiceExportResource: "ServiceExportList",
+			kube.ServiceImportResource: "ServiceImportList",
+		},
+		objects...,
+	)
+}
+
+func TestGenerateServiceImport(t *testing.T) {
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	svc := createTestService("test-svc", "remote-ns", "1.1.1.1", []int32{80})
+	svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	store.AddOrUpdateClusterServiceTarget(svc, "a", false, nil, nil)
+	gsvc := store.AddOrUpdateClusterServiceTarget(svc, "b", false, nil, nil)
+
+	globalSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "gl-remote-ns-73736d-test-svc"},
+		Spec: v1.ServiceSpec{
+			ClusterIP:  "10.0.0.1",
+			ClusterIPs: []string{"10.0.0.1"},
+		},
+	}
+	si := generateServiceImport(gsvc, globalSvc)
+	assert.Equal(t, "test-svc", si.Name)
+	assert.Equal(t, "remote-ns", si.Namespace)
+	assert.Equal(t, "gl-remote-ns-73736d-test-svc", si.Annotations[serviceImportGlobalSvcAnno])
+	assert.Equal(t, kube.ServiceImportClusterSetIP, si.Spec.Type)
+	assert.Equal(t, []string{"10.0.0.1"}, si.Spec.IPs)
+	assert.Equal(t, []kube.ServicePort{{Port: 80}}, si.Spec.Ports)
+	assert.Equal(t, v1.ServiceAffinityClientIP, si.Spec.SessionAffinity)
+	assert.Equal(t, []kube.ClusterStatus{{Cluster: "a"}, {Cluster: "b"}}, si.Status.Clusters)
+
+	// Headless imports have no IPs
+	svc.Spec.ClusterIP = v1.ClusterIPNone
+	gsvc = store.AddOrUpdateClusterServiceTarget(svc, "a", false, nil, nil)
+	gsvc = store.AddOrUpdateClusterServiceTarget(svc, "b", false, nil, nil)
+	globalSvc.Spec = v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}
+	si = generateServiceImport(gsvc, globalSvc)
+	assert.Equal(t, kube.ServiceImportHeadless, si.Spec.Type)
+	assert.Empty(t, si.Spec.IPs)
+}
+
+func TestGlobalRunnerServiceExports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPort := int32(80)
+	exportedSvc := createTestService("exported-svc", "remote-ns", "1.1.1.1", []int32{80})
+	otherSvc := createTestService("other-svc", "remote-ns", "1.1.1.2", []int32{80})
+	exportedSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "exported-svc-abcde",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"kubernetes.io/service-name": "exported-svc"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		Ports:       []discoveryv1.EndpointPort{{Port: &testPort}},
+	}
+	otherSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-svc-abcde",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"kubernetes.io/service-name": "other-svc"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	serviceExport := &kube.ServiceExport{
+		TypeMeta:   metav1.TypeMeta{APIVersion: kube.MCSGroupVersion.String(), Kind: "ServiceExport"},
+		ObjectMeta: metav1.ObjectMeta{Name: "exported-svc", Namespace: "remote-ns"},
+	}
+	fakeClient := fake.NewSimpleClientset()
+	fakeWatchClient := fake.NewSimpleClientset(exportedSvc, otherSvc, exportedSlice, otherSlice)
+	fakeMCSClient := newFakeMCSClient()
+	fakeMCSWatchClient := newFakeMCSClient(serviceExport)
+
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		mcsClient:            fakeMCSClient,
+		mcsWatchClient:       fakeMCSWatchClient,
+		name:                 "test-runner",
+		namespace:            "local-ns",
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, ""),
+		routingStrategyLabel: selector,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.endpointSliceWatcher.Run()
+	go testRunner.serviceExportWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("serviceExportWatcher", ctx.Done(), testRunner.serviceExportWatcher.HasSynced)
+
+	// Only the exported service gets a global service and a service import
+	for _, name := range []string{"exported-svc", "other-svc"} {
+		if err := testRunner.reconcileGlobalService(name, "remote-ns"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svcs, err := fakeClient.CoreV1().Services("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(svcs.Items))
+	assert.Equal(t, generateGlobalServiceName("exported-svc", "remote-ns"), svcs.Items[0].Name)
+	si, err := kube.GetServiceImport(ctx, fakeMCSClient, "exported-svc", "remote-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, kube.ServiceImportClusterSetIP, si.Spec.Type)
+	assert.Equal(t, []kube.ClusterStatus{{Cluster: "test-runner"}}, si.Status.Clusters)
+	_, err = kube.GetServiceImport(ctx, fakeMCSClient, "other-svc", "remote-ns")
+	assert.True(t, errors.IsNotFound(err))
+
+	// Only the endpointslices of the exported service are mirrored
+	for _, name := range []string{"exported-svc-abcde", "other-svc-abcde"} {
+		if err := testRunner.reconcileEndpointSlice(name, "remote-ns"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	endpointSlices, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(endpointSlices.Items))
+	assert.Equal(t, generateGlobalEndpointSliceName("test-runner", "remote-ns", "exported-svc-abcde"), endpointSlices.Items[0].Name)
+
+	// Deleting the export removes the global service, its import and
+	// endpointslices
+	if err := fakeMCSWatchClient.Resource(kube.ServiceExportResource).Namespace("remote-ns").Delete(ctx, "exported-svc", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		return !testRunner.exported("exported-svc", "remote-ns")
+	}, time.Second, 10*time.Millisecond)
+	if err := testRunner.reconcileGlobalService("exported-svc", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testRunner.reconcileEndpointSlice("exported-svc-abcde", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	svcs, err = fakeClient.CoreV1().Services("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(svcs.Items))
+	_, err = kube.GetServiceImport(ctx, fakeMCSClient, "exported-svc", "remote-ns")
+	assert.True(t, errors.IsNotFound(err))
+	endpointSlices, err = fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(endpointSlices.Items))
+}
+
+func TestGlobalRunnerCleanupSkipsUnexportedServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	exportedSvc := createTestService("exported-svc", "remote-ns", "1.1.1.1", []int32{80})
+	otherSvc := createTestService("other-svc", "remote-ns", "1.1.1.2", []int32{80})
+	// A global service of the unexported name that is exported by another
+	// cluster, whose runner has not added it to the store yet
+	otherGlobalSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generateGlobalServiceName("other-svc", "remote-ns"),
+			Namespace:   "local-ns",
+			Labels:      globalSvcLabels,
+			Annotations: map[string]string{globalSvcClustersAnno: "runnerB"},
+		},
+	}
+	serviceExport := &kube.ServiceExport{
+		TypeMeta:   metav1.TypeMeta{APIVersion: kube.MCSGroupVersion.String(), Kind: "ServiceExport"},
+		ObjectMeta: metav1.ObjectMeta{Name: "exported-svc", Namespace: "remote-ns"},
+	}
+	fakeClient := fake.NewSimpleClientset(otherGlobalSvc)
+	fakeWatchClient := fake.NewSimpleClientset(exportedSvc, otherSvc)
+	fakeMCSClient := newFakeMCSClient()
+	fakeMCSWatchClient := newFakeMCSClient(serviceExport)
+
+	selector, _ := labels.Parse(testGlobalRoutingStrategyLabel)
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:               fakeClient,
+		watchClient:          fakeWatchClient,
+		mcsClient:            fakeMCSClient,
+		mcsWatchClient:       fakeMCSWatchClient,
+		name:                 "runnerA",
+		namespace:            "local-ns",
+		resyncPeriod:         60 * time.Minute,
+		globalServiceStore:   newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, ""),
+		routingStrategyLabel: selector,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.serviceExportWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("serviceExportWatcher", ctx.Done(), testRunner.serviceExportWatcher.HasSynced)
+
+	if err := testRunner.reconcileGlobalService("exported-svc", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cleanup removes the global service of the exported service and leaves
+	// the one of the unexported service alone
+	if err := testRunner.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	svcs, err := fakeClient.CoreV1().Services("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(svcs.Items))
+	assert.Equal(t, otherGlobalSvc.Name, svcs.Items[0].Name)
+	_, err = kube.GetServiceImport(ctx, fakeMCSClient, "exported-svc", "remote-ns")
+	assert.True(t, errors.IsNotFound(err))
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_config_reloads_total",
+		Help: "Number of configuration reloads, by result",
+	},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		configReloads,
+	)
+}
+
+// IncConfigReloads increments the config reloads counter for the given result
+func IncConfigReloads(result string) {
+	configReloads.With(prometheus.Labels{
+		"result": result,
+	}).Inc()
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dryRunRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_dry_run_requests_total",
+		Help: "Number of mutating requests to the Kubernetes API recorded instead of executed in dry run mode, by verb, resource and namespace",
+	},
+		[]string{"verb", "resource", "namespace"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		dryRunRequests,
+	)
+}
+
+// IncDryRunRequests increments the number of recorded dry run requests
+func IncDryRunRequests(verb, resource, namespace string) {
+	dryRunRequests.With(prometheus.Labels{
+		"verb":      verb,
+		"resource":  resource,
+		"namespace": namespace,
+	}).Inc()
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	gcOrphans = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "semaphore_service_mirror_gc_orphans",
+		Help: "Number of orphaned mirrored objects found by the last garbage collection, by kind and runner",
+	},
+		[]string{"kind", "runner"},
+	)
+	gcDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_gc_deleted_total",
+		Help: "Number of orphaned mirrored objects deleted by garbage collection, by kind and runner",
+	},
+		[]string{"kind", "runner"},
+	)
+	gcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_gc_errors_total",
+		Help: "Number of errors during garbage collection, by kind and runner",
+	},
+		[]string{"kind", "runner"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		gcOrphans,
+		gcDeleted,
+		gcErrors,
+	)
+}
+
+// SetGCOrphans sets the number of orphaned objects of a kind found by a runner
+func SetGCOrphans(kind, runner string, v float64) {
+	gcOrphans.With(prometheus.Labels{
+		"kind":   kind,
+		"runner": runner,
+	}).Set(v)
+}
+
+// IncGCDeleted increments the number of orphaned objects of a kind deleted by a
+// runner
+func IncGCDeleted(kind, runner string) {
+	gcDeleted.With(prometheus.Labels{
+		"kind":   kind,
+		"runner": runner,
+	}).Inc()
+}
+
+// IncGCErrors increments the number of garbage collection errors for a kind
+// and runner
+func IncGCErrors(kind, runner string) {
+	gcErrors.With(prometheus.Labels{
+		"kind":   kind,
+		"runner": runner,
+	}).Inc()
+}
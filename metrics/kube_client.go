@@ -3,6 +3,7 @@ package metrics
 import (
 	"context"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,6 +23,12 @@ var (
 	},
 		[]string{"host", "method"},
 	)
+	kubeClientExecPluginCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_kube_exec_plugin_calls_total",
+		Help: "Number of calls to exec credential plugins by exit code and call status",
+	},
+		[]string{"code", "call_status"},
+	)
 )
 
 func init() {
@@ -35,17 +42,30 @@ type kubeClientRequestAdapter struct{}
 func (a *kubeClientRequestAdapter) Register() {
 	metrics.Register(
 		metrics.RegisterOpts{
-			RequestLatency: a,
-			RequestResult:  a,
+			RequestLatency:  a,
+			RequestResult:   a,
+			ExecPluginCalls: kubeClientExecPluginCallsAdapter{},
 		},
 	)
 	prometheus.MustRegister(
 		kubeClientRequests,
 		kubeClientRequestsDuration,
+		kubeClientExecPluginCalls,
 	)
 
 }
 
+// kubeClientExecPluginCallsAdapter implements metrics.CallsMetric
+type kubeClientExecPluginCallsAdapter struct{}
+
+// Increment implements metrics.CallsMetric
+func (a kubeClientExecPluginCallsAdapter) Increment(exitCode int, callStatus string) {
+	kubeClientExecPluginCalls.With(prometheus.Labels{
+		"code":        strconv.Itoa(exitCode),
+		"call_status": callStatus,
+	}).Inc()
+}
+
 // Increment implements metrics.ResultMetric
 func (a kubeClientRequestAdapter) Increment(ctx context.Context, code string, method string, host string) {
 	kubeClientRequests.With(prometheus.Labels{
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "semaphore_service_mirror_leader",
+		Help: "Whether this replica is the leader (1) or not (0)",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		leader,
+	)
+}
+
+// SetLeader sets the leadership state of the replica
+func SetLeader(isLeader bool) {
+	if isLeader {
+		leader.Set(1)
+		return
+	}
+	leader.Set(0)
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	remoteTokenReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_remote_token_reloads_total",
+		Help: "Number of remote cluster service account token reloads, by cluster and result",
+	},
+		[]string{"cluster", "result"},
+	)
+	remoteAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_remote_auth_failures_total",
+		Help: "Number of requests to remote cluster APIs rejected with 401 or 403, by cluster and code",
+	},
+		[]string{"cluster", "code"},
+	)
+	remoteCredentialErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_remote_credential_errors_total",
+		Help: "Number of requests to remote cluster APIs that failed to get credentials from an exec plugin or auth provider, by cluster",
+	},
+		[]string{"cluster"},
+	)
+	remoteCARefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_remote_ca_refreshes_total",
+		Help: "Number of remote cluster CA bundle refreshes, by cluster and result",
+	},
+		[]string{"cluster", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		remoteTokenReloads,
+		remoteAuthFailures,
+		remoteCredentialErrors,
+		remoteCARefreshes,
+	)
+}
+
+// IncRemoteTokenReloads increments the number of token reloads of a remote
+// cluster, result is "success" or "error"
+func IncRemoteTokenReloads(cluster, result string) {
+	remoteTokenReloads.With(prometheus.Labels{
+		"cluster": cluster,
+		"result":  result,
+	}).Inc()
+}
+
+// IncRemoteAuthFailures increments the number of rejected requests to a remote
+// cluster API
+func IncRemoteAuthFailures(cluster, code string) {
+	remoteAuthFailures.With(prometheus.Labels{
+		"cluster": cluster,
+		"code":    code,
+	}).Inc()
+}
+
+// IncRemoteCARefreshes increments the number of CA bundle refreshes of a remote
+// cluster, result is "success" or "error"
+func IncRemoteCARefreshes(cluster, result string) {
+	remoteCARefreshes.With(prometheus.Labels{
+		"cluster": cluster,
+		"result":  result,
+	}).Inc()
+}
+
+// IncRemoteCredentialErrors increments the number of requests to a remote
+// cluster API that failed to get credentials
+func IncRemoteCredentialErrors(cluster string) {
+	remoteCredentialErrors.With(prometheus.Labels{
+		"cluster": cluster,
+	}).Inc()
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	serviceRecreations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "semaphore_service_mirror_service_recreations_total",
+		Help: "Number of local services deleted and created again to change immutable fields",
+	},
+		[]string{"runner", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		serviceRecreations,
+	)
+}
+
+// IncServiceRecreations increments the number of services recreated by a
+// runner for the given reason
+func IncServiceRecreations(runner, reason string) {
+	serviceRecreations.With(prometheus.Labels{
+		"runner": runner,
+		"reason": reason,
+	}).Inc()
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	staleEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "semaphore_service_mirror_stale_endpoints",
+		Help: "Whether the stale endpoint policy is applied to the endpoints mirrored by a runner (1) or not (0)",
+	},
+		[]string{"runner"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		staleEndpoints,
+	)
+}
+
+// SetStaleEndpoints sets whether the stale endpoint policy is applied to the
+// endpoints mirrored by a runner
+func SetStaleEndpoints(runner string, v float64) {
+	staleEndpoints.With(prometheus.Labels{
+		"runner": runner,
+	}).Set(v)
+}
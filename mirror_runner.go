@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -17,115 +20,231 @@ import (
 	"github.com/utilitywarehouse/semaphore-service-mirror/log"
 )
 
-// MirrorRunner watches a remote cluster and mirrors services and endpoints locally
+// MirrorRunner watches a remote cluster and mirrors services and endpoints
+// or endpointslices locally
 type MirrorRunner struct {
-	ctx                    context.Context
-	client                 kubernetes.Interface
-	serviceQueue           *queue
-	serviceWatcher         *kube.ServiceWatcher
-	mirrorServiceWatcher   *kube.ServiceWatcher
-	endpointsQueue         *queue
-	endpointsWatcher       *kube.EndpointsWatcher
-	mirrorEndpointsWatcher *kube.EndpointsWatcher
-	mirrorLabels           map[string]string
-	name                   string
-	namespace              string
-	prefix                 string
-	labelselector          string
-	sync                   bool
-	initialised            bool // Flag to turn on after the successful initialisation of the runner.
-}
-
-func newMirrorRunner(client, watchClient kubernetes.Interface, name, namespace, prefix, labelselector string, resyncPeriod time.Duration, sync bool) *MirrorRunner {
+	ctx                        context.Context
+	client                     kubernetes.Interface
+	serviceQueue               *queue
+	serviceWatcher             *kube.ServiceWatcher
+	mirrorServiceWatcher       *kube.ServiceWatcher
+	endpointsQueue             *queue
+	endpointsWatcher           *kube.EndpointsWatcher
+	mirrorEndpointsWatcher     *kube.EndpointsWatcher
+	endpointSliceQueue         *queue
+	endpointSliceWatcher       *kube.EndpointSliceWatcher
+	mirrorEndpointSliceWatcher *kube.EndpointSliceWatcher
+	statusQueue                *queue
+	finalizerQueue             *queue
+	mirrorLabels               map[string]string
+	name                       string
+	namespace                  string
+	prefix                     string
+	labelselector              string
+	namespaceFilter            *namespaceFilter    // Remote namespaces to mirror, nil mirrors all
+	staleEndpoints             *staleEndpointGuard // Applies the stale endpoint policy while the remote cluster is unreachable, nil keeps endpoints
+	propagation                *propagationRules   // Labels and annotations to copy from remote services, nil copies none
+	status                     *statusReporter     // Reports the state of mirrored services, nil reports nothing
+	sync                       bool
+	endpointSlices             bool            // Mirror endpointslices instead of endpoints
+	loadBalancerIngress        bool            // Mirror the load balancer ingress of LoadBalancer services instead of their endpoints
+	finalizer                  bool            // Add a finalizer to mirrored services, to clean up after them before they are deleted
+	initialised                atomic.Bool     // Flag to turn on after the successful initialisation of the runner.
+	elected                    <-chan struct{} // Closed when the replica becomes the leader and should start reconciling
+	gc                         *garbageCollector
+	gcInterval                 time.Duration // How often to delete orphaned mirrors, 0 disables garbage collection
+	gcStop                     chan struct{}
+}
+
+// mirrorRunnerOptions holds the configuration of a MirrorRunner. Zero values
+// disable the optional features.
+type mirrorRunnerOptions struct {
+	client              kubernetes.Interface // Client of the local cluster, where mirrors are created
+	watchClient         kubernetes.Interface // Client of the remote cluster
+	name                string
+	namespace           string // Local namespace of the mirrors
+	prefix              string
+	labelSelector       string
+	namespaceFilter     *namespaceFilter
+	staleEndpoints      *staleEndpointGuard
+	propagation         *propagationRules
+	status              *statusReporter
+	resyncPeriod        time.Duration
+	sync                bool
+	endpointSlices      bool
+	loadBalancerIngress bool
+	finalizer           bool
+	gcInterval          time.Duration
+	gcGracePeriod       time.Duration
+	elected             <-chan struct{} // Nil never starts reconciling
+}
+
+func newMirrorRunner(opts mirrorRunnerOptions) *MirrorRunner {
 	mirrorLabels := map[string]string{
 		"mirrored-svc":           "true",
-		"mirror-svc-prefix-sync": prefix,
+		"mirror-svc-prefix-sync": opts.prefix,
 	}
 	runner := &MirrorRunner{
-		ctx:          context.Background(),
-		client:       client,
-		name:         name,
-		namespace:    namespace,
-		prefix:       prefix,
-		sync:         sync,
-		mirrorLabels: mirrorLabels,
-		initialised:  false,
-	}
-	runner.serviceQueue = newQueue(fmt.Sprintf("%s-service", name), runner.reconcileService)
-	runner.endpointsQueue = newQueue(fmt.Sprintf("%s-endpoints", name), runner.reconcileEndpoints)
-	runnerName := fmt.Sprintf("mirror-%s", name)
+		ctx:                 context.Background(),
+		client:              opts.client,
+		name:                opts.name,
+		namespace:           opts.namespace,
+		prefix:              opts.prefix,
+		namespaceFilter:     opts.namespaceFilter,
+		staleEndpoints:      opts.staleEndpoints,
+		propagation:         opts.propagation,
+		status:              opts.status,
+		sync:                opts.sync,
+		endpointSlices:      opts.endpointSlices,
+		loadBalancerIngress: opts.loadBalancerIngress,
+		finalizer:           opts.finalizer,
+		mirrorLabels:        mirrorLabels,
+		elected:             opts.elected,
+		gcInterval:          opts.gcInterval,
+		gcStop:              make(chan struct{}),
+	}
+	runner.serviceQueue = newQueue(fmt.Sprintf("%s-service", opts.name), runner.reconcileService)
+	runner.endpointsQueue = newQueue(fmt.Sprintf("%s-endpoints", opts.name), runner.reconcileEndpoints)
+	runner.endpointSliceQueue = newQueue(fmt.Sprintf("%s-mirror-endpointslice", opts.name), runner.reconcileEndpointSlice)
+	runner.statusQueue = newQueue(fmt.Sprintf("%s-mirror-status", opts.name), runner.reconcileStatus)
+	runner.finalizerQueue = newQueue(fmt.Sprintf("%s-mirror-finalizer", opts.name), runner.finalizeService)
+	runnerName := fmt.Sprintf("mirror-%s", opts.name)
+	runner.gc = newGarbageCollector(runnerName, opts.gcGracePeriod)
 
 	// Create and initialize a service watcher
 	serviceWatcher := kube.NewServiceWatcher(
-		fmt.Sprintf("%s-serviceWatcher", name),
-		watchClient,
-		resyncPeriod,
+		fmt.Sprintf("%s-serviceWatcher", opts.name),
+		opts.watchClient,
+		opts.resyncPeriod,
 		runner.ServiceEventHandler,
-		labelselector,
+		opts.labelSelector,
 		metav1.NamespaceAll,
 		runnerName,
+		kube.HealthOf(opts.name),
 	)
 	runner.serviceWatcher = serviceWatcher
 	runner.serviceWatcher.Init()
 
 	// Create and initialize a service watcher for mirrored services
 	mirrorServiceWatcher := kube.NewServiceWatcher(
-		fmt.Sprintf("%s-mirrorServiceWatcher", name),
-		client,
-		resyncPeriod,
-		nil,
+		fmt.Sprintf("%s-mirrorServiceWatcher", opts.name),
+		opts.client,
+		opts.resyncPeriod,
+		runner.MirrorServiceEventHandler,
 		labels.Set(mirrorLabels).String(),
-		namespace,
+		opts.namespace,
 		runnerName,
+		nil,
 	)
 	runner.mirrorServiceWatcher = mirrorServiceWatcher
 	runner.mirrorServiceWatcher.Init()
 
 	// Create and initialize an endpoints watcher
 	endpointsWatcher := kube.NewEndpointsWatcher(
-		fmt.Sprintf("%s-endpointsWatcher", name),
-		watchClient,
-		resyncPeriod,
+		fmt.Sprintf("%s-endpointsWatcher", opts.name),
+		opts.watchClient,
+		opts.resyncPeriod,
 		runner.EndpointsEventHandler,
-		labelselector,
+		opts.labelSelector,
 		metav1.NamespaceAll,
 		runnerName,
+		kube.HealthOf(opts.name),
 	)
 	runner.endpointsWatcher = endpointsWatcher
 	runner.endpointsWatcher.Init()
 
 	// Create and initialize an endpoints watcher for mirrored endpoints
 	mirrorEndpointsWatcher := kube.NewEndpointsWatcher(
-		fmt.Sprintf("%s-mirrorEndpointsWatcher", name),
-		client,
-		resyncPeriod,
+		fmt.Sprintf("%s-mirrorEndpointsWatcher", opts.name),
+		opts.client,
+		opts.resyncPeriod,
 		nil,
 		labels.Set(mirrorLabels).String(),
-		namespace,
+		opts.namespace,
 		runnerName,
+		nil,
 	)
 	runner.mirrorEndpointsWatcher = mirrorEndpointsWatcher
 	runner.mirrorEndpointsWatcher.Init()
 
+	// Create and initialize an endpointslice watcher
+	endpointSliceWatcher := kube.NewEndpointSliceWatcher(
+		fmt.Sprintf("%s-endpointSliceWatcher", opts.name),
+		opts.watchClient,
+		opts.resyncPeriod,
+		runner.EndpointSliceEventHandler,
+		opts.labelSelector,
+		metav1.NamespaceAll,
+		runnerName,
+		kube.HealthOf(opts.name),
+	)
+	runner.endpointSliceWatcher = endpointSliceWatcher
+	runner.endpointSliceWatcher.Init()
+
+	// Create and initialize an endpointslice watcher for mirrored
+	// endpointslices. Filter on the managed-by label to skip slices that
+	// kube-controller-manager mirrors from our endpoints.
+	mirrorEndpointSliceWatcher := kube.NewEndpointSliceWatcher(
+		fmt.Sprintf("%s-mirrorEndpointSliceWatcher", opts.name),
+		opts.client,
+		opts.resyncPeriod,
+		nil,
+		labels.Set(runner.mirrorEndpointSliceSelectorLabels()).String(),
+		opts.namespace,
+		runnerName,
+		nil,
+	)
+	runner.mirrorEndpointSliceWatcher = mirrorEndpointSliceWatcher
+	runner.mirrorEndpointSliceWatcher.Init()
+
 	return runner
 }
 
-// Run starts the watchers and queues of the runner
-func (mr *MirrorRunner) Run() error {
+// Run starts the watchers of the runner and, once the replica is elected
+// leader, the initial sync and queues. Followers keep their caches warm.
+func (mr *MirrorRunner) Run(ctx context.Context) error {
+	mr.ctx = ctx
 	go mr.serviceWatcher.Run()
 	go mr.mirrorServiceWatcher.Run()
-	// At this point the runner should be considered initialised and live.
-	mr.initialised = true
 	// wait for service watcher to sync before starting the endpoints to
 	// avoid race between them. TODO: atm dummy and could run forever if
 	// services cache fails to sync
-	stopCh := make(chan struct{})
-	if ok := cache.WaitForNamedCacheSync("serviceWatcher", stopCh, mr.serviceWatcher.HasSynced); !ok {
+	if ok := cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), mr.serviceWatcher.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for service caches to sync")
 	}
-	if ok := cache.WaitForNamedCacheSync("mirrorServiceWatcher", stopCh, mr.mirrorServiceWatcher.HasSynced); !ok {
+	if ok := cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), mr.mirrorServiceWatcher.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for mirror service caches to sync")
 	}
+	// Both mirror watchers run in either mode, to clean up objects left
+	// over from the other mode.
+	go mr.mirrorEndpointsWatcher.Run()
+	go mr.mirrorEndpointSliceWatcher.Run()
+	if ok := cache.WaitForNamedCacheSync("mirrorEndpointsWatcher", ctx.Done(), mr.mirrorEndpointsWatcher.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for mirror endpoints caches to sync")
+	}
+	if ok := cache.WaitForNamedCacheSync("mirrorEndpointSliceWatcher", ctx.Done(), mr.mirrorEndpointSliceWatcher.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for mirror endpointslice caches to sync")
+	}
+	if mr.endpointSlices {
+		go mr.endpointSliceWatcher.Run()
+		if ok := cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), mr.endpointSliceWatcher.HasSynced); !ok {
+			return fmt.Errorf("failed to wait for endpointslice caches to sync")
+		}
+	} else {
+		go mr.endpointsWatcher.Run()
+		if ok := cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), mr.endpointsWatcher.HasSynced); !ok {
+			return fmt.Errorf("failed to wait for endpoints caches to sync")
+		}
+	}
+	// The runner is initialised once all its caches have synced
+	mr.initialised.Store(true)
+
+	log.Logger.Info("waiting for leadership to start reconciling", "runner", mr.name)
+	select {
+	case <-mr.elected:
+	case <-ctx.Done():
+		return fmt.Errorf("stopped while waiting for leadership: %v", ctx.Err())
+	}
 
 	// After services store syncs, perform a sync to delete stale mirrors
 	if mr.sync {
@@ -137,33 +256,127 @@ func (mr *MirrorRunner) Run() error {
 				"runner", mr.name,
 			)
 		}
+		if mr.endpointSlices {
+			log.Logger.Info("Syncing endpointslices", "runner", mr.name)
+			if err := mr.EndpointSliceSync(); err != nil {
+				log.Logger.Warn(
+					"Error syncing endpointslices, skipping..",
+					"err", err,
+					"runner", mr.name,
+				)
+			}
+		}
 	}
-	go mr.endpointsWatcher.Run()
-	go mr.mirrorEndpointsWatcher.Run()
 
 	go mr.serviceQueue.Run()
-	go mr.endpointsQueue.Run()
+	if mr.endpointSlices {
+		go mr.endpointSliceQueue.Run()
+	} else {
+		go mr.endpointsQueue.Run()
+	}
+	go mr.statusQueue.Run()
+	go mr.finalizerQueue.Run()
+	go runGarbageCollection(ctx, mr.gcStop, mr.gcInterval, mr.name, mr.GarbageCollect)
+	go mr.staleEndpoints.Run(ctx, mr.name, mr.requeueEndpoints)
 
 	return nil
 }
 
-// Stop stops watchers and runners
+// Stop stops garbage collection, queues and watchers. It waits for in-flight
+// reconciles to finish before stopping the watchers.
 func (mr *MirrorRunner) Stop() {
+	close(mr.gcStop)
+	mr.staleEndpoints.Stop()
 	mr.serviceQueue.Stop()
+	mr.endpointsQueue.Stop()
+	mr.endpointSliceQueue.Stop()
+	mr.statusQueue.Stop()
+	mr.finalizerQueue.Stop()
 	mr.serviceWatcher.Stop()
 	mr.mirrorServiceWatcher.Stop()
-	mr.endpointsQueue.Stop()
 	mr.endpointsWatcher.Stop()
 	mr.mirrorEndpointsWatcher.Stop()
+	mr.endpointSliceWatcher.Stop()
+	mr.mirrorEndpointSliceWatcher.Stop()
+}
+
+// requeueEndpoints queues all the remote endpoints, or endpointslices, to
+// reconcile their mirrors
+func (mr *MirrorRunner) requeueEndpoints() {
+	if mr.endpointSlices {
+		endpointSlices, err := mr.endpointSliceWatcher.List()
+		if err != nil {
+			log.Logger.Error("listing remote endpointslices", "err", err, "runner", mr.name)
+			return
+		}
+		for _, es := range endpointSlices {
+			if mr.namespaceFilter.Allowed(es.Namespace) {
+				mr.endpointSliceQueue.Add(es)
+			}
+		}
+		return
+	}
+	endpoints, err := mr.endpointsWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote endpoints", "err", err, "runner", mr.name)
+		return
+	}
+	for _, e := range endpoints {
+		if mr.namespaceFilter.Allowed(e.Namespace) {
+			mr.endpointsQueue.Add(e)
+		}
+	}
+}
+
+// requeueServiceEndpoints queues the remote endpoints, or endpointslices, of a
+// service to reconcile their mirrors
+func (mr *MirrorRunner) requeueServiceEndpoints(name, namespace string) {
+	if !mr.endpointSlices {
+		mr.endpointsQueue.Add(&v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}})
+		return
+	}
+	endpointSlices, err := mr.endpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing remote endpointslices", "err", err, "runner", mr.name)
+		return
+	}
+	for _, es := range endpointSlices {
+		if es.Namespace == namespace && es.Labels["kubernetes.io/service-name"] == name {
+			mr.endpointSliceQueue.Add(es)
+		}
+	}
 }
 
 // Initialised returns true when the runner is successfully initialised
 func (mr *MirrorRunner) Initialised() bool {
-	return mr.initialised
+	return mr.initialised.Load()
+}
+
+// Lookup returns the namespace and name of the remote service mirrored under
+// the passed local name. It reads the annotations of the mirrored service and
+// falls back to parsing the name for mirrors created without them.
+func (mr *MirrorRunner) Lookup(mirrorName string) (string, string, bool) {
+	svc, err := mr.mirrorServiceWatcher.Get(mirrorName, mr.namespace)
+	if err != nil {
+		return "", "", false
+	}
+	name, nameOk := svc.Annotations[mirrorSvcRemoteNameAnno]
+	namespace, namespaceOk := svc.Annotations[mirrorSvcRemoteNamespaceAnno]
+	if nameOk && namespaceOk {
+		return namespace, name, true
+	}
+	return parseMirrorName(mr.prefix, mirrorName)
 }
 
-func (mr *MirrorRunner) reconcileService(name, namespace string) error {
+func (mr *MirrorRunner) reconcileService(name, namespace string) (err error) {
 	mirrorName := generateMirrorName(mr.prefix, namespace, name)
+	defer func() {
+		if err != nil {
+			kube.EventRecorder.Eventf(mr.eventTarget(mirrorName), v1.EventTypeWarning, eventReasonReconcileFailed, "Failed to mirror service %s/%s from cluster %s: %v", namespace, name, mr.name, err)
+		}
+		mr.status.RecordReconcile(name, namespace, err)
+		mr.queueStatus(name, namespace)
+	}()
 
 	// Get the remote service
 	log.Logger.Info("getting remote service", "namespace", namespace, "name", name, "runner", mr.name)
@@ -172,7 +385,10 @@ func (mr *MirrorRunner) reconcileService(name, namespace string) error {
 		// If the remote service doesn't exist, clean up the local mirror service (if it
 		// exists)
 		log.Logger.Info("remote service not found, deleting local service", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-		if err := kube.DeleteService(mr.ctx, mr.client, mirrorName, mr.namespace); err != nil && !errors.IsNotFound(err) {
+		target := mr.eventTarget(mirrorName)
+		if err := kube.DeleteService(mr.ctx, mr.client, mirrorName, mr.namespace); err == nil {
+			kube.EventRecorder.Eventf(target, v1.EventTypeNormal, eventReasonDeleted, "Deleted mirror of service %s/%s, which was removed from cluster %s", namespace, name, mr.name)
+		} else if !errors.IsNotFound(err) {
 			return fmt.Errorf("deleting service %s/%s: %v", mr.namespace, mirrorName, err)
 		}
 		return nil
@@ -182,37 +398,105 @@ func (mr *MirrorRunner) reconcileService(name, namespace string) error {
 
 	// If the mirror service doesn't exist, create it. Otherwise, update it.
 	mirrorSvc, err := kube.GetService(mr.ctx, mr.client, mirrorName, mr.namespace)
+	var svc *v1.Service
 	if errors.IsNotFound(err) {
 		log.Logger.Info("local service not found, creating service", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-		if _, err := kube.CreateService(mr.ctx, mr.client, mirrorName, mr.namespace, mr.mirrorLabels, map[string]string{}, remoteSvc.Spec.Ports, isHeadless(remoteSvc)); err != nil {
+		meta := mr.serviceMetadata(&metav1.ObjectMeta{Labels: mergeMetadata(mr.mirrorLabels)}, remoteSvc)
+		svc, err = kube.CreateService(mr.ctx, mr.client, mirrorName, mr.namespace, meta.Labels, meta.Annotations, kube.MirroredServiceSpec(remoteSvc.Spec))
+		if err != nil {
 			return fmt.Errorf("creating service %s/%s: %v", mr.namespace, mirrorName, err)
 		}
+		kube.EventRecorder.Eventf(svc, v1.EventTypeNormal, eventReasonCreated, "Created mirror of service %s/%s from cluster %s", namespace, name, mr.name)
+		if len(fullMirrorName(mr.prefix, namespace, name)) > maxNameLength {
+			kube.EventRecorder.Eventf(svc, v1.EventTypeWarning, eventReasonNameTooLong, "Mirror name of service %s/%s is longer than %d characters and was shortened", namespace, name, maxNameLength)
+		}
 	} else if err != nil {
 		return fmt.Errorf("getting service %s/%s: %v", mr.namespace, mirrorName, err)
+	} else if mirrorSvc.DeletionTimestamp != nil {
+		// Retry until the deletion completes, to create the service again
+		return fmt.Errorf("service %s/%s is being deleted", mr.namespace, mirrorName)
 	} else {
 		log.Logger.Info("local service found, updating service", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-		if _, err := kube.UpdateService(mr.ctx, mr.client, mirrorSvc, remoteSvc.Spec.Ports); err != nil {
+		meta := mr.serviceMetadata(mirrorSvc.ObjectMeta.DeepCopy(), remoteSvc)
+		spec := kube.MirroredServiceSpec(remoteSvc.Spec)
+		svc, err = kube.UpdateService(mr.ctx, mr.client, mirrorSvc, meta.Labels, meta.Annotations, spec)
+		if err == nil && svc != mirrorSvc {
+			kube.EventRecorder.Eventf(svc, v1.EventTypeNormal, eventReasonUpdated, "Updated mirror of service %s/%s from cluster %s", namespace, name, mr.name)
+		}
+		if err == kube.ErrServiceRecreateRequired {
+			if svc, err = recreateService(mr.ctx, mr.client, mr.name, mirrorSvc, meta.Labels, meta.Annotations, spec); err != nil {
+				return fmt.Errorf("recreating service %s/%s: %v", mr.namespace, mirrorName, err)
+			}
+		} else if err != nil {
 			return fmt.Errorf("updating service %s/%s: %v", mr.namespace, mirrorName, err)
 		}
 	}
+	if _, err := kube.SetServiceFinalizer(mr.ctx, mr.client, svc, serviceFinalizer, mr.finalizer); err != nil {
+		return fmt.Errorf("setting finalizer of service %s/%s: %v", mr.namespace, mirrorName, err)
+	}
 
+	// Load balancer endpoints follow the status of the service, not the
+	// remote endpoints
+	if mr.mirrorsLoadBalancer(remoteSvc) {
+		return mr.reconcileLoadBalancerEndpoints(mirrorName, remoteSvc)
+	}
 	return nil
 }
 
+// mirrorsLoadBalancer returns true if the endpoints of the mirrored service
+// should point at the load balancer of the remote service instead of its pods
+func (mr *MirrorRunner) mirrorsLoadBalancer(remoteSvc *v1.Service) bool {
+	return mr.loadBalancerIngress && remoteSvc.Spec.Type == v1.ServiceTypeLoadBalancer
+}
+
+// reconcileLoadBalancerEndpoints points the endpoints of the mirrored service at
+// the load balancer ingress of the remote service. Endpoints are used in both
+// endpoints and endpointslice mirroring modes, Kubernetes mirrors them into
+// endpointslices.
+func (mr *MirrorRunner) reconcileLoadBalancerEndpoints(mirrorName string, remoteSvc *v1.Service) error {
+	log.Logger.Info("mirroring load balancer ingress", "namespace", remoteSvc.Namespace, "name", remoteSvc.Name, "runner", mr.name)
+	if err := mr.applyEndpoints(mirrorName, mr.staleEndpoints.Subsets(loadBalancerSubsets(remoteSvc))); err != nil {
+		return err
+	}
+	return mr.deleteServiceMirrorEndpointSlices(mirrorName)
+}
+
+// serviceMetadata sets the labels and annotations of a mirrored service: the
+// ones propagated from the remote service and the annotations owned by the
+// controller, which always take precedence. The mirror labels are never
+// overwritten or removed.
+func (mr *MirrorRunner) serviceMetadata(meta *metav1.ObjectMeta, remoteSvc *v1.Service) *metav1.ObjectMeta {
+	annotations := generateMirrorAnnotations(remoteSvc.Name, remoteSvc.Namespace)
+	propagateMetadata(
+		meta,
+		mr.propagation.Labels(remoteSvc.Labels),
+		mr.propagation.Annotations(remoteSvc.Annotations),
+		mr.mirrorLabels,
+		annotations,
+	)
+	for k, v := range annotations {
+		meta.Annotations[k] = v
+	}
+	return meta
+}
+
 func (mr *MirrorRunner) getRemoteService(name, namespace string) (*v1.Service, error) {
 	return mr.serviceWatcher.Get(name, namespace)
 }
 
-// ServiceSync checks for stale mirrors (services) under the local namespace and
-// deletes them
-func (mr *MirrorRunner) ServiceSync() error {
+// orphanServices returns the names of mirrored services under the local
+// namespace whose remote service does not exist or is in an excluded namespace
+func (mr *MirrorRunner) orphanServices() ([]string, error) {
 	storeSvcs, err := mr.serviceWatcher.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mirrorSvcList := []string{}
 	for _, svc := range storeSvcs {
+		if !mr.namespaceFilter.Allowed(svc.Namespace) {
+			continue
+		}
 		mirrorSvcList = append(
 			mirrorSvcList,
 			generateMirrorName(mr.prefix, svc.Namespace, svc.Name),
@@ -221,35 +505,237 @@ func (mr *MirrorRunner) ServiceSync() error {
 
 	currSvcs, err := mr.mirrorServiceWatcher.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	orphans := []string{}
 	for _, svc := range currSvcs {
-		_, inSlice := inSlice(mirrorSvcList, svc.Name)
-		if !inSlice {
-			log.Logger.Info(
-				"Deleting old service and related endpoint",
-				"service", svc.Name,
-				"runner", mr.name,
-			)
-			// Deleting a service should also clear the related
-			// endpoints
-			if err := kube.DeleteService(mr.ctx, mr.client, svc.Name, mr.namespace); err != nil {
-				log.Logger.Error(
-					"Error clearing service",
-					"service", svc.Name,
-					"err", err,
-					"runner", mr.name,
-				)
-				return err
-			}
+		if _, inSlice := inSlice(mirrorSvcList, svc.Name); !inSlice {
+			orphans = append(orphans, svc.Name)
+		}
+	}
+	return orphans, nil
+}
+
+// ServiceSync checks for stale mirrors (services) under the local namespace and
+// deletes them
+func (mr *MirrorRunner) ServiceSync() error {
+	orphans, err := mr.orphanServices()
+	if err != nil {
+		return err
+	}
+	for _, name := range orphans {
+		if err := mr.deleteOrphanService(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mr *MirrorRunner) deleteOrphanService(name string) error {
+	log.Logger.Info(
+		"Deleting old service and related endpoint",
+		"service", name,
+		"runner", mr.name,
+	)
+	// Resolve the remote service before the mirror is gone from the cache
+	namespace, svcName, _ := mr.Lookup(name)
+	target := mr.eventTarget(name)
+	// Deleting a service should also clear the related endpoints
+	if err := kube.DeleteService(mr.ctx, mr.client, name, mr.namespace); err != nil && !errors.IsNotFound(err) {
+		log.Logger.Error(
+			"Error clearing service",
+			"service", name,
+			"err", err,
+			"runner", mr.name,
+		)
+		return err
+	}
+	kube.EventRecorder.Eventf(target, v1.EventTypeNormal, eventReasonGarbageCollected, "Deleted orphaned mirror service, its remote service is not mirrored from cluster %s anymore", mr.name)
+	return mr.status.DeleteMirroredService(mr.ctx, name, svcName, namespace)
+}
+
+// GarbageCollect deletes mirrored services, and endpointslices when mirroring
+// endpointslices, that have been orphaned for longer than the grace period
+func (mr *MirrorRunner) GarbageCollect() {
+	// An unsynced remote cache would make all mirrors look orphaned
+	if !mr.serviceWatcher.HasSynced() {
+		log.Logger.Warn("remote service cache not synced, skipping garbage collection", "runner", mr.name)
+		return
+	}
+	mr.gc.collect("service", mr.orphanServices, mr.deleteOrphanService)
+
+	if !mr.endpointSlices {
+		return
+	}
+	if !mr.endpointSliceWatcher.HasSynced() {
+		log.Logger.Warn("remote endpointslice cache not synced, skipping garbage collection", "runner", mr.name)
+		return
+	}
+	mr.gc.collect("endpointslice", mr.orphanEndpointSlices, mr.deleteOrphanEndpointSlice)
+}
+
+// Cleanup deletes all the services and endpointslices mirrored by the runner. It
+// is meant to be called after the runner is stopped, when the remote cluster is
+// removed from the configuration.
+func (mr *MirrorRunner) Cleanup() error {
+	svcs, err := mr.client.CoreV1().Services(mr.namespace).List(
+		mr.ctx,
+		metav1.ListOptions{LabelSelector: labels.Set(mr.mirrorLabels).String()},
+	)
+	if err != nil {
+		return fmt.Errorf("listing mirrored services: %v", err)
+	}
+	for _, svc := range svcs.Items {
+		log.Logger.Info(
+			"Deleting mirrored service",
+			"service", svc.Name,
+			"runner", mr.name,
+		)
+		// The runner is stopped and cannot finalize the service, the
+		// clean up is done here instead
+		if _, err := kube.SetServiceFinalizer(mr.ctx, mr.client, &svc, serviceFinalizer, false); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("removing finalizer of service %s/%s: %v", mr.namespace, svc.Name, err)
 		}
+		// Deleting a service should also clear the related endpoints
+		if err := kube.DeleteService(mr.ctx, mr.client, svc.Name, mr.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting service %s/%s: %v", mr.namespace, svc.Name, err)
+		}
+	}
+	if err := mr.status.DeleteMirroredServices(mr.ctx); err != nil {
+		return err
+	}
+	// Endpointslices created by the runner are not cleared with the services
+	return mr.deleteMirrorEndpointSlices()
+}
+
+// queueStatus queues the remote service to report the state of its mirror
+func (mr *MirrorRunner) queueStatus(name, namespace string) {
+	if mr.status == nil || name == "" {
+		return
+	}
+	mr.statusQueue.Add(&metav1.ObjectMeta{Name: name, Namespace: namespace})
+}
+
+// reconcileStatus reports the state of a mirrored service, or deletes the
+// report if the remote service is gone
+func (mr *MirrorRunner) reconcileStatus(name, namespace string) error {
+	mirrorName := generateMirrorName(mr.prefix, namespace, name)
+	remoteSvc, err := mr.getRemoteService(name, namespace)
+	if errors.IsNotFound(err) {
+		return mr.status.DeleteMirroredService(mr.ctx, mirrorName, name, namespace)
+	} else if err != nil {
+		return fmt.Errorf("getting remote service: %v", err)
+	}
+	endpoints, ready := mr.countEndpoints(remoteSvc)
+	return mr.status.ReportMirroredService(mr.ctx, mirrorName, remoteSvc, endpoints, ready)
+}
+
+// countEndpoints returns the number of all and ready endpoints of a remote
+// service that are mirrored
+func (mr *MirrorRunner) countEndpoints(remoteSvc *v1.Service) (int, int) {
+	if mr.mirrorsLoadBalancer(remoteSvc) {
+		return countSubsetEndpoints(loadBalancerSubsets(remoteSvc))
+	}
+	if mr.endpointSlices {
+		endpointSlices, err := mr.endpointSliceWatcher.List()
+		if err != nil {
+			return 0, 0
+		}
+		return countEndpointSliceEndpoints(endpointSlices, remoteSvc.Name, remoteSvc.Namespace)
+	}
+	endpoints, err := mr.getRemoteEndpoints(remoteSvc.Name, remoteSvc.Namespace)
+	if err != nil {
+		return 0, 0
+	}
+	return countSubsetEndpoints(endpoints.Subsets)
+}
+
+// finalizeService deletes the endpoints, endpointslices and status resource of
+// a mirrored service that is being deleted and removes its finalizer, to let
+// the deletion complete
+func (mr *MirrorRunner) finalizeService(name, namespace string) error {
+	svc, err := mr.mirrorServiceWatcher.Get(name, namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting service %s/%s: %v", namespace, name, err)
+	}
+	if !finalizing(svc) {
+		return nil
+	}
+	log.Logger.Info("cleaning up after deleted service", "namespace", namespace, "name", name, "runner", mr.name)
+	if err := mr.deleteEndpoints(name, namespace); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting endpoints %s/%s: %v", namespace, name, err)
+	}
+	if err := mr.deleteServiceMirrorEndpointSlices(name); err != nil {
+		return err
+	}
+	remoteNamespace, remoteName, _ := mr.Lookup(name)
+	if err := mr.status.DeleteMirroredService(mr.ctx, name, remoteName, remoteNamespace); err != nil {
+		return err
+	}
+	if _, err := kube.SetServiceFinalizer(mr.ctx, mr.client, svc, serviceFinalizer, false); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("removing finalizer of service %s/%s: %v", namespace, name, err)
 	}
 	return nil
 }
 
+// MirrorServiceEventHandler queues mirrored services that are being deleted
+// to clean up after them. For new or recreated mirrored services, and ones with
+// endpoints or endpointslices that they do not own, it queues the remote
+// endpoints to set the owner of their mirrors.
+func (mr *MirrorRunner) MirrorServiceEventHandler(eventType watch.EventType, old *v1.Service, new *v1.Service) {
+	switch eventType {
+	case watch.Added, watch.Modified:
+		if finalizing(new) {
+			log.Logger.Debug("mirrored service deleted, queueing clean up", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
+			mr.finalizerQueue.Add(new)
+			return
+		}
+		if new.DeletionTimestamp != nil {
+			return
+		}
+		if eventType == watch.Added || old.UID != new.UID || !mr.endpointsOwnedBy(new) {
+			namespace, name, ok := mr.Lookup(new.Name)
+			if !ok {
+				return
+			}
+			// Load balancer endpoints are reconciled with the service
+			if remoteSvc, err := mr.getRemoteService(name, namespace); err == nil && mr.mirrorsLoadBalancer(remoteSvc) {
+				mr.serviceQueue.Add(remoteSvc)
+				return
+			}
+			mr.requeueServiceEndpoints(name, namespace)
+		}
+	}
+}
+
+// endpointsOwnedBy returns true if the mirrored endpoints and endpointslices of
+// a mirrored service are owned by it
+func (mr *MirrorRunner) endpointsOwnedBy(mirrorSvc *v1.Service) bool {
+	if e, err := mr.mirrorEndpointsWatcher.Get(mirrorSvc.Name, mr.namespace); err == nil && !kube.OwnedByService(e.OwnerReferences, mirrorSvc) {
+		return false
+	}
+	endpointSlices, err := mr.mirrorEndpointSliceWatcher.List()
+	if err != nil {
+		log.Logger.Error("listing mirrored endpointslices", "err", err, "runner", mr.name)
+		return true
+	}
+	for _, es := range endpointSlices {
+		if es.Labels["kubernetes.io/service-name"] == mirrorSvc.Name && !kube.OwnedByService(es.OwnerReferences, mirrorSvc) {
+			return false
+		}
+	}
+	return true
+}
+
 // ServiceEventHandler adds Service resource events to the respective queue
 func (mr *MirrorRunner) ServiceEventHandler(eventType watch.EventType, old *v1.Service, new *v1.Service) {
+	if namespace := eventNamespace(eventType, old, new); !mr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping service event from excluded namespace", "namespace", namespace, "runner", mr.name)
+		return
+	}
 	switch eventType {
 	case watch.Added:
 		log.Logger.Debug("service added", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
@@ -257,6 +743,10 @@ func (mr *MirrorRunner) ServiceEventHandler(eventType watch.EventType, old *v1.S
 	case watch.Modified:
 		log.Logger.Debug("service modified", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
 		mr.serviceQueue.Add(new)
+		// Endpoints switch between the load balancer and the pods
+		if mr.loadBalancerIngress && old.Spec.Type != new.Spec.Type {
+			mr.requeueServiceEndpoints(new.Name, new.Namespace)
+		}
 	case watch.Deleted:
 		log.Logger.Debug("service deleted", "namespace", old.Namespace, "name", old.Name, "runner", mr.name)
 		mr.serviceQueue.Add(old)
@@ -265,8 +755,13 @@ func (mr *MirrorRunner) ServiceEventHandler(eventType watch.EventType, old *v1.S
 	}
 }
 
-func (mr *MirrorRunner) reconcileEndpoints(name, namespace string) error {
+func (mr *MirrorRunner) reconcileEndpoints(name, namespace string) (err error) {
 	mirrorName := generateMirrorName(mr.prefix, namespace, name)
+	defer func() {
+		if err != nil {
+			kube.EventRecorder.Eventf(mr.eventTarget(mirrorName), v1.EventTypeWarning, eventReasonReconcileFailed, "Failed to mirror endpoints %s/%s from cluster %s: %v", namespace, name, mr.name, err)
+		}
+	}()
 
 	// Get the remote endpoints
 	log.Logger.Info("getting remote endpoints", "namespace", namespace, "name", name, "runner", mr.name)
@@ -276,24 +771,39 @@ func (mr *MirrorRunner) reconcileEndpoints(name, namespace string) error {
 		if err := mr.deleteEndpoints(mirrorName, mr.namespace); err != nil && !errors.IsNotFound(err) {
 			return fmt.Errorf("deleting endpoints %s/%s: %v", mr.namespace, mirrorName, err)
 		}
-		return nil
+		return mr.deleteServiceMirrorEndpointSlices(mirrorName)
 	} else if err != nil {
 		return fmt.Errorf("getting remote endpoints %s/%s: %v", namespace, name, err)
 	}
+	if remoteSvc, err := mr.getRemoteService(name, namespace); err == nil && mr.mirrorsLoadBalancer(remoteSvc) {
+		log.Logger.Debug("skipping endpoints of service mirrored from its load balancer", "namespace", namespace, "name", name, "runner", mr.name)
+		return nil
+	}
 
-	// If the mirror endpoints doesn't exist, create it. Otherwise, update it.
+	if err := mr.applyEndpoints(mirrorName, mr.staleEndpoints.Subsets(remoteEndpoints.Subsets)); err != nil {
+		return err
+	}
+	// Delete endpointslices left over from the endpointslice mirroring
+	// mode, now that the service has endpoints. Kubernetes will mirror the
+	// endpoints into endpointslices.
+	return mr.deleteServiceMirrorEndpointSlices(mirrorName)
+}
+
+// applyEndpoints creates the mirror endpoints if they don't exist, or updates
+// them otherwise
+func (mr *MirrorRunner) applyEndpoints(mirrorName string, subsets []v1.EndpointSubset) error {
 	log.Logger.Info("getting local endpoints", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-	_, err = mr.getEndpoints(mirrorName, mr.namespace)
+	_, err := mr.getEndpoints(mirrorName, mr.namespace)
 	if errors.IsNotFound(err) {
 		log.Logger.Info("local endpoints not found, creating endpoints", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-		if _, err := mr.createEndpoints(mirrorName, mr.namespace, mr.mirrorLabels, remoteEndpoints.Subsets); err != nil {
+		if _, err := mr.createEndpoints(mirrorName, mr.namespace, mr.mirrorLabels, subsets); err != nil {
 			return fmt.Errorf("creating endpoints %s/%s: %v", mr.namespace, mirrorName, err)
 		}
 	} else if err != nil {
 		return fmt.Errorf("getting endpoints %s/%s: %v", mr.namespace, mirrorName, err)
 	} else {
 		log.Logger.Info("local endpoints found, updating endpoints", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
-		if _, err := mr.updateEndpoints(mirrorName, mr.namespace, mr.mirrorLabels, remoteEndpoints.Subsets); err != nil {
+		if _, err := mr.updateEndpoints(mirrorName, mr.namespace, mr.mirrorLabels, subsets); err != nil {
 			return fmt.Errorf("updating endpoints %s/%s: %v", mr.namespace, mirrorName, err)
 		}
 	}
@@ -304,6 +814,20 @@ func (mr *MirrorRunner) getRemoteEndpoints(name, namespace string) (*v1.Endpoint
 	return mr.endpointsWatcher.Get(name, namespace)
 }
 
+// ownerReferences returns the owner references of the endpoints and
+// endpointslices of a mirrored service, so that they are deleted with it. The
+// service is read from the cache, which may lag behind a recreated service:
+// MirrorServiceEventHandler requeues the endpoints once the cache holds the new
+// service, which repairs their owner. Returns nil if the service is not cached
+// yet.
+func (mr *MirrorRunner) ownerReferences(mirrorName string) []metav1.OwnerReference {
+	svc, err := mr.mirrorServiceWatcher.Get(mirrorName, mr.namespace)
+	if err != nil {
+		return nil
+	}
+	return kube.ServiceOwnerReferences(svc)
+}
+
 func (mr *MirrorRunner) getEndpoints(name, namespace string) (*v1.Endpoints, error) {
 	return mr.client.CoreV1().Endpoints(namespace).Get(
 		mr.ctx,
@@ -317,9 +841,10 @@ func (mr *MirrorRunner) createEndpoints(name, namespace string, labels map[strin
 		mr.ctx,
 		&v1.Endpoints{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: namespace,
-				Labels:    labels,
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          labels,
+				OwnerReferences: mr.ownerReferences(name),
 			},
 			Subsets: subsets,
 		},
@@ -332,9 +857,10 @@ func (mr *MirrorRunner) updateEndpoints(name, namespace string, labels map[strin
 		mr.ctx,
 		&v1.Endpoints{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: namespace,
-				Labels:    labels,
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          labels,
+				OwnerReferences: mr.ownerReferences(name),
 			},
 			Subsets: subsets,
 		},
@@ -352,17 +878,321 @@ func (mr *MirrorRunner) deleteEndpoints(name, namespace string) error {
 
 // EndpointsEventHandler adds Endpoints resource events to the respective queue
 func (mr *MirrorRunner) EndpointsEventHandler(eventType watch.EventType, old *v1.Endpoints, new *v1.Endpoints) {
+	if namespace := eventNamespace(eventType, old, new); !mr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping endpoints event from excluded namespace", "namespace", namespace, "runner", mr.name)
+		return
+	}
 	switch eventType {
 	case watch.Added:
 		log.Logger.Debug("endpoints added", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
 		mr.endpointsQueue.Add(new)
+		mr.queueStatus(new.Name, new.Namespace)
 	case watch.Modified:
 		log.Logger.Debug("endpoints modified", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
 		mr.endpointsQueue.Add(new)
+		mr.queueStatus(new.Name, new.Namespace)
 	case watch.Deleted:
 		log.Logger.Debug("endpoints deleted", "namespace", old.Namespace, "name", old.Name, "runner", mr.name)
 		mr.endpointsQueue.Add(old)
+		mr.queueStatus(old.Name, old.Namespace)
 	default:
 		log.Logger.Info("Unknown endpoints event received: %v", eventType, "runner", mr.name)
 	}
 }
+
+// mirrorEndpointSliceSelectorLabels returns the labels that select the
+// endpointslices created by the runner
+func (mr *MirrorRunner) mirrorEndpointSliceSelectorLabels() map[string]string {
+	selectorLabels := map[string]string{
+		"endpointslice.kubernetes.io/managed-by": "semaphore-service-mirror",
+	}
+	for k, v := range mr.mirrorLabels {
+		selectorLabels[k] = v
+	}
+	return selectorLabels
+}
+
+func (mr *MirrorRunner) reconcileEndpointSlice(name, namespace string) (err error) {
+	mirrorName := generateMirrorName(mr.prefix, namespace, name)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if target := mr.endpointSliceEventTarget(mirrorName, name, namespace); target != nil {
+			kube.EventRecorder.Eventf(target, v1.EventTypeWarning, eventReasonReconcileFailed, "Failed to mirror endpointslice %s/%s from cluster %s: %v", namespace, name, mr.name, err)
+		}
+	}()
+
+	// Get the remote endpointslice
+	log.Logger.Info("getting remote endpointslice", "namespace", namespace, "name", name, "runner", mr.name)
+	remoteEndpointSlice, err := mr.endpointSliceWatcher.Get(name, namespace)
+	if errors.IsNotFound(err) {
+		log.Logger.Info("remote endpointslice not found, removing local mirror", "namespace", namespace, "name", name, "runner", mr.name)
+		if err := mr.deleteEndpointSlice(mirrorName, mr.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", mr.namespace, mirrorName, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting remote endpointslice %s/%s: %v", namespace, name, err)
+	}
+	// Determine the local mirror service to target
+	targetSvc, ok := remoteEndpointSlice.Labels["kubernetes.io/service-name"]
+	if !ok {
+		return fmt.Errorf("remote endpointslice is missing kubernetes.io/service-name label")
+	}
+	targetMirrorService := generateMirrorName(mr.prefix, namespace, targetSvc)
+	if remoteSvc, err := mr.getRemoteService(targetSvc, namespace); err == nil && mr.mirrorsLoadBalancer(remoteSvc) {
+		log.Logger.Debug("skipping endpointslice of service mirrored from its load balancer", "namespace", namespace, "name", name, "runner", mr.name)
+		if err := mr.deleteEndpointSlice(mirrorName, mr.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", mr.namespace, mirrorName, err)
+		}
+		return nil
+	}
+
+	// If the mirror endpointslice doesn't exist, create it. Otherwise, update it.
+	log.Logger.Info("getting local endpointslice", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
+	_, err = mr.getEndpointSlice(mirrorName, mr.namespace)
+	if errors.IsNotFound(err) {
+		log.Logger.Info("local endpointslice not found, creating", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
+		if _, err := mr.createEndpointSlice(mirrorName, mr.namespace, targetMirrorService, remoteEndpointSlice.AddressType, mr.staleEndpoints.Endpoints(remoteEndpointSlice.Endpoints), remoteEndpointSlice.Ports); err != nil {
+			return fmt.Errorf("creating endpointslice %s/%s: %v", mr.namespace, mirrorName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("getting endpointslice %s/%s: %v", mr.namespace, mirrorName, err)
+	} else {
+		log.Logger.Info("local endpointslice found, updating", "namespace", mr.namespace, "name", mirrorName, "runner", mr.name)
+		if _, err := mr.updateEndpointSlice(mirrorName, mr.namespace, targetMirrorService, remoteEndpointSlice.AddressType, mr.staleEndpoints.Endpoints(remoteEndpointSlice.Endpoints), remoteEndpointSlice.Ports); err != nil {
+			return fmt.Errorf("updating endpointslice %s/%s: %v", mr.namespace, mirrorName, err)
+		}
+	}
+
+	// Delete endpoints left over from the endpoints mirroring mode, now that
+	// the service has an endpointslice
+	if _, err := mr.mirrorEndpointsWatcher.Get(targetMirrorService, mr.namespace); err == nil {
+		log.Logger.Info("deleting endpoints replaced by endpointslices", "namespace", mr.namespace, "name", targetMirrorService, "runner", mr.name)
+		if err := mr.deleteEndpoints(targetMirrorService, mr.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpoints %s/%s: %v", mr.namespace, targetMirrorService, err)
+		}
+	}
+	return nil
+}
+
+// orphanEndpointSlices returns the names of mirrored endpointslices under the
+// local namespace whose remote endpointslice does not exist or is in an
+// excluded namespace
+func (mr *MirrorRunner) orphanEndpointSlices() ([]string, error) {
+	storeEndpointSlices, err := mr.endpointSliceWatcher.List()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrorEndpointSliceList := []string{}
+	for _, es := range storeEndpointSlices {
+		if !mr.namespaceFilter.Allowed(es.Namespace) {
+			continue
+		}
+		mirrorEndpointSliceList = append(
+			mirrorEndpointSliceList,
+			generateMirrorName(mr.prefix, es.Namespace, es.Name),
+		)
+	}
+
+	currEndpointSlices, err := mr.mirrorEndpointSliceWatcher.List()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []string{}
+	for _, es := range currEndpointSlices {
+		if _, inSlice := inSlice(mirrorEndpointSliceList, es.Name); !inSlice {
+			orphans = append(orphans, es.Name)
+		}
+	}
+	return orphans, nil
+}
+
+// EndpointSliceSync checks for stale mirrors (endpointslices) under the local
+// namespace and deletes them
+func (mr *MirrorRunner) EndpointSliceSync() error {
+	orphans, err := mr.orphanEndpointSlices()
+	if err != nil {
+		return err
+	}
+	for _, name := range orphans {
+		if err := mr.deleteOrphanEndpointSlice(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mr *MirrorRunner) deleteOrphanEndpointSlice(name string) error {
+	log.Logger.Info(
+		"Deleting old endpointslice",
+		"endpointslice", name,
+		"runner", mr.name,
+	)
+	target := mr.mirrorEndpointSliceService(name)
+	if err := mr.deleteEndpointSlice(name, mr.namespace); err != nil && !errors.IsNotFound(err) {
+		log.Logger.Error(
+			"Error clearing endpointslice",
+			"endpointslice", name,
+			"err", err,
+			"runner", mr.name,
+		)
+		return err
+	}
+	if target != nil {
+		kube.EventRecorder.Eventf(target, v1.EventTypeNormal, eventReasonGarbageCollected, "Deleted orphaned endpointslice %s, its remote endpointslice is not mirrored from cluster %s anymore", name, mr.name)
+	}
+	return nil
+}
+
+// eventTarget returns the mirrored service to record events on
+func (mr *MirrorRunner) eventTarget(mirrorName string) runtime.Object {
+	svc, err := mr.mirrorServiceWatcher.Get(mirrorName, mr.namespace)
+	return serviceEventTarget(svc, err, mirrorName, mr.namespace)
+}
+
+// endpointSliceEventTarget returns the mirrored service of a remote
+// endpointslice to record events on, or nil if the service is unknown
+func (mr *MirrorRunner) endpointSliceEventTarget(mirrorName, name, namespace string) runtime.Object {
+	if es, err := mr.endpointSliceWatcher.Get(name, namespace); err == nil {
+		if svc, ok := es.Labels["kubernetes.io/service-name"]; ok {
+			return mr.eventTarget(generateMirrorName(mr.prefix, namespace, svc))
+		}
+	}
+	return mr.mirrorEndpointSliceService(mirrorName)
+}
+
+// mirrorEndpointSliceService returns the mirrored service of a mirrored
+// endpointslice to record events on, or nil if the endpointslice is unknown
+func (mr *MirrorRunner) mirrorEndpointSliceService(name string) runtime.Object {
+	es, err := mr.mirrorEndpointSliceWatcher.Get(name, mr.namespace)
+	if err != nil {
+		return nil
+	}
+	svc, ok := es.Labels["kubernetes.io/service-name"]
+	if !ok {
+		return nil
+	}
+	return mr.eventTarget(svc)
+}
+
+// deleteMirrorEndpointSlices deletes all the endpointslices created by the
+// runner
+func (mr *MirrorRunner) deleteMirrorEndpointSlices() error {
+	endpointSlices, err := mr.client.DiscoveryV1().EndpointSlices(mr.namespace).List(
+		mr.ctx,
+		metav1.ListOptions{LabelSelector: labels.Set(mr.mirrorEndpointSliceSelectorLabels()).String()},
+	)
+	if err != nil {
+		return fmt.Errorf("listing mirrored endpointslices: %v", err)
+	}
+	for _, es := range endpointSlices.Items {
+		log.Logger.Info(
+			"Deleting mirrored endpointslice",
+			"endpointslice", es.Name,
+			"runner", mr.name,
+		)
+		if err := mr.deleteEndpointSlice(es.Name, es.Namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", es.Namespace, es.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteServiceMirrorEndpointSlices deletes the endpointslices created by the
+// runner for a mirrored service
+func (mr *MirrorRunner) deleteServiceMirrorEndpointSlices(service string) error {
+	endpointSlices, err := mr.mirrorEndpointSliceWatcher.List()
+	if err != nil {
+		return fmt.Errorf("listing mirrored endpointslices: %v", err)
+	}
+	for _, es := range endpointSlices {
+		if es.Labels["kubernetes.io/service-name"] != service {
+			continue
+		}
+		log.Logger.Info("deleting endpointslice replaced by endpoints", "namespace", es.Namespace, "name", es.Name, "runner", mr.name)
+		if err := mr.deleteEndpointSlice(es.Name, es.Namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting endpointslice %s/%s: %v", es.Namespace, es.Name, err)
+		}
+	}
+	return nil
+}
+
+func (mr *MirrorRunner) getEndpointSlice(name, namespace string) (*discoveryv1.EndpointSlice, error) {
+	return mr.client.DiscoveryV1().EndpointSlices(namespace).Get(
+		mr.ctx,
+		name,
+		metav1.GetOptions{},
+	)
+}
+
+func (mr *MirrorRunner) createEndpointSlice(name, namespace, targetService string, at discoveryv1.AddressType, endpoints []discoveryv1.Endpoint, ports []discoveryv1.EndpointPort) (*discoveryv1.EndpointSlice, error) {
+	return mr.client.DiscoveryV1().EndpointSlices(namespace).Create(
+		mr.ctx,
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          generateEndpointSliceLabels(mr.mirrorLabels, targetService),
+				OwnerReferences: mr.ownerReferences(targetService),
+			},
+			AddressType: at,
+			Endpoints:   stripEndpointHints(endpoints),
+			Ports:       ports,
+		},
+		metav1.CreateOptions{},
+	)
+}
+
+func (mr *MirrorRunner) updateEndpointSlice(name, namespace, targetService string, at discoveryv1.AddressType, endpoints []discoveryv1.Endpoint, ports []discoveryv1.EndpointPort) (*discoveryv1.EndpointSlice, error) {
+	return mr.client.DiscoveryV1().EndpointSlices(namespace).Update(
+		mr.ctx,
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          generateEndpointSliceLabels(mr.mirrorLabels, targetService),
+				OwnerReferences: mr.ownerReferences(targetService),
+			},
+			AddressType: at,
+			Endpoints:   stripEndpointHints(endpoints),
+			Ports:       ports,
+		},
+		metav1.UpdateOptions{},
+	)
+}
+
+func (mr *MirrorRunner) deleteEndpointSlice(name, namespace string) error {
+	return mr.client.DiscoveryV1().EndpointSlices(namespace).Delete(
+		mr.ctx,
+		name,
+		metav1.DeleteOptions{},
+	)
+}
+
+// EndpointSliceEventHandler adds EndpointSlice resource events to the respective queue
+func (mr *MirrorRunner) EndpointSliceEventHandler(eventType watch.EventType, old *discoveryv1.EndpointSlice, new *discoveryv1.EndpointSlice) {
+	if namespace := eventNamespace(eventType, old, new); !mr.namespaceFilter.Allowed(namespace) {
+		log.Logger.Debug("skipping endpointslice event from excluded namespace", "namespace", namespace, "runner", mr.name)
+		return
+	}
+	switch eventType {
+	case watch.Added:
+		log.Logger.Debug("endpointslice added", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
+		mr.endpointSliceQueue.Add(new)
+		mr.queueStatus(new.Labels["kubernetes.io/service-name"], new.Namespace)
+	case watch.Modified:
+		log.Logger.Debug("endpointslice modified", "namespace", new.Namespace, "name", new.Name, "runner", mr.name)
+		mr.endpointSliceQueue.Add(new)
+		mr.queueStatus(new.Labels["kubernetes.io/service-name"], new.Namespace)
+	case watch.Deleted:
+		log.Logger.Debug("endpointslice deleted", "namespace", old.Namespace, "name", old.Name, "runner", mr.name)
+		mr.endpointSliceQueue.Add(old)
+		mr.queueStatus(old.Labels["kubernetes.io/service-name"], old.Namespace)
+	default:
+		log.Logger.Info("Unknown endpointslice event received: %v", eventType, "runner", mr.name)
+	}
+}
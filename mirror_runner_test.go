@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
 	"github.com/utilitywarehouse/semaphore-service-mirror/log"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var testMirrorLabels = map[string]string{
@@ -41,16 +48,16 @@ func TestAddService(t *testing.T) {
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
 
-	testRunner := newMirrorRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		"prefix",
-		"uw.systems/test=true",
-		60*time.Minute,
-		true,
-	)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -96,16 +103,16 @@ func TestAddHeadlessService(t *testing.T) {
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
 
-	testRunner := newMirrorRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		"prefix",
-		"uw.systems/test=true",
-		60*time.Minute,
-		true,
-	)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -162,16 +169,16 @@ func TestModifyService(t *testing.T) {
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
 
-	testRunner := newMirrorRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		"prefix",
-		"uw.systems/test=true",
-		60*time.Minute,
-		true,
-	)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -201,8 +208,9 @@ func TestModifyServiceNoChange(t *testing.T) {
 	existingPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
 	existingSvc := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
-			Namespace: "local-ns",
+			Name:        fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+			Namespace:   "local-ns",
+			Annotations: generateMirrorAnnotations("test-svc", "remote-ns"),
 		},
 		Spec: v1.ServiceSpec{
 			Ports:     existingPorts,
@@ -225,16 +233,16 @@ func TestModifyServiceNoChange(t *testing.T) {
 	}
 	fakeWatchClient := fake.NewSimpleClientset(testSvc)
 
-	testRunner := newMirrorRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		"prefix",
-		"uw.systems/test=true",
-		60*time.Minute,
-		true,
-	)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
 	go testRunner.serviceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
 
@@ -252,6 +260,81 @@ func TestModifyServiceNoChange(t *testing.T) {
 	assert.Equal(t, *existingSvc, svcs.Items[0])
 }
 
+func TestModifyServiceRecreate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	recorder := record.NewFakeRecorder(10)
+	kube.EventRecorder = recorder
+	defer func() { kube.EventRecorder = &record.FakeRecorder{} }()
+
+	existingPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
+	existingSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+			Namespace:   "local-ns",
+			Labels:      testMirrorLabels,
+			Annotations: generateMirrorAnnotations("test-svc", "remote-ns"),
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     existingPorts,
+			ClusterIP: "10.0.0.1",
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(existingSvc)
+
+	// A ClusterIP service that became headless and publishes not ready
+	// addresses
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:                    existingPorts,
+			Selector:                 map[string]string{"selector": "x"},
+			ClusterIP:                "None",
+			PublishNotReadyAddresses: true,
+		},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.mirrorServiceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+	// Drain the endpoints queued for the existing mirror
+	assert.Eventually(t, func() bool { return testRunner.endpointsQueue.queue.Len() == 1 }, time.Second, 10*time.Millisecond)
+	processQueue(testRunner.endpointsQueue)
+
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, existingSvc.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "None", svc.Spec.ClusterIP)
+	assert.Equal(t, true, svc.Spec.PublishNotReadyAddresses)
+	assert.Equal(t, testMirrorLabels, svc.Labels)
+	assert.Equal(t, generateMirrorAnnotations("test-svc", "remote-ns"), svc.Annotations)
+	// The mirrored endpoints are deleted with the service, they are
+	// reconciled again once the new service is seen
+	assert.Eventually(t, func() bool { return testRunner.endpointsQueue.queue.Len() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Normal Recreated Recreated service to change immutable fields (headless)", <-recorder.Events)
+}
+
 func TestServiceSync(t *testing.T) {
 	ctx := context.Background()
 
@@ -303,16 +386,16 @@ func TestServiceSync(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	testRunner := newMirrorRunner(
-		fakeClient,
-		fakeWatchClient,
-		"test-runner",
-		"local-ns",
-		"prefix",
-		"uw.systems/test=true",
-		60*time.Minute,
-		true,
-	)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
 	go testRunner.serviceWatcher.Run()
 	go testRunner.mirrorServiceWatcher.Run()
 	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
@@ -336,3 +419,586 @@ func TestServiceSync(t *testing.T) {
 		svcs.Items[0].Name,
 	)
 }
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
+	// Service mirrored by the runner
+	mirroredSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports:    testPorts,
+			Selector: nil,
+		},
+	}
+	// Service mirrored from a different cluster
+	otherSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("other-remote-ns-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels: map[string]string{
+				"mirrored-svc":           "true",
+				"mirror-svc-prefix-sync": "other",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:    testPorts,
+			Selector: nil,
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(mirroredSvc, otherSvc)
+	fakeWatchClient := fake.NewSimpleClientset()
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
+	if err := testRunner.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	svcs, err := fakeClient.CoreV1().Services("").List(
+		ctx,
+		metav1.ListOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(svcs.Items))
+	assert.Equal(
+		t,
+		fmt.Sprintf("other-remote-ns-%s-test-svc", Separator),
+		svcs.Items[0].Name,
+	)
+}
+
+func TestMirrorEndpointSlice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPort := int32(80)
+	testEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc-abcde",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"kubernetes.io/service-name": "test-svc",
+				"uw.systems/test":            "true",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			discoveryv1.Endpoint{
+				Addresses: []string{"10.0.0.1"},
+				Hints: &discoveryv1.EndpointHints{
+					ForZones: []discoveryv1.ForZone{discoveryv1.ForZone{Name: "remote-zone"}},
+				},
+			},
+		},
+		Ports: []discoveryv1.EndpointPort{discoveryv1.EndpointPort{Port: &testPort}},
+	}
+	// Endpoints left over from the endpoints mirroring mode
+	mirrorName := fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator)
+	legacyEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mirrorName,
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(legacyEndpoints)
+	fakeWatchClient := fake.NewSimpleClientset(testEndpointSlice)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:         fakeClient,
+		watchClient:    fakeWatchClient,
+		name:           "test-runner",
+		namespace:      "local-ns",
+		prefix:         "prefix",
+		labelSelector:  "uw.systems/test=true",
+		resyncPeriod:   60 * time.Minute,
+		sync:           true,
+		endpointSlices: true,
+	})
+	go testRunner.endpointSliceWatcher.Run()
+	go testRunner.mirrorEndpointsWatcher.Run()
+	cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorEndpointsWatcher", ctx.Done(), testRunner.mirrorEndpointsWatcher.HasSynced)
+
+	// Test create - should create a mirror endpointslice targeting the
+	// mirrored service, without topology hints, and delete the old endpoints
+	if err := testRunner.reconcileEndpointSlice("test-svc-abcde", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	mirrorSliceName := fmt.Sprintf("prefix-remote-ns-%s-test-svc-abcde", Separator)
+	es, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, mirrorSliceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mirrorName, es.Labels["kubernetes.io/service-name"])
+	assert.Equal(t, "semaphore-service-mirror", es.Labels["endpointslice.kubernetes.io/managed-by"])
+	assert.Equal(t, "prefix", es.Labels["mirror-svc-prefix-sync"])
+	assert.Equal(t, 1, len(es.Endpoints))
+	assert.Equal(t, []string{"10.0.0.1"}, es.Endpoints[0].Addresses)
+	assert.Nil(t, es.Endpoints[0].Hints)
+	assert.Equal(t, testEndpointSlice.Ports, es.Ports)
+	eps, err := fakeClient.CoreV1().Endpoints("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(eps.Items))
+
+	// Test delete - removing the remote endpointslice should remove the
+	// mirror
+	if err := fakeWatchClient.DiscoveryV1().EndpointSlices("remote-ns").Delete(ctx, "test-svc-abcde", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		_, err := testRunner.endpointSliceWatcher.Get("test-svc-abcde", "remote-ns")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	if err := testRunner.reconcileEndpointSlice("test-svc-abcde", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	slices, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(slices.Items))
+}
+
+func TestMirrorEndpointSliceSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc-abcde",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"kubernetes.io/service-name": "test-svc",
+				"uw.systems/test":            "true",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	mirrorLabels := generateEndpointSliceLabels(testMirrorLabels, fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator))
+	mirrorEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc-abcde", Separator),
+			Namespace: "local-ns",
+			Labels:    mirrorLabels,
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	staleEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-old-svc-fghij", Separator),
+			Namespace: "local-ns",
+			Labels:    mirrorLabels,
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	// Created by kube-controller-manager for the mirrored endpoints, should
+	// not be touched
+	kubeMirrorEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-old-svc-klmno", Separator),
+			Namespace: "local-ns",
+			Labels: map[string]string{
+				"mirrored-svc":                           "true",
+				"mirror-svc-prefix-sync":                 "prefix",
+				"endpointslice.kubernetes.io/managed-by": "endpointslicemirroring-controller.k8s.io",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	fakeClient := fake.NewSimpleClientset(mirrorEndpointSlice, staleEndpointSlice, kubeMirrorEndpointSlice)
+	fakeWatchClient := fake.NewSimpleClientset(testEndpointSlice)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:         fakeClient,
+		watchClient:    fakeWatchClient,
+		name:           "test-runner",
+		namespace:      "local-ns",
+		prefix:         "prefix",
+		labelSelector:  "uw.systems/test=true",
+		resyncPeriod:   60 * time.Minute,
+		sync:           true,
+		endpointSlices: true,
+	})
+	go testRunner.endpointSliceWatcher.Run()
+	go testRunner.mirrorEndpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorEndpointSliceWatcher", ctx.Done(), testRunner.mirrorEndpointSliceWatcher.HasSynced)
+
+	if err := testRunner.EndpointSliceSync(); err != nil {
+		t.Fatal(err)
+	}
+	slices, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, es := range slices.Items {
+		names = append(names, es.Name)
+	}
+	assert.ElementsMatch(t, []string{mirrorEndpointSlice.Name, kubeMirrorEndpointSlice.Name}, names)
+}
+
+func TestMirrorEndpointsDeletesEndpointSlices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Subsets: []v1.EndpointSubset{
+			v1.EndpointSubset{Addresses: []v1.EndpointAddress{v1.EndpointAddress{IP: "10.0.0.1"}}},
+		},
+	}
+	// Endpointslice left over from the endpointslice mirroring mode
+	mirrorName := fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator)
+	mirrorEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc-abcde", Separator),
+			Namespace: "local-ns",
+			Labels:    generateEndpointSliceLabels(testMirrorLabels, mirrorName),
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	fakeClient := fake.NewSimpleClientset(mirrorEndpointSlice)
+	fakeWatchClient := fake.NewSimpleClientset(testEndpoints)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
+	go testRunner.endpointsWatcher.Run()
+	go testRunner.mirrorEndpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), testRunner.endpointsWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorEndpointSliceWatcher", ctx.Done(), testRunner.mirrorEndpointSliceWatcher.HasSynced)
+
+	if err := testRunner.reconcileEndpoints("test-svc", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	eps, err := fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testEndpoints.Subsets, eps.Subsets)
+	slices, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(slices.Items))
+}
+
+func TestMirrorLookup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	longName := strings.Repeat("a", 60)
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      longName,
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{v1.ServicePort{Port: 1}},
+		},
+	}
+	// Mirror created before the remote name annotations were introduced
+	legacySvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-legacy-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(legacySvc)
+	fakeWatchClient := fake.NewSimpleClientset(testSvc)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+		sync:          true,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.mirrorServiceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+
+	if err := testRunner.reconcileService(longName, "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	mirrorName := generateMirrorName("prefix", "remote-ns", longName)
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, longName, svc.Annotations[mirrorSvcRemoteNameAnno])
+	assert.Equal(t, "remote-ns", svc.Annotations[mirrorSvcRemoteNamespaceAnno])
+
+	assert.Eventually(t, func() bool {
+		_, _, ok := testRunner.Lookup(mirrorName)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	namespace, name, _ := testRunner.Lookup(mirrorName)
+	assert.Equal(t, "remote-ns", namespace)
+	assert.Equal(t, longName, name)
+
+	namespace, name, ok := testRunner.Lookup(legacySvc.Name)
+	assert.True(t, ok)
+	assert.Equal(t, "remote-ns", namespace)
+	assert.Equal(t, "legacy-svc", name)
+
+	_, _, ok = testRunner.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestMirrorExcludedNamespaces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPorts := []v1.ServicePort{v1.ServicePort{Port: 1}}
+	// Services on the remote cluster
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	tenantSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "tenant-a",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc, tenantSvc)
+
+	// Mirrors of both services, created before the namespace was excluded
+	mirroredSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	excludedSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prefix-tenant-a-%s-test-svc", Separator),
+			Namespace: "local-ns",
+			Labels:    testMirrorLabels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: testPorts,
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(mirroredSvc, excludedSvc)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:          fakeClient,
+		watchClient:     fakeWatchClient,
+		name:            "test-runner",
+		namespace:       "local-ns",
+		prefix:          "prefix",
+		labelSelector:   "uw.systems/test=true",
+		namespaceFilter: newNamespaceFilter(nil, []string{"tenant-*"}),
+		resyncPeriod:    60 * time.Minute,
+		sync:            true,
+	})
+
+	// Events from excluded namespaces are not queued
+	testRunner.ServiceEventHandler(watch.Added, nil, tenantSvc)
+	assert.Equal(t, 0, testRunner.serviceQueue.queue.Len())
+	testRunner.ServiceEventHandler(watch.Added, nil, testSvc)
+	assert.Equal(t, 1, testRunner.serviceQueue.queue.Len())
+
+	go testRunner.serviceWatcher.Run()
+	go testRunner.mirrorServiceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("mirrorServiceWatcher", ctx.Done(), testRunner.mirrorServiceWatcher.HasSynced)
+
+	// Mirrors from the excluded namespace are stale
+	if err := testRunner.ServiceSync(); err != nil {
+		t.Fatal(err)
+	}
+	svcs, err := fakeClient.CoreV1().Services("").List(
+		ctx,
+		metav1.ListOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(svcs.Items))
+	assert.Equal(
+		t,
+		fmt.Sprintf("prefix-remote-ns-%s-test-svc", Separator),
+		svcs.Items[0].Name,
+	)
+}
+
+func TestMirrorLoadBalancerAndExternalNameServices(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	fakeClient := fake.NewSimpleClientset()
+
+	lbSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "lb-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Type:     v1.ServiceTypeLoadBalancer,
+			Ports:    []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}},
+			Selector: map[string]string{"selector": "x"},
+		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "1.1.1.1"}}},
+		},
+	}
+	lbEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "lb-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []v1.EndpointPort{{Name: "http", Port: 8080}},
+		}},
+	}
+	externalNameSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ext-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: "example.com",
+		},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(lbSvc, lbEndpoints, externalNameSvc)
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:              fakeClient,
+		watchClient:         fakeWatchClient,
+		name:                "test-runner",
+		namespace:           "local-ns",
+		prefix:              "prefix",
+		labelSelector:       "uw.systems/test=true",
+		resyncPeriod:        60 * time.Minute,
+		loadBalancerIngress: true,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.endpointsWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), testRunner.endpointsWatcher.HasSynced)
+
+	// The mirror of the load balancer service points at the ingress IPs
+	lbMirrorName := generateMirrorName("prefix", "remote-ns", "lb-svc")
+	assert.Equal(t, nil, testRunner.reconcileService("lb-svc", "remote-ns"))
+	assert.Equal(t, nil, testRunner.reconcileEndpoints("lb-svc", "remote-ns"))
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, lbMirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v1.ServiceType(""), svc.Spec.Type)
+	assert.Equal(t, []v1.ServicePort{{Name: "http", Port: 80}}, svc.Spec.Ports)
+	endpoints, err := fakeClient.CoreV1().Endpoints("local-ns").Get(ctx, lbMirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, loadBalancerSubsets(lbSvc), endpoints.Subsets)
+
+	// ExternalName services are mirrored as ExternalName services
+	extMirrorName := generateMirrorName("prefix", "remote-ns", "ext-svc")
+	assert.Equal(t, nil, testRunner.reconcileService("ext-svc", "remote-ns"))
+	svc, err = fakeClient.CoreV1().Services("local-ns").Get(ctx, extMirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v1.ServiceTypeExternalName, svc.Spec.Type)
+	assert.Equal(t, "example.com", svc.Spec.ExternalName)
+}
+
+func TestMirrorRunnerInitialisedAfterCacheSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	fakeClient := fake.NewSimpleClientset()
+	fakeWatchClient := fake.NewSimpleClientset()
+	// Hold the remote endpoints list until released
+	release := make(chan struct{})
+	fakeWatchClient.PrependReactor("list", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return false, nil, nil
+	})
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		resyncPeriod:  60 * time.Minute,
+	})
+	done := make(chan error)
+	go func() { done <- testRunner.Run(ctx) }()
+
+	assert.Never(t, testRunner.Initialised, 200*time.Millisecond, 10*time.Millisecond)
+	close(release)
+	assert.Eventually(t, testRunner.Initialised, time.Second, 10*time.Millisecond)
+
+	// Wait for the runner to give up waiting for leadership, so that it does
+	// not log after the test
+	cancel()
+	assert.NotEqual(t, nil, <-done)
+}
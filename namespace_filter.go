@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// namespaceFilter decides which remote namespaces are mirrored, based on lists
+// of namespace names or glob patterns. A namespace is allowed when it matches
+// an include pattern, or there are no include patterns, and does not match any
+// exclude pattern. A nil filter allows all namespaces.
+type namespaceFilter struct {
+	include []string
+	exclude []string
+}
+
+func newNamespaceFilter(include, exclude []string) *namespaceFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	return &namespaceFilter{
+		include: include,
+		exclude: exclude,
+	}
+}
+
+// validateNamespacePatterns errors if any of the patterns is malformed
+func validateNamespacePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if objects in the namespace should be mirrored
+func (f *namespaceFilter) Allowed(namespace string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchNamespace(f.include, namespace) {
+		return false
+	}
+	return !matchNamespace(f.exclude, namespace)
+}
+
+// matchNamespace returns true if the namespace matches any of the patterns.
+// Patterns are validated when the config is loaded, so errors are ignored.
+func matchNamespace(patterns []string, namespace string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// eventNamespace returns the namespace of the object of a watcher event
+func eventNamespace[T metav1.Object](eventType watch.EventType, old, new T) string {
+	if eventType == watch.Deleted {
+		return old.GetNamespace()
+	}
+	return new.GetNamespace()
+}
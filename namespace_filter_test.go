@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceFilterAllowed(t *testing.T) {
+	testCases := []struct {
+		include   []string
+		exclude   []string
+		namespace string
+		allowed   bool
+	}{
+		{nil, nil, "any", true},
+		{[]string{"team-*"}, nil, "team-a", true},
+		{[]string{"team-*"}, nil, "other", false},
+		{nil, []string{"kube-system", "tenant-*"}, "tenant-a", false},
+		{nil, []string{"kube-system", "tenant-*"}, "kube-system", false},
+		{nil, []string{"kube-system", "tenant-*"}, "team-a", true},
+		// Exclude takes precedence over include
+		{[]string{"team-*"}, []string{"team-secret"}, "team-secret", false},
+		{[]string{"team-*"}, []string{"team-secret"}, "team-a", true},
+	}
+	for _, tc := range testCases {
+		f := newNamespaceFilter(tc.include, tc.exclude)
+		assert.Equal(t, tc.allowed, f.Allowed(tc.namespace), "include=%v exclude=%v namespace=%s", tc.include, tc.exclude, tc.namespace)
+	}
+}
+
+func TestValidateNamespacePatterns(t *testing.T) {
+	assert.Equal(t, nil, validateNamespacePatterns([]string{"team-*", "sys-?", "[ab]-ns"}))
+	assert.NotEqual(t, nil, validateNamespacePatterns([]string{"team-["}))
+}
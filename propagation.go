@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations that record the keys copied from the remote service, so that
+// keys that are no longer propagated can be removed without touching keys set
+// by others
+const (
+	propagatedLabelsAnno      = "mirror-svc-propagated-labels"
+	propagatedAnnotationsAnno = "mirror-svc-propagated-annotations"
+)
+
+// propagationConfig selects the labels and annotations of remote services to
+// copy onto mirrored and global services. Entries are regular expressions that
+// must match the whole key, so plain keys match only themselves.
+type propagationConfig struct {
+	Labels      []string `json:"labels"`
+	Annotations []string `json:"annotations"`
+}
+
+// propagationRules holds the compiled rules of one or more propagation configs.
+// A nil propagationRules propagates nothing.
+type propagationRules struct {
+	labels      []*regexp.Regexp
+	annotations []*regexp.Regexp
+}
+
+// validatePropagationConfig checks that all the rules are valid regular
+// expressions
+func validatePropagationConfig(c propagationConfig) error {
+	_, err := newPropagationRules(c)
+	return err
+}
+
+// newPropagationRules compiles the rules of all the passed configs. Returns nil
+// if there are no rules.
+func newPropagationRules(configs ...propagationConfig) (*propagationRules, error) {
+	rules := &propagationRules{}
+	for _, c := range configs {
+		labels, err := compileKeyRules(c.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label rule: %v", err)
+		}
+		annotations, err := compileKeyRules(c.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation rule: %v", err)
+		}
+		rules.labels = append(rules.labels, labels...)
+		rules.annotations = append(rules.annotations, annotations...)
+	}
+	if len(rules.labels) == 0 && len(rules.annotations) == 0 {
+		return nil, nil
+	}
+	return rules, nil
+}
+
+func compileKeyRules(rules []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(rules))
+	for _, r := range rules {
+		re, err := regexp.Compile("^(?:" + r + ")$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Labels returns the labels to copy from a remote service
+func (r *propagationRules) Labels(labels map[string]string) map[string]string {
+	if r == nil {
+		return map[string]string{}
+	}
+	return selectKeys(labels, r.labels)
+}
+
+// Annotations returns the annotations to copy from a remote service
+func (r *propagationRules) Annotations(annotations map[string]string) map[string]string {
+	if r == nil {
+		return map[string]string{}
+	}
+	return selectKeys(annotations, r.annotations)
+}
+
+func selectKeys(in map[string]string, rules []*regexp.Regexp) map[string]string {
+	out := map[string]string{}
+	for k, v := range in {
+		for _, re := range rules {
+			if re.MatchString(k) {
+				out[k] = v
+				break
+			}
+		}
+	}
+	return out
+}
+
+// propagateMetadata copies the propagated labels and annotations onto the
+// metadata of a mirrored or global service. Keys copied by a previous reconcile
+// that are no longer propagated are removed, other keys are left alone. Keys in
+// ownedLabels and ownedAnnotations belong to the controller and are never
+// copied, they are set after propagation by the caller.
+func propagateMetadata(meta *metav1.ObjectMeta, labels, annotations, ownedLabels, ownedAnnotations map[string]string) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+		// Keep a service without labels as it is when there is nothing
+		// to propagate
+		defer func() {
+			if len(meta.Labels) == 0 {
+				meta.Labels = nil
+			}
+		}()
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	for _, k := range propagatedKeys(meta.Annotations[propagatedLabelsAnno]) {
+		if _, ok := ownedLabels[k]; !ok {
+			delete(meta.Labels, k)
+		}
+	}
+	for _, k := range propagatedKeys(meta.Annotations[propagatedAnnotationsAnno]) {
+		if _, ok := ownedAnnotations[k]; !ok {
+			delete(meta.Annotations, k)
+		}
+	}
+	copiedLabels := copyKeys(meta.Labels, labels, ownedLabels)
+	copiedAnnotations := copyKeys(meta.Annotations, annotations, ownedAnnotations)
+	setPropagatedKeys(meta.Annotations, propagatedLabelsAnno, copiedLabels)
+	setPropagatedKeys(meta.Annotations, propagatedAnnotationsAnno, copiedAnnotations)
+}
+
+// copyKeys copies keys from src to dst, skipping owned keys and the propagation
+// bookkeeping annotations. Returns the sorted copied keys.
+func copyKeys(dst, src, owned map[string]string) []string {
+	copied := []string{}
+	for k, v := range src {
+		if _, ok := owned[k]; ok || k == propagatedLabelsAnno || k == propagatedAnnotationsAnno {
+			continue
+		}
+		dst[k] = v
+		copied = append(copied, k)
+	}
+	sort.Strings(copied)
+	return copied
+}
+
+func propagatedKeys(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func setPropagatedKeys(annotations map[string]string, anno string, keys []string) {
+	if len(keys) == 0 {
+		delete(annotations, anno)
+		return
+	}
+	annotations[anno] = strings.Join(keys, ",")
+}
+
+// mergeMetadata returns the union of the passed labels or annotations. The
+// first map that sets a key wins.
+func mergeMetadata(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			if _, ok := merged[k]; !ok {
+				merged[k] = v
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func TestNewPropagationRules(t *testing.T) {
+	rules, err := newPropagationRules(propagationConfig{}, propagationConfig{})
+	assert.Equal(t, nil, err)
+	assert.Nil(t, rules)
+	assert.Equal(t, map[string]string{}, rules.Labels(map[string]string{"team": "a"}))
+
+	rules, err = newPropagationRules(
+		propagationConfig{Labels: []string{"team"}},
+		propagationConfig{Labels: []string{"app\\.kubernetes\\.io/.*"}, Annotations: []string{"example.com/owner"}},
+	)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{
+		"team":                   "a",
+		"app.kubernetes.io/name": "svc",
+	}, rules.Labels(map[string]string{
+		"team":                   "a",
+		"teams":                  "b",
+		"app.kubernetes.io/name": "svc",
+		"other":                  "c",
+	}))
+	assert.Equal(t, map[string]string{"example.com/owner": "a"}, rules.Annotations(map[string]string{
+		"example.com/owner": "a",
+		"example.com/other": "b",
+	}))
+
+	_, err = newPropagationRules(propagationConfig{Annotations: []string{"("}})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPropagateMetadata(t *testing.T) {
+	meta := &metav1.ObjectMeta{
+		Labels:      map[string]string{"mirrored-svc": "true", "foreign": "x"},
+		Annotations: map[string]string{"foreign": "y"},
+	}
+	ownedLabels := map[string]string{"mirrored-svc": "true"}
+	ownedAnnotations := map[string]string{mirrorSvcRemoteNameAnno: "svc"}
+
+	propagateMetadata(
+		meta,
+		map[string]string{"team": "a", "mirrored-svc": "false"},
+		map[string]string{"owner": "b", mirrorSvcRemoteNameAnno: "other"},
+		ownedLabels,
+		ownedAnnotations,
+	)
+	assert.Equal(t, map[string]string{"mirrored-svc": "true", "foreign": "x", "team": "a"}, meta.Labels)
+	assert.Equal(t, map[string]string{
+		"foreign":                 "y",
+		"owner":                   "b",
+		propagatedLabelsAnno:      "team",
+		propagatedAnnotationsAnno: "owner",
+	}, meta.Annotations)
+
+	// Keys that are no longer propagated are removed, foreign keys are kept
+	propagateMetadata(meta, map[string]string{"env": "prod"}, nil, ownedLabels, ownedAnnotations)
+	assert.Equal(t, map[string]string{"mirrored-svc": "true", "foreign": "x", "env": "prod"}, meta.Labels)
+	assert.Equal(t, map[string]string{
+		"foreign":            "y",
+		propagatedLabelsAnno: "env",
+	}, meta.Annotations)
+}
+
+func TestMirrorServicePropagation(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	fakeClient := fake.NewSimpleClientset()
+
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"uw.systems/test": "true",
+				"team":            "a",
+				"mirrored-svc":    "false",
+			},
+			Annotations: map[string]string{
+				"example.com/owner": "b",
+				"example.com/other": "c",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Port: 80}},
+		},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc)
+
+	propagation, err := newPropagationRules(propagationConfig{
+		Labels:      []string{"team", "mirrored-svc"},
+		Annotations: []string{"example\\.com/owner"},
+	})
+	assert.Equal(t, nil, err)
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		propagation:   propagation,
+		resyncPeriod:  60 * time.Minute,
+	})
+	go testRunner.serviceWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+
+	mirrorName := generateMirrorName("prefix", "remote-ns", "test-svc")
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	svc, err := fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{
+		"mirrored-svc":           "true",
+		"mirror-svc-prefix-sync": "prefix",
+		"team":                   "a",
+	}, svc.Labels)
+	assert.Equal(t, "b", svc.Annotations["example.com/owner"])
+	assert.NotContains(t, svc.Annotations, "example.com/other")
+	assert.Equal(t, "test-svc", svc.Annotations[mirrorSvcRemoteNameAnno])
+
+	// Dropping a label from the remote service removes it from the mirror
+	testSvc.Labels = map[string]string{"uw.systems/test": "true"}
+	_, err = fakeWatchClient.CoreV1().Services("remote-ns").Update(ctx, testSvc, metav1.UpdateOptions{})
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		remoteSvc, err := testRunner.getRemoteService("test-svc", "remote-ns")
+		return err == nil && remoteSvc.Labels["team"] == ""
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	svc, err = fakeClient.CoreV1().Services("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{
+		"mirrored-svc":           "true",
+		"mirror-svc-prefix-sync": "prefix",
+	}, svc.Labels)
+	assert.Equal(t, "b", svc.Annotations["example.com/owner"])
+}
+
+func TestGlobalServicePropagation(t *testing.T) {
+	store := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "local")
+	svc := createTestService("name", "namespace", "1.1.1.1", []int32{80})
+	store.AddOrUpdateClusterServiceTarget(svc, "a", false, map[string]string{"team": "a"}, map[string]string{"owner": "a"})
+	gsvc := store.AddOrUpdateClusterServiceTarget(svc, "b", false, map[string]string{"team": "b", "env": "prod"}, nil)
+	assert.Equal(t, map[string]string{"team": "a", "env": "prod"}, gsvc.propagatedLabels)
+	assert.Equal(t, map[string]string{"owner": "a"}, gsvc.propagatedAnnotations)
+
+	meta := globalServiceMetadata(&metav1.ObjectMeta{Labels: mergeMetadata(gsvc.labels)}, gsvc)
+	assert.Equal(t, map[string]string{"global-svc": "true", "team": "a", "env": "prod"}, meta.Labels)
+	assert.Equal(t, "a", meta.Annotations["owner"])
+	assert.Equal(t, "a,b", meta.Annotations[globalSvcClustersAnno])
+
+	gsvc = store.DeleteClusterServiceTarget("name", "namespace", "a")
+	meta = globalServiceMetadata(meta, gsvc)
+	assert.Equal(t, map[string]string{"global-svc": "true", "team": "b", "env": "prod"}, meta.Labels)
+	assert.NotContains(t, meta.Annotations, "owner")
+	assert.NotContains(t, meta.Annotations, propagatedAnnotationsAnno)
+	assert.Equal(t, "b", meta.Annotations[globalSvcClustersAnno])
+}
@@ -47,9 +47,10 @@ func (q *queue) Run() {
 	}
 }
 
-// Stop causes the queue to shut down
+// Stop causes the queue to shut down. No new items are accepted and Stop blocks
+// until the item that is currently being processed, if any, is done.
 func (q *queue) Stop() {
-	q.queue.ShutDown()
+	q.queue.ShutDownWithDrain()
 }
 
 // processItem processes the next item in the queue
@@ -61,6 +62,13 @@ func (q *queue) processItem() bool {
 	}
 	defer q.queue.Done(key)
 
+	// Do not start reconciling items that remained in the queue after
+	// shutdown was requested
+	if q.queue.ShuttingDown() {
+		log.Logger.Info("queue shutdown, dropping item", "queue", q.name, "key", key)
+		return false
+	}
+
 	namespace, name, err := cache.SplitMetaNamespaceKey(key.(string))
 	if err != nil {
 		log.Logger.Error(
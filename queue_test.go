@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQueueStop_WaitsInFlightAndDropsPending(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	reconciled := []string{}
+	q := newQueue("test-queue", func(name, namespace string) error {
+		if name == "first" {
+			close(started)
+			<-release
+		}
+		reconciled = append(reconciled, name)
+		return nil
+	})
+	q.Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "ns"}})
+	q.Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "ns"}})
+	done := make(chan struct{})
+	go func() {
+		q.Run()
+		close(done)
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+	// Stop should block while the first item is being reconciled
+	select {
+	case <-stopped:
+		t.Fatal("queue stopped before in-flight item finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	<-done
+	// The pending item should be dropped and new items ignored
+	q.Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "third", Namespace: "ns"}})
+	assert.Equal(t, []string{"first"}, reconciled)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+// recreateService deletes a local service and creates it again, to apply a
+// change of immutable fields that kube.UpdateService refused. The recreation is
+// logged, counted and recorded as an event on the new service.
+func recreateService(ctx context.Context, client kubernetes.Interface, runner string, service *v1.Service, labels, annotations map[string]string, spec v1.ServiceSpec) (*v1.Service, error) {
+	reason := kube.ServiceRecreateReason(service, spec)
+	log.Logger.Info("immutable service fields changed, recreating service", "namespace", service.Namespace, "name", service.Name, "reason", reason, "runner", runner)
+	// The finalizer would hold the deletion and the service could not be
+	// created again. The dependents of the old service are owned by the new
+	// one when they are reconciled next, or mirrored again if the garbage
+	// collector deleted them first. The runners requeue them when they see
+	// the new service.
+	if _, err := kube.SetServiceFinalizer(ctx, client, service, serviceFinalizer, false); err != nil {
+		return nil, fmt.Errorf("removing finalizer: %v", err)
+	}
+	svc, err := kube.RecreateService(ctx, client, service, labels, annotations, spec)
+	if err != nil {
+		return nil, err
+	}
+	metrics.IncServiceRecreations(runner, reason)
+	kube.EventRecorder.Eventf(svc, v1.EventTypeNormal, eventReasonRecreated, "Recreated service to change immutable fields (%s)", reason)
+	return svc, nil
+}
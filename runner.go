@@ -1,9 +1,13 @@
 package main
 
+import "context"
+
 // Runner interface must implement Run(), Stop() and Initialised() for main
-// to be able to orchestrate all runners actions.
+// to be able to orchestrate all runners actions. The context passed to Run is
+// used for all the calls the runner makes to the Kubernetes API, cancelling it
+// cancels any outstanding calls.
 type Runner interface {
-	Run() error
+	Run(ctx context.Context) error
 	Stop()
 	Initialised() bool
 }
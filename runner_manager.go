@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/backoff"
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+// remoteRunners groups the runners that serve a single remote cluster
+type remoteRunners struct {
+	config *remoteClusterConfig
+	client *kube.Clientset // Client of the remote cluster, nil in tests
+	mirror *MirrorRunner
+	global *GlobalRunner
+	cancel context.CancelFunc
+}
+
+// start runs the runners until they are stopped, under a context derived from
+// the passed one. The remote client refreshes its CA bundle under the same
+// context.
+func (r *remoteRunners) start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	if r.client != nil {
+		go r.client.Run(ctx)
+	}
+	go func() { backoff.Retry(ctx, func() error { return r.mirror.Run(ctx) }, "start mirror runner") }()
+	go func() { backoff.Retry(ctx, func() error { return r.global.Run(ctx) }, "start global runner") }()
+}
+
+// stop stops the runners and waits for their in-flight work to finish
+func (r *remoteRunners) stop() {
+	var wg sync.WaitGroup
+	wg.Go(r.mirror.Stop)
+	wg.Go(r.global.Stop)
+	wg.Wait()
+}
+
+// runnerManager keeps track of the running runners and starts, restarts or
+// stops remote cluster runners when the list of remote clusters changes.
+type runnerManager struct {
+	mu                   sync.Mutex
+	ctx                  context.Context
+	homeClient           kubernetes.Interface
+	homeDynamicClient    dynamic.Interface // Client for ServiceImports and status resources in the local cluster
+	global               globalConfig
+	globalServiceStore   *GlobalServiceStore
+	routingStrategyLabel labels.Selector
+	local                *GlobalRunner
+	remotes              map[string]*remoteRunners
+	elected              <-chan struct{}
+	sourcesMu            sync.Mutex             // Serialises applying remote clusters from the config file and from discovery
+	staticRemotes        []*remoteClusterConfig // Remote clusters from the config file
+	discoveredRemotes    []*remoteClusterConfig // Remote clusters discovered from secrets
+}
+
+func newRunnerManager(homeClient kubernetes.Interface, homeDynamicClient dynamic.Interface, localName string, global globalConfig, gst *GlobalServiceStore, routingStrategyLabel labels.Selector, elected <-chan struct{}) (*runnerManager, error) {
+	propagation, err := newPropagationRules(global.Propagation)
+	if err != nil {
+		return nil, fmt.Errorf("compiling propagation rules: %v", err)
+	}
+	return &runnerManager{
+		homeClient:           homeClient,
+		homeDynamicClient:    homeDynamicClient,
+		global:               global,
+		globalServiceStore:   gst,
+		routingStrategyLabel: routingStrategyLabel,
+		local:                makeGlobalRunner(homeClient, homeClient, homeDynamicClient, homeDynamicClient, localName, nil, nil, propagation, global, gst, true, routingStrategyLabel, elected),
+		remotes:              make(map[string]*remoteRunners),
+		elected:              elected,
+	}, nil
+}
+
+// Run starts the local global runner. The passed context is used by all the
+// managed runners for calls to the Kubernetes API.
+func (m *runnerManager) Run(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ctx = ctx
+	go func() { backoff.Retry(ctx, func() error { return m.local.Run(ctx) }, "start runner") }()
+}
+
+// Runners returns a list of all the currently managed runners
+func (m *runnerManager) Runners() []Runner {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runners := []Runner{m.local}
+	for _, r := range m.remotes {
+		runners = append(runners, r.mirror, r.global)
+	}
+	return runners
+}
+
+// ApplyRemoteClusters compares the passed remote cluster configuration with
+// the running one. It starts runners for new clusters, stops runners and cleans
+// up mirrors for removed clusters and restarts runners of clusters whose
+// configuration has changed. Runners of unaffected clusters are left alone.
+// The runners of a changed cluster are only replaced once the new ones have
+// been created, so that a broken configuration does not stop the mirroring.
+// Returns an error if runners for any of the clusters could not be started.
+func (m *runnerManager) ApplyRemoteClusters(remotes []*remoteClusterConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := map[string]*remoteClusterConfig{}
+	for name, r := range m.remotes {
+		current[name] = r.config
+	}
+	added, removed, changed := diffRemoteClusters(current, remotes)
+	var startErr error
+	for _, remote := range removed {
+		log.Logger.Info("removing remote cluster runners", "cluster", remote.Name)
+		r := m.remotes[remote.Name]
+		r.stop()
+		if m.leader() {
+			if err := r.mirror.Cleanup(); err != nil {
+				log.Logger.Error("cleaning up mirrored services", "cluster", remote.Name, "err", err)
+			}
+			if err := r.global.Cleanup(); err != nil {
+				log.Logger.Error("cleaning up global services", "cluster", remote.Name, "err", err)
+			}
+		} else {
+			log.Logger.Info("not the leader, leaving the clean up of removed cluster to the leader", "cluster", remote.Name)
+		}
+		r.cancel()
+		delete(m.remotes, remote.Name)
+		kube.ForgetHealth(remote.Name)
+	}
+	for _, remote := range changed {
+		log.Logger.Info("restarting remote cluster runners", "cluster", remote.Name)
+		r, err := m.newRemoteRunners(remote)
+		if err != nil {
+			log.Logger.Error("cannot create runners for remote cluster, keeping the running ones", "cluster", remote.Name, "err", err)
+			startErr = fmt.Errorf("starting runners for cluster %s: %v", remote.Name, err)
+			continue
+		}
+		old := m.remotes[remote.Name]
+		old.stop()
+		// Mirrors created under the old prefix will not be picked up by
+		// the new runner, delete them.
+		if old.config.ServicePrefix != remote.ServicePrefix && m.leader() {
+			if err := old.mirror.Cleanup(); err != nil {
+				log.Logger.Error("cleaning up mirrored services", "cluster", remote.Name, "err", err)
+			}
+		}
+		old.cancel()
+		r.start(m.ctx)
+		m.remotes[remote.Name] = r
+	}
+	for _, remote := range added {
+		log.Logger.Info("adding remote cluster runners", "cluster", remote.Name)
+		r, err := m.newRemoteRunners(remote)
+		if err != nil {
+			log.Logger.Error("cannot start runners for remote cluster", "cluster", remote.Name, "err", err)
+			startErr = fmt.Errorf("starting runners for cluster %s: %v", remote.Name, err)
+			continue
+		}
+		r.start(m.ctx)
+		m.remotes[remote.Name] = r
+	}
+	return startErr
+}
+
+// leader returns true if the replica is the leader. Followers do not reconcile
+// and must not write, so they leave cleaning up after removed clusters to the
+// leader, which applies the same configuration.
+func (m *runnerManager) leader() bool {
+	select {
+	case <-m.elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// ApplyStaticRemoteClusters applies the remote clusters from the config file,
+// together with the last discovered remote clusters
+func (m *runnerManager) ApplyStaticRemoteClusters(remotes []*remoteClusterConfig) error {
+	m.sourcesMu.Lock()
+	defer m.sourcesMu.Unlock()
+
+	m.staticRemotes = remotes
+	return m.ApplyRemoteClusters(mergeRemoteClusters(m.staticRemotes, m.discoveredRemotes))
+}
+
+// ApplyDiscoveredRemoteClusters applies the remote clusters discovered from
+// secrets, together with the remote clusters from the config file
+func (m *runnerManager) ApplyDiscoveredRemoteClusters(remotes []*remoteClusterConfig) error {
+	m.sourcesMu.Lock()
+	defer m.sourcesMu.Unlock()
+
+	m.discoveredRemotes = remotes
+	return m.ApplyRemoteClusters(mergeRemoteClusters(m.staticRemotes, m.discoveredRemotes))
+}
+
+// mergeRemoteClusters returns the static remote clusters followed by the
+// discovered ones. Static clusters take precedence over discovered clusters
+// with the same name.
+func mergeRemoteClusters(static, discovered []*remoteClusterConfig) []*remoteClusterConfig {
+	names := map[string]bool{}
+	remotes := []*remoteClusterConfig{}
+	for _, r := range static {
+		names[r.Name] = true
+		remotes = append(remotes, r)
+	}
+	for _, r := range discovered {
+		if names[r.Name] {
+			log.Logger.Warn("discovered remote cluster is already configured in the config file, ignoring", "cluster", r.Name)
+			continue
+		}
+		names[r.Name] = true
+		remotes = append(remotes, r)
+	}
+	return remotes
+}
+
+// newRemoteRunners creates the mirror and global runners for a remote cluster,
+// without starting them
+func (m *runnerManager) newRemoteRunners(remote *remoteClusterConfig) (*remoteRunners, error) {
+	remoteClient, err := makeRemoteKubeClientFromConfig(remote)
+	if err != nil {
+		return nil, err
+	}
+	propagation, err := newPropagationRules(m.global.Propagation, remote.Propagation)
+	if err != nil {
+		return nil, fmt.Errorf("compiling propagation rules: %v", err)
+	}
+	mr := makeMirrorRunner(m.homeClient, remoteClient, m.homeDynamicClient, remote, m.global, propagation, m.elected)
+	gr := makeGlobalRunner(
+		m.homeClient,
+		remoteClient,
+		m.homeDynamicClient,
+		remoteClient.Dynamic,
+		remote.Name,
+		newNamespaceFilter(remote.IncludeNamespaces, remote.ExcludeNamespaces),
+		newStaleEndpointGuard(remote.StaleEndpointPolicy, remote.StaleEndpointTimeout.Duration, kube.HealthOf(remote.Name)),
+		propagation,
+		m.global,
+		m.globalServiceStore,
+		false,
+		m.routingStrategyLabel,
+		m.elected,
+	)
+	return &remoteRunners{
+		config: remote,
+		client: remoteClient,
+		mirror: mr,
+		global: gr,
+	}, nil
+}
+
+// Stop stops all the managed runners in parallel and blocks until they have
+// finished their in-flight work
+func (m *runnerManager) Stop() {
+	var wg sync.WaitGroup
+	for _, r := range m.Runners() {
+		wg.Go(r.Stop)
+	}
+	wg.Wait()
+}
+
+// serviceLookup is the result of a reverse lookup of a local mirrored or
+// global service name
+type serviceLookup struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Clusters  []string `json:"clusters"`
+}
+
+// Lookup resolves a local mirrored or global service name to the name and
+// namespace of the remote service and the clusters it is mirrored from
+func (m *runnerManager) Lookup(localName string) (*serviceLookup, bool) {
+	if gsvc, ok := m.globalServiceStore.Lookup(localName); ok {
+		return &serviceLookup{
+			Name:      gsvc.name,
+			Namespace: gsvc.namespace,
+			Clusters:  gsvc.clusters,
+		}, true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, r := range m.remotes {
+		if namespace, svcName, ok := r.mirror.Lookup(localName); ok {
+			return &serviceLookup{
+				Name:      svcName,
+				Namespace: namespace,
+				Clusters:  []string{name},
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// diffRemoteClusters compares the current remote cluster configuration, keyed
+// by cluster name, with a desired list and returns the clusters that need to be
+// added, removed and the ones with changed configuration.
+func diffRemoteClusters(current map[string]*remoteClusterConfig, desired []*remoteClusterConfig) (added, removed, changed []*remoteClusterConfig) {
+	desiredNames := map[string]bool{}
+	for _, d := range desired {
+		desiredNames[d.Name] = true
+		c, ok := current[d.Name]
+		if !ok {
+			added = append(added, d)
+			continue
+		}
+		if !reflect.DeepEqual(c, d) {
+			changed = append(changed, d)
+		}
+	}
+	for name, c := range current {
+		if !desiredNames[name] {
+			removed = append(removed, c)
+		}
+	}
+	return added, removed, changed
+}
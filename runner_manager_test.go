@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func TestDiffRemoteClusters(t *testing.T) {
+	current := map[string]*remoteClusterConfig{
+		"a": &remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"},
+		"b": &remoteClusterConfig{Name: "b", KubeConfigPath: "/path/b", ServicePrefix: "b"},
+		"c": &remoteClusterConfig{Name: "c", KubeConfigPath: "/path/c", ServicePrefix: "c"},
+	}
+	desired := []*remoteClusterConfig{
+		// unchanged
+		&remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"},
+		// changed resync period
+		&remoteClusterConfig{Name: "b", KubeConfigPath: "/path/b", ServicePrefix: "b", ResyncPeriod: Duration{10 * time.Second}},
+		// new cluster
+		&remoteClusterConfig{Name: "d", KubeConfigPath: "/path/d", ServicePrefix: "d"},
+	}
+	added, removed, changed := diffRemoteClusters(current, desired)
+	assert.Equal(t, 1, len(added))
+	assert.Equal(t, "d", added[0].Name)
+	assert.Equal(t, 1, len(removed))
+	assert.Equal(t, "c", removed[0].Name)
+	assert.Equal(t, 1, len(changed))
+	assert.Equal(t, "b", changed[0].Name)
+	assert.Equal(t, Duration{10 * time.Second}, changed[0].ResyncPeriod)
+}
+
+func TestDiffRemoteClusters_NoChange(t *testing.T) {
+	current := map[string]*remoteClusterConfig{
+		"a": &remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"},
+	}
+	desired := []*remoteClusterConfig{
+		&remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"},
+	}
+	added, removed, changed := diffRemoteClusters(current, desired)
+	assert.Equal(t, 0, len(added))
+	assert.Equal(t, 0, len(removed))
+	assert.Equal(t, 0, len(changed))
+}
+
+func TestApplyRemoteClusters_ChangedClusterFailsToStart(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	homeClient := fake.NewSimpleClientset()
+	global := globalConfig{MirrorNamespace: "local-ns", MirrorSvcLabelSelector: "uw.systems/test=true"}
+	gst := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+	m, err := newRunnerManager(homeClient, nil, "local", global, gst, labels.Everything(), nil)
+	assert.Equal(t, nil, err)
+	m.ctx = context.Background()
+
+	running := &remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"}
+	remoteClient := fake.NewSimpleClientset()
+	mr := makeMirrorRunner(homeClient, remoteClient, nil, running, global, nil, nil)
+	gr := makeGlobalRunner(homeClient, remoteClient, nil, nil, "a", nil, nil, nil, global, gst, false, labels.Everything(), nil)
+	m.remotes["a"] = &remoteRunners{config: running, mirror: mr, global: gr}
+
+	// The new kubeconfig does not exist, the running runners are kept
+	broken := &remoteClusterConfig{Name: "a", KubeConfigPath: "/nonexistent/kubeconfig", ServicePrefix: "a"}
+	assert.NotEqual(t, nil, m.ApplyRemoteClusters([]*remoteClusterConfig{broken}))
+	assert.Equal(t, running, m.remotes["a"].config)
+	assert.Equal(t, mr, m.remotes["a"].mirror)
+	assert.Equal(t, gr, m.remotes["a"].global)
+	select {
+	case <-mr.gcStop:
+		t.Fatal("running mirror runner was stopped")
+	default:
+	}
+
+	// The change is retried on the next apply
+	assert.NotEqual(t, nil, m.ApplyRemoteClusters([]*remoteClusterConfig{broken}))
+	assert.Equal(t, running, m.remotes["a"].config)
+}
+
+func TestApplyRemoteClusters_OnlyLeaderCleansUp(t *testing.T) {
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	global := globalConfig{MirrorNamespace: "local-ns", MirrorSvcLabelSelector: "uw.systems/test=true"}
+	remote := &remoteClusterConfig{Name: "a", KubeConfigPath: "/path/a", ServicePrefix: "a"}
+
+	for _, tc := range []struct {
+		name    string
+		leader  bool
+		mirrors int
+	}{
+		{name: "follower", leader: false, mirrors: 1},
+		{name: "leader", leader: true, mirrors: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mirrorSvc := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      generateMirrorName("a", "remote-ns", "test-svc"),
+					Namespace: "local-ns",
+					Labels:    map[string]string{"mirrored-svc": "true", "mirror-svc-prefix-sync": "a"},
+				},
+			}
+			homeClient := fake.NewSimpleClientset(mirrorSvc)
+			elected := make(chan struct{})
+			if tc.leader {
+				close(elected)
+			}
+			gst := newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "")
+			m, err := newRunnerManager(homeClient, nil, "local", global, gst, labels.Everything(), elected)
+			assert.Equal(t, nil, err)
+			m.ctx = context.Background()
+
+			remoteClient := fake.NewSimpleClientset()
+			mr := makeMirrorRunner(homeClient, remoteClient, nil, remote, global, nil, elected)
+			gr := makeGlobalRunner(homeClient, remoteClient, nil, nil, "a", nil, nil, nil, global, gst, false, labels.Everything(), elected)
+			m.remotes["a"] = &remoteRunners{config: remote, mirror: mr, global: gr, cancel: func() {}}
+
+			// Removing the cluster only deletes its mirrors on the leader
+			assert.Equal(t, nil, m.ApplyRemoteClusters(nil))
+			assert.Equal(t, 0, len(m.remotes))
+			svcs, err := homeClient.CoreV1().Services("local-ns").List(context.Background(), metav1.ListOptions{})
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tc.mirrors, len(svcs.Items))
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+	"github.com/utilitywarehouse/semaphore-service-mirror/metrics"
+)
+
+const (
+	// staleEndpointCheckInterval is how often runners check whether their
+	// remote cluster has been unreachable for longer than the timeout
+	staleEndpointCheckInterval  = 10 * time.Second
+	defaultStaleEndpointTimeout = 5 * time.Minute
+)
+
+// staleEndpointPolicy decides what happens to the mirrored endpoints of a
+// remote cluster that has been unreachable for longer than a timeout
+type staleEndpointPolicy string
+
+const (
+	staleEndpointPolicyKeep     staleEndpointPolicy = "keep"     // Keep the last known endpoints
+	staleEndpointPolicyWithdraw staleEndpointPolicy = "withdraw" // Remove all the endpoints
+	staleEndpointPolicyNotReady staleEndpointPolicy = "notReady" // Mark all the endpoints as not ready
+)
+
+func (p staleEndpointPolicy) valid() bool {
+	switch p {
+	case staleEndpointPolicyKeep, staleEndpointPolicyWithdraw, staleEndpointPolicyNotReady:
+		return true
+	}
+	return false
+}
+
+// staleEndpointGuard tracks whether the endpoints mirrored from a remote
+// cluster are stale, because the cluster has been unreachable for longer than
+// the timeout, and applies the policy to stale endpoints. A nil guard keeps the
+// last known endpoints.
+type staleEndpointGuard struct {
+	mu      sync.Mutex
+	policy  staleEndpointPolicy
+	timeout time.Duration
+	health  *kube.ClusterHealth
+	stale   bool
+	stop    chan struct{}
+	now     func() time.Time
+}
+
+// newStaleEndpointGuard returns a guard for the endpoints of the cluster, or nil
+// if the policy is to keep the last known endpoints
+func newStaleEndpointGuard(policy staleEndpointPolicy, timeout time.Duration, health *kube.ClusterHealth) *staleEndpointGuard {
+	if policy == "" || policy == staleEndpointPolicyKeep {
+		return nil
+	}
+	if timeout == 0 {
+		timeout = defaultStaleEndpointTimeout
+	}
+	return &staleEndpointGuard{
+		policy:  policy,
+		timeout: timeout,
+		health:  health,
+		stop:    make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// check updates whether the endpoints are stale from the health of the cluster
+// and returns true if that changed
+func (g *staleEndpointGuard) check() bool {
+	failingSince := g.health.Status().FailingSince
+	stale := !failingSince.IsZero() && g.now().Sub(failingSince) >= g.timeout
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	changed := stale != g.stale
+	g.stale = stale
+	return changed
+}
+
+// Stale returns true if the endpoints of the cluster are stale
+func (g *staleEndpointGuard) Stale() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.stale
+}
+
+// Subsets returns the endpoints subsets to mirror, after applying the policy if
+// they are stale
+func (g *staleEndpointGuard) Subsets(subsets []v1.EndpointSubset) []v1.EndpointSubset {
+	if !g.Stale() {
+		return subsets
+	}
+	if g.policy == staleEndpointPolicyWithdraw {
+		return nil
+	}
+	notReady := make([]v1.EndpointSubset, 0, len(subsets))
+	for _, s := range subsets {
+		notReady = append(notReady, v1.EndpointSubset{
+			NotReadyAddresses: append(append([]v1.EndpointAddress{}, s.NotReadyAddresses...), s.Addresses...),
+			Ports:             s.Ports,
+		})
+	}
+	return notReady
+}
+
+// Endpoints returns the endpointslice endpoints to mirror, after applying the
+// policy if they are stale
+func (g *staleEndpointGuard) Endpoints(endpoints []discoveryv1.Endpoint) []discoveryv1.Endpoint {
+	if !g.Stale() {
+		return endpoints
+	}
+	if g.policy == staleEndpointPolicyWithdraw {
+		return []discoveryv1.Endpoint{}
+	}
+	notReady := make([]discoveryv1.Endpoint, 0, len(endpoints))
+	ready := false
+	for _, e := range endpoints {
+		e.Conditions.Ready = &ready
+		notReady = append(notReady, e)
+	}
+	return notReady
+}
+
+// Run checks every interval whether the endpoints became stale or fresh again
+// and calls requeue to reconcile all endpoints when they do, until the context
+// is cancelled or the guard is stopped
+func (g *staleEndpointGuard) Run(ctx context.Context, runner string, requeue func()) {
+	if g == nil {
+		return
+	}
+	ticker := time.NewTicker(staleEndpointCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+		if !g.check() {
+			continue
+		}
+		if g.Stale() {
+			log.Logger.Warn("remote cluster unreachable, applying stale endpoint policy", "policy", g.policy, "timeout", g.timeout, "runner", runner)
+			metrics.SetStaleEndpoints(runner, 1)
+		} else {
+			log.Logger.Info("remote cluster reachable again, restoring endpoints", "runner", runner)
+			metrics.SetStaleEndpoints(runner, 0)
+		}
+		requeue()
+	}
+}
+
+// Stop stops Run
+func (g *staleEndpointGuard) Stop() {
+	if g == nil {
+		return
+	}
+	close(g.stop)
+}
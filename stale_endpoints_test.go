@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+func TestStaleEndpointGuardCheck(t *testing.T) {
+	assert.Nil(t, newStaleEndpointGuard("", 0, nil))
+	assert.Nil(t, newStaleEndpointGuard(staleEndpointPolicyKeep, time.Minute, nil))
+
+	health := kube.HealthOf("stale-check")
+	defer kube.ForgetHealth("stale-check")
+	guard := newStaleEndpointGuard(staleEndpointPolicyWithdraw, 0, health)
+	assert.Equal(t, defaultStaleEndpointTimeout, guard.timeout)
+
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+	assert.Equal(t, false, guard.check())
+	assert.Equal(t, false, guard.Stale())
+
+	// Errors shorter than the timeout do not make endpoints stale
+	health.RecordError(fmt.Errorf("connection refused"))
+	now = now.Add(time.Minute)
+	assert.Equal(t, false, guard.check())
+
+	now = now.Add(defaultStaleEndpointTimeout)
+	assert.Equal(t, true, guard.check())
+	assert.Equal(t, true, guard.Stale())
+	assert.Equal(t, false, guard.check())
+
+	health.RecordSuccess()
+	assert.Equal(t, true, guard.check())
+	assert.Equal(t, false, guard.Stale())
+}
+
+func TestStaleEndpointGuardPolicies(t *testing.T) {
+	subsets := []v1.EndpointSubset{{
+		Addresses:         []v1.EndpointAddress{{IP: "10.0.0.1"}},
+		NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.2"}},
+		Ports:             []v1.EndpointPort{{Port: 80}},
+	}}
+	ready := true
+	endpoints := []discoveryv1.Endpoint{{
+		Addresses:  []string{"10.0.0.1"},
+		Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+	}}
+
+	// A nil guard keeps endpoints
+	var keep *staleEndpointGuard
+	assert.Equal(t, subsets, keep.Subsets(subsets))
+	assert.Equal(t, endpoints, keep.Endpoints(endpoints))
+
+	withdraw := newStaleEndpointGuard(staleEndpointPolicyWithdraw, time.Minute, nil)
+	assert.Equal(t, subsets, withdraw.Subsets(subsets))
+	withdraw.stale = true
+	assert.Equal(t, 0, len(withdraw.Subsets(subsets)))
+	assert.Equal(t, 0, len(withdraw.Endpoints(endpoints)))
+
+	notReady := newStaleEndpointGuard(staleEndpointPolicyNotReady, time.Minute, nil)
+	notReady.stale = true
+	assert.Equal(t, []v1.EndpointSubset{{
+		NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+		Ports:             []v1.EndpointPort{{Port: 80}},
+	}}, notReady.Subsets(subsets))
+	es := notReady.Endpoints(endpoints)
+	assert.Equal(t, []string{"10.0.0.1"}, es[0].Addresses)
+	assert.Equal(t, false, *es[0].Conditions.Ready)
+	// The cached remote endpoints are not modified
+	assert.Equal(t, true, *endpoints[0].Conditions.Ready)
+}
+
+func TestGlobalRunnerStaleEndpointSlices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+
+	testPort := int32(80)
+	testEndpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc-abcde",
+			Namespace: "remote-ns",
+			Labels: map[string]string{
+				"kubernetes.io/service-name":            "test-svc",
+				"mirror.semaphore.uw.io/global-service": "true",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		Ports:       []discoveryv1.EndpointPort{{Port: &testPort}},
+	}
+	fakeClient := fake.NewSimpleClientset()
+	fakeWatchClient := fake.NewSimpleClientset(testEndpointSlice)
+	guard := newStaleEndpointGuard(staleEndpointPolicyNotReady, time.Minute, kube.HealthOf("stale-runner"))
+	defer kube.ForgetHealth("stale-runner")
+
+	testRunner := newGlobalRunner(globalRunnerOptions{
+		client:             fakeClient,
+		watchClient:        fakeWatchClient,
+		name:               "stale-runner",
+		namespace:          "local-ns",
+		labelSelector:      testGlobalSvcLabelString,
+		staleEndpoints:     guard,
+		resyncPeriod:       60 * time.Minute,
+		globalServiceStore: newGlobalServiceStore(mergePolicyUnion, headlessPolicyReference, "local"),
+		sync:               true,
+	})
+	go testRunner.endpointSliceWatcher.Run()
+	cache.WaitForNamedCacheSync("endpointSliceWatcher", ctx.Done(), testRunner.endpointSliceWatcher.HasSynced)
+
+	mirrorName := generateGlobalEndpointSliceName("stale-runner", "remote-ns", "test-svc-abcde")
+	if err := testRunner.reconcileEndpointSlice("test-svc-abcde", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	es, err := fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, es.Endpoints[0].Conditions.Ready)
+
+	// Once stale, the mirrored endpoints are marked not ready
+	guard.stale = true
+	if err := testRunner.reconcileEndpointSlice("test-svc-abcde", "remote-ns"); err != nil {
+		t.Fatal(err)
+	}
+	es, err = fakeClient.DiscoveryV1().EndpointSlices("local-ns").Get(ctx, mirrorName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"10.0.0.1"}, es.Endpoints[0].Addresses)
+	assert.Equal(t, false, *es.Endpoints[0].Conditions.Ready)
+}
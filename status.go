@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+// Label that holds the source cluster of MirroredService status resources
+const statusClusterLabel = "mirror-status-cluster"
+
+// reconcileResult is the outcome of the last reconcile of a remote service
+type reconcileResult struct {
+	time metav1.Time
+	err  error
+}
+
+// statusReporter maintains the status resources that report the state of the
+// services mirrored from a single cluster. A nil statusReporter reports
+// nothing.
+type statusReporter struct {
+	client    dynamic.Interface
+	namespace string // Namespace of the mirrored and global services
+	cluster   string
+	mu        sync.Mutex
+	results   map[string]reconcileResult // Last reconcile results keyed by remote service namespace and name
+	now       func() time.Time
+}
+
+func newStatusReporter(client dynamic.Interface, namespace, cluster string) *statusReporter {
+	return &statusReporter{
+		client:    client,
+		namespace: namespace,
+		cluster:   cluster,
+		results:   map[string]reconcileResult{},
+		now:       time.Now,
+	}
+}
+
+// RecordReconcile records the outcome of a reconcile of a remote service
+func (r *statusReporter) RecordReconcile(name, namespace string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Status resources are serialised with a precision of a second
+	r.results[namespace+"/"+name] = reconcileResult{
+		time: metav1.NewTime(r.now().Truncate(time.Second)),
+		err:  err,
+	}
+}
+
+func (r *statusReporter) forget(name, namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.results, namespace+"/"+name)
+}
+
+// clusterState returns the state of a remote service in the reporter's cluster
+func (r *statusReporter) clusterState(svc *v1.Service, endpoints, ready int) kube.ClusterServiceState {
+	r.mu.Lock()
+	result, ok := r.results[svc.Namespace+"/"+svc.Name]
+	r.mu.Unlock()
+
+	state := kube.ClusterServiceState{
+		Cluster:        r.cluster,
+		Ports:          servicePorts(svc.Spec.Ports),
+		Endpoints:      endpoints,
+		ReadyEndpoints: ready,
+	}
+	if ok {
+		state.LastReconcileTime = result.time.DeepCopy()
+		if result.err != nil {
+			state.LastError = result.err.Error()
+		}
+	}
+	// ExternalName services have no endpoints
+	state.Ready = state.LastError == "" && (ready > 0 || svc.Spec.Type == v1.ServiceTypeExternalName)
+	return state
+}
+
+// ReportMirroredService creates or updates the status resource of a mirrored
+// service
+func (r *statusReporter) ReportMirroredService(ctx context.Context, mirrorName string, remoteSvc *v1.Service, endpoints, ready int) error {
+	if r == nil {
+		return nil
+	}
+	desired := &kube.MirroredService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mirrorName,
+			Namespace: r.namespace,
+			Labels:    map[string]string{statusClusterLabel: r.cluster},
+		},
+		Status: kube.MirroredServiceStatus{
+			Service:             remoteSvc.Namespace + "/" + remoteSvc.Name,
+			ClusterServiceState: r.clusterState(remoteSvc, endpoints, ready),
+		},
+	}
+	ms, err := kube.GetMirroredService(ctx, r.client, mirrorName, r.namespace)
+	if errors.IsNotFound(err) {
+		if _, err := kube.CreateMirroredService(ctx, r.client, desired); err != nil {
+			return fmt.Errorf("creating mirrored service status %s/%s: %v", r.namespace, mirrorName, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting mirrored service status %s/%s: %v", r.namespace, mirrorName, err)
+	}
+	if equality.Semantic.DeepEqual(ms.Status, desired.Status) && ms.Labels[statusClusterLabel] == r.cluster {
+		return nil
+	}
+	updated := ms.DeepCopy()
+	updated.Labels = mergeMetadata(desired.Labels, ms.Labels)
+	updated.Status = desired.Status
+	if _, err := kube.UpdateMirroredService(ctx, r.client, updated); err != nil {
+		return fmt.Errorf("updating mirrored service status %s/%s: %v", r.namespace, mirrorName, err)
+	}
+	return nil
+}
+
+// DeleteMirroredService deletes the status resource of a mirrored service
+func (r *statusReporter) DeleteMirroredService(ctx context.Context, mirrorName, name, namespace string) error {
+	if r == nil {
+		return nil
+	}
+	r.forget(name, namespace)
+	if err := kube.DeleteMirroredService(ctx, r.client, mirrorName, r.namespace); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting mirrored service status %s/%s: %v", r.namespace, mirrorName, err)
+	}
+	return nil
+}
+
+// DeleteMirroredServices deletes the status resources of all the services
+// mirrored from the reporter's cluster
+func (r *statusReporter) DeleteMirroredServices(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	mss, err := kube.ListMirroredServices(ctx, r.client, r.namespace, labels.Set{statusClusterLabel: r.cluster}.String())
+	if err != nil {
+		return fmt.Errorf("listing mirrored service statuses: %v", err)
+	}
+	for _, ms := range mss {
+		if err := kube.DeleteMirroredService(ctx, r.client, ms.Name, r.namespace); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting mirrored service status %s/%s: %v", r.namespace, ms.Name, err)
+		}
+	}
+	return nil
+}
+
+// ReportGlobalService sets the state of the reporter's cluster in the status
+// resource of a global service, together with the conflicts between clusters
+func (r *statusReporter) ReportGlobalService(ctx context.Context, globalName string, remoteSvc *v1.Service, endpoints, ready int, conflicts []string) error {
+	if r == nil {
+		return nil
+	}
+	state := r.clusterState(remoteSvc, endpoints, ready)
+	return r.updateGlobalService(ctx, globalName, remoteSvc.Name, remoteSvc.Namespace, &state, conflicts)
+}
+
+// RemoveGlobalServiceCluster removes the reporter's cluster from the status
+// resource of a global service. The resource is deleted when no clusters are
+// left.
+func (r *statusReporter) RemoveGlobalServiceCluster(ctx context.Context, globalName, name, namespace string, conflicts []string) error {
+	if r == nil {
+		return nil
+	}
+	r.forget(name, namespace)
+	return r.updateGlobalService(ctx, globalName, name, namespace, nil, conflicts)
+}
+
+// DeleteGlobalService deletes the status resource of a global service
+func (r *statusReporter) DeleteGlobalService(ctx context.Context, globalName string) error {
+	if r == nil {
+		return nil
+	}
+	if err := kube.DeleteGlobalService(ctx, r.client, globalName, r.namespace); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting global service status %s/%s: %v", r.namespace, globalName, err)
+	}
+	return nil
+}
+
+// updateGlobalService replaces the state of the reporter's cluster in the
+// status resource of a global service, or removes it if state is nil. All the
+// runners of a global service write to the same resource, so conflicting
+// writes are retried.
+func (r *statusReporter) updateGlobalService(ctx context.Context, globalName, name, namespace string, state *kube.ClusterServiceState, conflicts []string) error {
+	conflicting := func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}
+	err := retry.OnError(retry.DefaultRetry, conflicting, func() error {
+		gs, err := kube.GetGlobalService(ctx, r.client, globalName, r.namespace)
+		if errors.IsNotFound(err) {
+			if state == nil {
+				return nil
+			}
+			gs = &kube.GlobalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      globalName,
+					Namespace: r.namespace,
+				},
+				Status: globalServiceStatus(namespace+"/"+name, nil, r.cluster, state, conflicts),
+			}
+			_, err := kube.CreateGlobalService(ctx, r.client, gs)
+			return err
+		} else if err != nil {
+			return err
+		}
+		status := globalServiceStatus(namespace+"/"+name, gs.Status.Clusters, r.cluster, state, conflicts)
+		if len(status.Clusters) == 0 {
+			log.Logger.Debug("global service status has no more clusters, deleting", "namespace", r.namespace, "name", globalName, "runner", r.cluster)
+			if err := kube.DeleteGlobalService(ctx, r.client, globalName, r.namespace); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		}
+		if equality.Semantic.DeepEqual(gs.Status, status) {
+			return nil
+		}
+		updated := gs.DeepCopy()
+		updated.Status = status
+		_, err = kube.UpdateGlobalService(ctx, r.client, updated)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating global service status %s/%s: %v", r.namespace, globalName, err)
+	}
+	return nil
+}
+
+// globalServiceStatus returns the status of a global service with the state of
+// the cluster replaced, or removed if state is nil. Clusters are sorted by name
+// and the summary fields are computed from the clusters.
+func globalServiceStatus(service string, clusters []kube.ClusterServiceState, cluster string, state *kube.ClusterServiceState, conflicts []string) kube.GlobalServiceStatus {
+	status := kube.GlobalServiceStatus{
+		Service:   service,
+		Clusters:  []kube.ClusterServiceState{},
+		Conflicts: append([]string(nil), conflicts...),
+	}
+	for _, c := range clusters {
+		if c.Cluster != cluster {
+			status.Clusters = append(status.Clusters, c)
+		}
+	}
+	if state != nil {
+		status.Clusters = append(status.Clusters, *state)
+	}
+	sort.Slice(status.Clusters, func(i, j int) bool {
+		return status.Clusters[i].Cluster < status.Clusters[j].Cluster
+	})
+	errs := []string{}
+	for _, c := range status.Clusters {
+		status.Ready = status.Ready || c.Ready
+		if c.LastReconcileTime != nil && (status.LastReconcileTime == nil || status.LastReconcileTime.Before(c.LastReconcileTime)) {
+			status.LastReconcileTime = c.LastReconcileTime.DeepCopy()
+		}
+		if c.LastError != "" {
+			errs = append(errs, fmt.Sprintf("%s: %s", c.Cluster, c.LastError))
+		}
+	}
+	status.LastError = strings.Join(errs, "; ")
+	return status
+}
+
+// countSubsetEndpoints returns the number of all and ready addresses of
+// endpoints subsets
+func countSubsetEndpoints(subsets []v1.EndpointSubset) (int, int) {
+	total, ready := 0, 0
+	for _, s := range subsets {
+		total += len(s.Addresses) + len(s.NotReadyAddresses)
+		ready += len(s.Addresses)
+	}
+	return total, ready
+}
+
+// countEndpointSliceEndpoints returns the number of all and ready endpoints in
+// the endpointslices of a service
+func countEndpointSliceEndpoints(endpointSlices []*discoveryv1.EndpointSlice, name, namespace string) (int, int) {
+	total, ready := 0, 0
+	for _, es := range endpointSlices {
+		if es.Namespace != namespace || es.Labels["kubernetes.io/service-name"] != name {
+			continue
+		}
+		for _, e := range es.Endpoints {
+			total++
+			// A nil ready condition should be interpreted as ready
+			if e.Conditions.Ready == nil || *e.Conditions.Ready {
+				ready++
+			}
+		}
+	}
+	return total, ready
+}
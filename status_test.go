@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/utilitywarehouse/semaphore-service-mirror/kube"
+	"github.com/utilitywarehouse/semaphore-service-mirror/log"
+)
+
+var testStatusTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newFakeStatusClient returns a fake client for the status resources, which
+// are created unstructured by the operator
+func newFakeStatusClient() *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			kube.MirroredServiceResource: "MirroredServiceList",
+			kube.GlobalServiceResource:   "GlobalServiceList",
+		},
+	)
+}
+
+func newTestStatusReporter(client *dynamicfake.FakeDynamicClient, cluster string) *statusReporter {
+	r := newStatusReporter(client, "local-ns", cluster)
+	r.now = func() time.Time { return testStatusTime }
+	return r
+}
+
+func TestMirrorRunnerStatus(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	fakeClient := fake.NewSimpleClientset()
+	testSvc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+	testEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: "remote-ns",
+			Labels:    map[string]string{"uw.systems/test": "true"},
+		},
+		Subsets: []v1.EndpointSubset{{
+			Addresses:         []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+			NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.3"}},
+		}},
+	}
+	fakeWatchClient := fake.NewSimpleClientset(testSvc, testEndpoints)
+	statusClient := newFakeStatusClient()
+
+	testRunner := newMirrorRunner(mirrorRunnerOptions{
+		client:        fakeClient,
+		watchClient:   fakeWatchClient,
+		name:          "test-runner",
+		namespace:     "local-ns",
+		prefix:        "prefix",
+		labelSelector: "uw.systems/test=true",
+		status:        newTestStatusReporter(statusClient, "test-runner"),
+		resyncPeriod:  60 * time.Minute,
+	})
+	go testRunner.serviceWatcher.Run()
+	go testRunner.endpointsWatcher.Run()
+	cache.WaitForNamedCacheSync("serviceWatcher", ctx.Done(), testRunner.serviceWatcher.HasSynced)
+	cache.WaitForNamedCacheSync("endpointsWatcher", ctx.Done(), testRunner.endpointsWatcher.HasSynced)
+
+	mirrorName := generateMirrorName("prefix", "remote-ns", "test-svc")
+	assert.Equal(t, nil, testRunner.reconcileService("test-svc", "remote-ns"))
+	assert.Equal(t, nil, testRunner.reconcileStatus("test-svc", "remote-ns"))
+	ms, err := kube.GetMirroredService(ctx, statusClient, mirrorName, "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test-runner", ms.Labels[statusClusterLabel])
+	assert.Equal(t, kube.MirroredServiceStatus{
+		Service: "remote-ns/test-svc",
+		ClusterServiceState: kube.ClusterServiceState{
+			Cluster:           "test-runner",
+			Ports:             []kube.ServicePort{{Name: "http", Port: 80}},
+			Endpoints:         3,
+			ReadyEndpoints:    2,
+			Ready:             true,
+			LastReconcileTime: &metav1.Time{Time: testStatusTime},
+		},
+	}, normaliseMirroredServiceStatus(ms.Status))
+
+	// Reconcile errors are reported
+	testRunner.status.RecordReconcile("test-svc", "remote-ns", fmt.Errorf("boom"))
+	assert.Equal(t, nil, testRunner.reconcileStatus("test-svc", "remote-ns"))
+	ms, err = kube.GetMirroredService(ctx, statusClient, mirrorName, "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "boom", ms.Status.LastError)
+	assert.Equal(t, false, ms.Status.Ready)
+
+	// The status is deleted with the remote service
+	assert.Equal(t, nil, fakeWatchClient.CoreV1().Services("remote-ns").Delete(ctx, "test-svc", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		_, err := testRunner.getRemoteService("test-svc", "remote-ns")
+		return err != nil && testRunner.serviceQueue.queue.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, nil, testRunner.reconcileStatus("test-svc", "remote-ns"))
+	_, err = kube.GetMirroredService(ctx, statusClient, mirrorName, "local-ns")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestGlobalServiceStatusClusters(t *testing.T) {
+	ctx := context.Background()
+
+	log.InitLogger("semaphore-service-mirror-test", "debug")
+	statusClient := newFakeStatusClient()
+	reporterA := newTestStatusReporter(statusClient, "a")
+	reporterB := newTestStatusReporter(statusClient, "b")
+	svc := createTestService("test-svc", "remote-ns", "1.1.1.1", []int32{80})
+	globalName := generateGlobalServiceName("test-svc", "remote-ns")
+
+	reporterB.RecordReconcile("test-svc", "remote-ns", fmt.Errorf("boom"))
+	assert.Equal(t, nil, reporterB.ReportGlobalService(ctx, globalName, svc, 1, 1, []string{"conflict"}))
+	reporterA.RecordReconcile("test-svc", "remote-ns", nil)
+	assert.Equal(t, nil, reporterA.ReportGlobalService(ctx, globalName, svc, 2, 1, []string{"conflict"}))
+
+	gs, err := kube.GetGlobalService(ctx, statusClient, globalName, "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "remote-ns/test-svc", gs.Status.Service)
+	assert.Equal(t, 2, len(gs.Status.Clusters))
+	assert.Equal(t, "a", gs.Status.Clusters[0].Cluster)
+	assert.Equal(t, 2, gs.Status.Clusters[0].Endpoints)
+	assert.Equal(t, true, gs.Status.Clusters[0].Ready)
+	assert.Equal(t, "b", gs.Status.Clusters[1].Cluster)
+	assert.Equal(t, false, gs.Status.Clusters[1].Ready)
+	assert.Equal(t, true, gs.Status.Ready)
+	assert.Equal(t, "b: boom", gs.Status.LastError)
+	assert.Equal(t, []string{"conflict"}, gs.Status.Conflicts)
+	assert.True(t, testStatusTime.Equal(gs.Status.LastReconcileTime.Time))
+
+	// The resource is deleted with the last cluster
+	assert.Equal(t, nil, reporterA.RemoveGlobalServiceCluster(ctx, globalName, "test-svc", "remote-ns", nil))
+	gs, err = kube.GetGlobalService(ctx, statusClient, globalName, "local-ns")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(gs.Status.Clusters))
+	assert.Equal(t, "b", gs.Status.Clusters[0].Cluster)
+	assert.Equal(t, false, gs.Status.Ready)
+	assert.Equal(t, nil, reporterB.RemoveGlobalServiceCluster(ctx, globalName, "test-svc", "remote-ns", nil))
+	_, err = kube.GetGlobalService(ctx, statusClient, globalName, "local-ns")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestNilStatusReporter(t *testing.T) {
+	var r *statusReporter
+	svc := createTestService("test-svc", "remote-ns", "1.1.1.1", []int32{80})
+	r.RecordReconcile("test-svc", "remote-ns", nil)
+	assert.Equal(t, nil, r.ReportMirroredService(context.Background(), "mirror", svc, 0, 0))
+	assert.Equal(t, nil, r.ReportGlobalService(context.Background(), "global", svc, 0, 0, nil))
+	assert.Equal(t, nil, r.DeleteMirroredServices(context.Background()))
+}
+
+func TestCountEndpointSliceEndpoints(t *testing.T) {
+	notReady := false
+	endpointSlices := []*discoveryv1.EndpointSlice{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-svc-a",
+				Namespace: "remote-ns",
+				Labels:    map[string]string{"kubernetes.io/service-name": "test-svc"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.1"}},
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "other-svc-a",
+				Namespace: "remote-ns",
+				Labels:    map[string]string{"kubernetes.io/service-name": "other-svc"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.3"}}},
+		},
+	}
+	total, ready := countEndpointSliceEndpoints(endpointSlices, "test-svc", "remote-ns")
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, ready)
+}
+
+// normaliseMirroredServiceStatus sets the location of the last reconcile time,
+// which is lost when converting from unstructured
+func normaliseMirroredServiceStatus(status kube.MirroredServiceStatus) kube.MirroredServiceStatus {
+	if status.LastReconcileTime != nil {
+		status.LastReconcileTime = &metav1.Time{Time: status.LastReconcileTime.UTC()}
+	}
+	return status
+}
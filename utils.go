@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
@@ -16,6 +19,17 @@ const (
 	// Separator is inserted between the namespace and name in the mirror
 	// name to prevent clashes
 	Separator = "73736d"
+
+	// maxNameLength is the length limit of DNS labels, which service names
+	// must comply with
+	maxNameLength = 63
+	// nameHashLength is the length of the hash suffix of shortened names
+	nameHashLength = 10
+
+	// Annotations that hold the name and namespace of the remote service on
+	// mirrored and global services, since generated names may be shortened
+	mirrorSvcRemoteNameAnno      = "mirror-svc-remote-name"
+	mirrorSvcRemoteNamespaceAnno = "mirror-svc-remote-namespace"
 )
 
 var (
@@ -26,29 +40,99 @@ var (
 
 // generateMirrorName generates a name for mirrored objects based on the name
 // and namespace of the remote object: <prefix>-<namespace>-73736d-<name>
+// Names longer than 63 characters are shortened.
 func generateMirrorName(prefix, namespace, name string) string {
+	return shortenName(fullMirrorName(prefix, namespace, name))
+}
+
+// fullMirrorName returns the mirror name before it is shortened
+func fullMirrorName(prefix, namespace, name string) string {
 	return fmt.Sprintf("%s-%s-%s-%s", prefix, namespace, Separator, name)
 }
 
 // generateGlobalServiceName generates a name for mirrored objects based on the
 // name and namespace of the remote object: gl-<namespace>-73736d-<name>
+// Names longer than 63 characters are shortened.
 func generateGlobalServiceName(name, namespace string) string {
+	return shortenName(fullGlobalServiceName(name, namespace))
+}
+
+// fullGlobalServiceName returns the global service name before it is shortened
+func fullGlobalServiceName(name, namespace string) string {
 	return fmt.Sprintf("gl-%s-%s-%s", namespace, Separator, name)
 }
 
-// generateGlobalEndpointSliceName just prefixes the name with `gl-`, and relies
-// on kubernetes suffixes for endpointslices to not collide.
-func generateGlobalEndpointSliceName(name string) string {
+// shortenName truncates names longer than 63 characters and appends a hash of
+// the full name, so that different long names do not collide:
+// <truncated name>-<hash>
+func shortenName(name string) string {
+	if len(name) <= maxNameLength {
+		return name
+	}
+	hash := sha256.Sum256([]byte(name))
+	truncated := strings.TrimRight(name[:maxNameLength-nameHashLength-1], "-")
+	return fmt.Sprintf("%s-%s", truncated, hex.EncodeToString(hash[:])[:nameHashLength])
+}
+
+// parseMirrorName returns the namespace and name of the remote object from a
+// name generated by generateMirrorName. It fails for names that were shortened.
+func parseMirrorName(prefix, mirrorName string) (string, string, bool) {
+	trimmed, ok := strings.CutPrefix(mirrorName, prefix+"-")
+	if !ok {
+		return "", "", false
+	}
+	namespace, name, ok := strings.Cut(trimmed, fmt.Sprintf("-%s-", Separator))
+	if !ok || namespace == "" || name == "" {
+		return "", "", false
+	}
+	return namespace, name, true
+}
+
+// generateMirrorAnnotations returns the annotations that record the name and
+// namespace of the remote service on a mirrored or global service
+func generateMirrorAnnotations(name, namespace string) map[string]string {
+	return map[string]string{
+		mirrorSvcRemoteNameAnno:      name,
+		mirrorSvcRemoteNamespaceAnno: namespace,
+	}
+}
+
+// generateGlobalEndpointSliceName generates a name for endpointslices of global
+// services based on the cluster, namespace and name of the remote
+// endpointslice: gl-<cluster>-73736d-<namespace>-73736d-<name>
+// Names longer than 63 characters are shortened.
+func generateGlobalEndpointSliceName(cluster, namespace, name string) string {
+	return shortenName(fmt.Sprintf("gl-%s-%s-%s-%s-%s", cluster, Separator, namespace, Separator, name))
+}
+
+// legacyGlobalEndpointSliceName returns the name that older versions gave to
+// endpointslices of global services, which only prefixed the remote name with
+// `gl-` and could collide between clusters and namespaces
+func legacyGlobalEndpointSliceName(name string) string {
 	return fmt.Sprintf("gl-%s", name)
 }
 
 func generateEndpointSliceLabels(baseLabels map[string]string, targetService string) map[string]string {
-	labels := baseLabels
+	labels := map[string]string{}
+	for k, v := range baseLabels {
+		labels[k] = v
+	}
 	labels["kubernetes.io/service-name"] = targetService
 	labels["endpointslice.kubernetes.io/managed-by"] = "semaphore-service-mirror"
 	return labels
 }
 
+// stripEndpointHints removes topology hints from endpoints, as zones of remote
+// clusters are meaningless for local routing decisions
+func stripEndpointHints(endpoints []discoveryv1.Endpoint) []discoveryv1.Endpoint {
+	var es []discoveryv1.Endpoint
+	for _, e := range endpoints {
+		e.Hints = nil
+		es = append(es, e)
+	}
+	return es
+}
+
 func setLocalEndpointZones(zones []string) {
 	for _, z := range zones {
 		DefaultLocalEndpointZones = append(DefaultLocalEndpointZones, discoveryv1.ForZone{Name: z})
@@ -64,9 +148,29 @@ func inSlice(slice []string, val string) (int, bool) {
 	return -1, false
 }
 
-func removeFromSlice(slice []string, i int) []string {
-	slice[len(slice)-1], slice[i] = slice[i], slice[len(slice)-1]
-	return slice[:len(slice)-1]
+// loadBalancerSubsets returns endpoints that point at the load balancer ingress
+// IPs of a service, on the service ports. Ingress hostnames cannot be used as
+// endpoint addresses and are skipped.
+func loadBalancerSubsets(svc *v1.Service) []v1.EndpointSubset {
+	addresses := []v1.EndpointAddress{}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			addresses = append(addresses, v1.EndpointAddress{IP: ingress.IP})
+		}
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	ports := make([]v1.EndpointPort, 0, len(svc.Spec.Ports))
+	for _, p := range svc.Spec.Ports {
+		ports = append(ports, v1.EndpointPort{
+			Name:        p.Name,
+			Port:        p.Port,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+		})
+	}
+	return []v1.EndpointSubset{{Addresses: addresses, Ports: ports}}
 }
 
 func isHeadless(svc *v1.Service) bool {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -57,3 +58,83 @@ func TestMatchSelector_NoServiceMatch(t *testing.T) {
 	res := matchSelector(selector, testSvc)
 	assert.Equal(t, false, res)
 }
+
+func TestGenerateMirrorName_Short(t *testing.T) {
+	name := generateMirrorName("prefix", "remote-ns", "test-svc")
+	assert.Equal(t, "prefix-remote-ns-73736d-test-svc", name)
+}
+
+func TestGenerateMirrorName_Long(t *testing.T) {
+	longName := strings.Repeat("a", 60)
+	name := generateMirrorName("prefix", "remote-ns", longName)
+	assert.Equal(t, 63, len(name))
+	assert.True(t, strings.HasPrefix(name, "prefix-remote-ns-73736d-aaa"))
+	// Deterministic
+	assert.Equal(t, name, generateMirrorName("prefix", "remote-ns", longName))
+	// Names that share the truncated part should not collide
+	otherName := generateMirrorName("prefix", "remote-ns", longName+"b")
+	assert.Equal(t, 63, len(otherName))
+	assert.NotEqual(t, name, otherName)
+}
+
+func TestShortenName_NoTrailingDash(t *testing.T) {
+	// Place a dash right where the name gets truncated
+	name := strings.Repeat("a", maxNameLength-nameHashLength-2) + "-" + strings.Repeat("b", 20)
+	shortened := shortenName(name)
+	assert.LessOrEqual(t, len(shortened), maxNameLength)
+	assert.NotContains(t, shortened, "--")
+}
+
+func TestGenerateGlobalServiceName_Long(t *testing.T) {
+	name := generateGlobalServiceName(strings.Repeat("a", 60), "remote-ns")
+	assert.Equal(t, 63, len(name))
+	assert.True(t, strings.HasPrefix(name, "gl-remote-ns-73736d-aaa"))
+}
+
+func TestGenerateGlobalEndpointSliceName(t *testing.T) {
+	assert.Equal(
+		t,
+		"gl-cluster-73736d-remote-ns-73736d-test-svc-abcde",
+		generateGlobalEndpointSliceName("cluster", "remote-ns", "test-svc-abcde"),
+	)
+	// The same remote name in different clusters or namespaces does not
+	// collide
+	assert.NotEqual(
+		t,
+		generateGlobalEndpointSliceName("a-b", "c", "test-svc-abcde"),
+		generateGlobalEndpointSliceName("a", "b-c", "test-svc-abcde"),
+	)
+
+	name := generateGlobalEndpointSliceName("cluster", "remote-ns", strings.Repeat("a", 60))
+	assert.Equal(t, 63, len(name))
+	assert.True(t, strings.HasPrefix(name, "gl-cluster-73736d-remote-ns-73736d-aaa"))
+	assert.NotEqual(t, name, generateGlobalEndpointSliceName("other", "remote-ns", strings.Repeat("a", 60)))
+}
+
+func TestParseMirrorName(t *testing.T) {
+	namespace, name, ok := parseMirrorName("prefix", "prefix-remote-ns-73736d-test-svc")
+	assert.True(t, ok)
+	assert.Equal(t, "remote-ns", namespace)
+	assert.Equal(t, "test-svc", name)
+
+	_, _, ok = parseMirrorName("other", "prefix-remote-ns-73736d-test-svc")
+	assert.False(t, ok)
+	_, _, ok = parseMirrorName("prefix", "prefix-remote-ns-test-svc")
+	assert.False(t, ok)
+}
+
+func TestLoadBalancerSubsets(t *testing.T) {
+	svc := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}},
+		},
+	}
+	assert.Nil(t, loadBalancerSubsets(svc))
+
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "1.1.1.1"}, {Hostname: "lb.example.com"}, {IP: "2.2.2.2"}}
+	assert.Equal(t, []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "2.2.2.2"}},
+		Ports:     []v1.EndpointPort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP}},
+	}}, loadBalancerSubsets(svc))
+}